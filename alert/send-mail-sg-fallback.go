@@ -2,8 +2,8 @@ package alert
 
 import (
 	"TUFWGo/audit"
-	"TUFWGo/system/local"
 	"TUFWGo/system/ssh"
+	"TUFWGo/system/userdir"
 	"TUFWGo/ufw"
 	"bufio"
 	"context"
@@ -29,7 +29,7 @@ func loadEmailsSG() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to determine user home directory: %w", err)
 	}*/
-	home := local.GlobalUserHomeDir
+	home := userdir.GlobalUserHomeDir
 	properPath := filepath.Join(home, pathSG)
 
 	if _, err := os.Stat(properPath); errors.Is(err, os.ErrNotExist) {
@@ -98,7 +98,7 @@ func batchesSG[T any](in []T, n int) [][]T {
 	return out
 }
 
-func addAuditSG(action, result, comment, errMsg string) {
+func addAuditSG(action audit.Action, result, comment, errMsg string) {
 	auditor, actor := audit.GetGlobalAuditor()
 	if auditor == nil {
 		return
@@ -121,7 +121,7 @@ func (e *EmailInfo) SendMailSG(action, cmd string, rule *ufw.Form) {
 	apiKey := os.Getenv("SENDGRID_API_KEY")
 	if apiKey == "" {
 		fmt.Println("SENDGRID_API_KEY environment variable not set")
-		addAuditSG("email.api", "error", "", "WARNING: SendGrid API key not set")
+		addAuditSG(audit.ActionEmailAPI, "error", "", "WARNING: SendGrid API key not set")
 		return
 	}
 	client := sendgrid.NewSendClient(apiKey)
@@ -129,12 +129,12 @@ func (e *EmailInfo) SendMailSG(action, cmd string, rule *ufw.Form) {
 	recips, err := loadEmailsSG()
 	if err != nil {
 		fmt.Println(err)
-		addAuditSG("email.recipients", "error", "WARNING: Unable to load email list:", err.Error())
+		addAuditSG(audit.ActionEmailRecipients, "error", "WARNING: Unable to load email list:", err.Error())
 		return
 	}
 	if len(recips) == 0 {
 		fmt.Println("WARNING: No emails found")
-		addAuditSG("email.recipients", "warning", "No email recipients found, skipping email alert", "")
+		addAuditSG(audit.ActionEmailRecipients, "warning", "No email recipients found, skipping email alert", "")
 		return
 	}
 
@@ -173,16 +173,16 @@ func (e *EmailInfo) SendMailSG(action, cmd string, rule *ufw.Form) {
 		response, clientErr := client.SendWithContext(ctx, msg)
 		if clientErr != nil {
 			fmt.Println(clientErr)
-			addAuditSG("email.send", "error", "Send Failed", clientErr.Error())
+			addAuditSG(audit.ActionEmailSend, "error", "Send Failed", clientErr.Error())
 			return
 		}
 		if response.StatusCode >= 400 {
 			fmt.Printf("status %d, body: %s", response.StatusCode, response.Body)
-			addAuditSG("email.send", "error", "Send Failed", fmt.Sprintf("status %d, body: %s", response.StatusCode, response.Body))
+			addAuditSG(audit.ActionEmailSend, "error", "Send Failed", fmt.Sprintf("status %d, body: %s", response.StatusCode, response.Body))
 			return
 		}
 
-		fmt.Printf("Email sent successfully to %s recipients", len(batch))
-		addAuditSG("email.send", "success", "Send Succeeded", fmt.Sprintf("number of recipients: %d, status: %d", len(batch), response.StatusCode))
+		fmt.Printf("Email sent successfully to %d recipients", len(batch))
+		addAuditSG(audit.ActionEmailSend, "success", "Send Succeeded", fmt.Sprintf("number of recipients: %d, status: %d", len(batch), response.StatusCode))
 	}
 }
@@ -2,8 +2,8 @@ package alert
 
 import (
 	"TUFWGo/audit"
-	"TUFWGo/system/local"
 	"TUFWGo/system/ssh"
+	"TUFWGo/system/userdir"
 	"TUFWGo/ufw"
 	"bufio"
 	"context"
@@ -25,7 +25,7 @@ var from string
 const path = ".config/tufwgo/emails.txt"
 
 func loadEmails() ([]string, error) {
-	home := local.GlobalUserHomeDir
+	home := userdir.GlobalUserHomeDir
 	properPath := filepath.Join(home, path)
 
 	if _, err := os.Stat(properPath); errors.Is(err, os.ErrNotExist) {
@@ -103,7 +103,7 @@ func mailersendCompatRecips(recips []string) []mailersend.Recipient {
 	return msRecips
 }
 
-func addAudit(action, result, comment, errMsg string) {
+func addAudit(action audit.Action, result, comment, errMsg string) {
 	auditor, actor := audit.GetGlobalAuditor()
 	if auditor == nil {
 		return
@@ -126,7 +126,7 @@ func (e *EmailInfo) SendMail(action, cmd string, rule *ufw.Form) {
 	apiKey := os.Getenv("MAILERSEND_API_KEY")
 	if apiKey == "" {
 		fmt.Println("$MAILERSEND_API_KEY environment variable not set")
-		addAudit("email.api", "error", "", "WARNING: MailerSend API key not set")
+		addAudit(audit.ActionEmailAPI, "error", "", "WARNING: MailerSend API key not set")
 		return
 	}
 	client := mailersend.NewMailersend(apiKey)
@@ -134,12 +134,12 @@ func (e *EmailInfo) SendMail(action, cmd string, rule *ufw.Form) {
 	recips, err := loadEmails()
 	if err != nil {
 		fmt.Println(err)
-		addAudit("email.recipients", "error", "WARNING: Unable to load email list:", err.Error())
+		addAudit(audit.ActionEmailRecipients, "error", "WARNING: Unable to load email list:", err.Error())
 		return
 	}
 	if len(recips) == 0 {
 		fmt.Println("WARNING: No emails found")
-		addAudit("email.recipients", "warning", "No email recipients found, skipping email alert", "")
+		addAudit(audit.ActionEmailRecipients, "warning", "No email recipients found, skipping email alert", "")
 		return
 	}
 
@@ -181,15 +181,15 @@ func (e *EmailInfo) SendMail(action, cmd string, rule *ufw.Form) {
 			response, clientErr := client.Email.Send(ctx, msg)
 			if clientErr != nil {
 				fmt.Println(clientErr)
-				addAudit("email.send", "error", "Send Failed", clientErr.Error())
+				addAudit(audit.ActionEmailSend, "error", "Send Failed", clientErr.Error())
 			}
 			if response.StatusCode >= 400 {
 				fmt.Printf("status %d, body: %s\n", response.StatusCode, response.Body)
-				addAudit("email.send", "error", "Send Failed", fmt.Sprintf("status %d, body: %s", response.StatusCode, response.Body))
+				addAudit(audit.ActionEmailSend, "error", "Send Failed", fmt.Sprintf("status %d, body: %s", response.StatusCode, response.Body))
 			}
 
 			fmt.Printf("Email sent successfully to recipient %s\n", r.Email)
-			addAudit("email.send", "success", "Send Succeeded", fmt.Sprintf("recipient %s, status: %d", r.Email, response.StatusCode))
+			addAudit(audit.ActionEmailSend, "success", "Send Succeeded", fmt.Sprintf("recipient %s, status: %d", r.Email, response.StatusCode))
 		}
 	}
 }
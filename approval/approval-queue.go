@@ -0,0 +1,89 @@
+package approval
+
+import (
+	"TUFWGo/system/userdir"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var queueDir string
+
+// SetQueueDir points the queue at another directory, e.g. one shared between engineers
+func SetQueueDir(dir string) {
+	queueDir = dir
+}
+
+func QueueDir() string {
+	if queueDir != "" {
+		return queueDir
+	}
+	return filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "approvals")
+}
+
+// Save writes the request into the queue and returns its path
+func Save(r *Request) (string, error) {
+	path := filepath.Join(QueueDir(), r.ID+".json")
+	return path, SaveTo(path, r)
+}
+
+// SaveTo writes the request to path, replacing any earlier copy in one step
+func SaveTo(path string, r *Request) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load finds a request by id in the queue, or reads it from a file path
+func Load(ref string) (*Request, string, error) {
+	path := ref
+	if !strings.Contains(ref, "/") && !strings.HasSuffix(ref, ".json") {
+		path = filepath.Join(QueueDir(), ref+".json")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("no approval request %s in %s", ref, filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var r Request
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, "", fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	if len(r.Commands) == 0 {
+		return nil, "", fmt.Errorf("%s has no commands", path)
+	}
+	return &r, path, nil
+}
+
+// List returns every request in the queue, oldest first
+func List() ([]*Request, error) {
+	files, err := filepath.Glob(filepath.Join(QueueDir(), "apr-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []*Request
+	for _, f := range files {
+		r, _, err := Load(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ProposedAt < out[j].ProposedAt })
+	return out, nil
+}
@@ -0,0 +1,305 @@
+package approval
+
+import (
+	"TUFWGo/auth"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	proposeTag = "TUFWGO-PROPOSE\x00"
+	approveTag = "TUFWGO-APPROVE\x00"
+	rejectTag  = "TUFWGO-REJECT\x00"
+)
+
+// DefaultTTL is how long a proposal can wait for approval and be applied
+const DefaultTTL = 24 * time.Hour
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+	StatusApplied  Status = "applied"
+)
+
+// Request is a destructive change waiting for a second controller. The proposer signs the change
+// itself; the approver signs the proposal together with the proposer's signature.
+type Request struct {
+	ID       string   `json:"id"`
+	Action   string   `json:"action"` // audit action, e.g. ufw.delete or profile.execute
+	Targets  []string `json:"targets"`
+	HostKeys []string `json:"host_keys"` // SHA256 fingerprints of the targets' SSH host keys
+	Commands []string `json:"commands"`
+	Reason   string   `json:"reason,omitempty"`
+	// Rule is the rule text a numbered delete is expected to remove, so a renumbered ruleset is caught
+	Rule string `json:"rule,omitempty"`
+
+	ProposedBy  string `json:"proposed_by"`
+	ProposerPub string `json:"proposer_pub"`
+	ProposedAt  string `json:"proposed_at"`
+	Expires     string `json:"expires"`
+	ProposerSig string `json:"proposer_sig"`
+
+	ApprovedBy  string `json:"approved_by,omitempty"`
+	ApproverPub string `json:"approver_pub,omitempty"`
+	ApprovedAt  string `json:"approved_at,omitempty"`
+	ApproverSig string `json:"approver_sig,omitempty"`
+
+	Status      Status   `json:"status"`
+	RejectedBy  string   `json:"rejected_by,omitempty"`
+	RejectorPub string   `json:"rejector_pub,omitempty"`
+	RejectorSig string   `json:"rejector_sig,omitempty"`
+	Applied     []string `json:"applied,omitempty"` // targets the change has been made on
+}
+
+// NeedsApproval reports whether any command deletes rules or changes default policy
+func NeedsApproval(cmds []string) bool {
+	for _, c := range cmds {
+		switch auth.PermissionForCommand(c) {
+		case auth.PermDelete, auth.PermDefaults:
+			return true
+		}
+	}
+	return false
+}
+
+// New drafts a pending request; set Reason or Rule before it is proposed
+func New(action string, targets, cmds []string, ttl time.Duration) (*Request, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Request{
+		ID:         "apr-" + hex.EncodeToString(id),
+		Action:     action,
+		Targets:    targets,
+		Commands:   cmds,
+		ProposedAt: now.Format(time.RFC3339),
+		Expires:    now.Add(ttl).Format(time.RFC3339),
+		Status:     StatusPending,
+	}, nil
+}
+
+// Propose signs the draft with the proposing controller's key
+func (r *Request) Propose(controllerID string, priv ed25519.PrivateKey) error {
+	pub := priv.Public().(ed25519.PublicKey)
+	if auth.ControllerID(pub) != controllerID {
+		return errors.New("controller id does not match its key")
+	}
+	r.ProposedBy = controllerID
+	r.ProposerPub = base64.StdEncoding.EncodeToString(pub)
+	r.ProposerSig = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, r.proposalMessage()))
+	return nil
+}
+
+// Approve co-signs a pending request. The approver must hold a different controller key.
+func (r *Request) Approve(controllerID string, priv ed25519.PrivateKey) error {
+	if r.Status != StatusPending {
+		return fmt.Errorf("request %s is %s, not pending", r.ID, r.Status)
+	}
+	if err := r.checkProposal(time.Now()); err != nil {
+		return err
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	if controllerID == r.ProposedBy || auth.ControllerID(pub) == r.ProposedBy {
+		return errors.New("a change can't be approved by the controller that proposed it")
+	}
+	r.ApprovedBy = controllerID
+	r.ApproverPub = base64.StdEncoding.EncodeToString(pub)
+	r.ApprovedAt = time.Now().UTC().Format(time.RFC3339)
+	r.ApproverSig = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, r.approvalMessage()))
+	r.Status = StatusApproved
+	return nil
+}
+
+// Reject signs the rejection, so hosts can be told about it and refuse the change even if the file is edited back
+func (r *Request) Reject(controllerID string, priv ed25519.PrivateKey) error {
+	pub := priv.Public().(ed25519.PublicKey)
+	if auth.ControllerID(pub) != controllerID {
+		return errors.New("controller id does not match its key")
+	}
+	r.Status = StatusRejected
+	r.RejectedBy = controllerID
+	r.RejectorPub = base64.StdEncoding.EncodeToString(pub)
+	r.RejectorSig = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, r.rejectionMessage()))
+	return nil
+}
+
+// VerifyRejection checks the rejection signature. Hosts still check that the rejector is one of their controllers.
+func (r *Request) VerifyRejection() error {
+	if r.RejectorSig == "" {
+		return fmt.Errorf("request %s has no signed rejection", r.ID)
+	}
+	pub, err := decodeKey(r.RejectorPub, r.RejectedBy)
+	if err != nil {
+		return fmt.Errorf("rejector: %w", err)
+	}
+	if !verifySig(pub, r.rejectionMessage(), r.RejectorSig) {
+		return errors.New("invalid rejector signature")
+	}
+	return nil
+}
+
+// Verify checks that the request carries valid signatures from two different controllers and
+// hasn't expired. It does not say whether those controllers are trusted; hosts check that.
+func (r *Request) Verify(now time.Time) error {
+	if r.Status != StatusApproved && r.Status != StatusApplied {
+		return fmt.Errorf("request %s is %s, not approved", r.ID, r.Status)
+	}
+	// The status isn't signed, so a signed rejection counts whatever the status says
+	if r.RejectorSig != "" && r.VerifyRejection() == nil {
+		return fmt.Errorf("request %s was rejected by %s", r.ID, r.RejectedBy)
+	}
+	if err := r.checkProposal(now); err != nil {
+		return err
+	}
+	pub, err := decodeKey(r.ApproverPub, r.ApprovedBy)
+	if err != nil {
+		return fmt.Errorf("approver: %w", err)
+	}
+	if r.ApprovedBy == r.ProposedBy {
+		return errors.New("proposer and approver are the same controller")
+	}
+	if !verifySig(pub, r.approvalMessage(), r.ApproverSig) {
+		return errors.New("invalid approver signature")
+	}
+	return nil
+}
+
+func (r *Request) checkProposal(now time.Time) error {
+	if len(r.Commands) == 0 {
+		return fmt.Errorf("request %s has no commands", r.ID)
+	}
+	if len(r.HostKeys) == 0 {
+		return fmt.Errorf("request %s is not bound to any host key", r.ID)
+	}
+	expires, err := time.Parse(time.RFC3339, r.Expires)
+	if err != nil {
+		return fmt.Errorf("bad expiry: %w", err)
+	}
+	if !now.Before(expires) {
+		return fmt.Errorf("request %s expired at %s", r.ID, r.Expires)
+	}
+	pub, err := decodeKey(r.ProposerPub, r.ProposedBy)
+	if err != nil {
+		return fmt.Errorf("proposer: %w", err)
+	}
+	if !verifySig(pub, r.proposalMessage(), r.ProposerSig) {
+		return errors.New("invalid proposer signature")
+	}
+	return nil
+}
+
+// Permissions lists what the request's commands need from a controller role, without repeats
+func (r *Request) Permissions() []auth.Permission {
+	var perms []auth.Permission
+	seen := map[auth.Permission]bool{}
+	for _, c := range r.Commands {
+		p := auth.PermissionForCommand(c)
+		if !seen[p] {
+			seen[p] = true
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// Summary is a one line description for listings
+func (r *Request) Summary() string {
+	switch len(r.Commands) {
+	case 0:
+		return "(no commands)"
+	case 1:
+		return r.Commands[0]
+	}
+	return fmt.Sprintf("%d commands: %s ...", len(r.Commands), r.Commands[0])
+}
+
+func (r *Request) proposalMessage() []byte {
+	var msg []byte
+	put := func(s string) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+		msg = append(msg, n[:]...)
+		msg = append(msg, s...)
+	}
+	putList := func(list []string) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(list)))
+		msg = append(msg, n[:]...)
+		for _, s := range list {
+			put(s)
+		}
+	}
+	msg = append(msg, proposeTag...)
+	put(r.ID)
+	put(r.Action)
+	putList(r.Targets)
+	putList(r.HostKeys)
+	putList(r.Commands)
+	put(r.Reason)
+	put(r.Rule)
+	put(r.ProposedBy)
+	put(r.ProposerPub)
+	put(r.ProposedAt)
+	put(r.Expires)
+	return msg
+}
+
+// approvalMessage covers the whole proposal and its signature, so an approval can't be moved to another change
+func (r *Request) approvalMessage() []byte {
+	msg := append([]byte(approveTag), r.proposalMessage()...)
+	for _, s := range []string{r.ProposerSig, r.ApprovedBy, r.ApproverPub, r.ApprovedAt} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+		msg = append(msg, n[:]...)
+		msg = append(msg, s...)
+	}
+	return msg
+}
+
+// rejectionMessage names the proposal by its signature, which already covers every field of the change
+func (r *Request) rejectionMessage() []byte {
+	msg := []byte(rejectTag)
+	for _, s := range []string{r.ID, r.ProposerSig, r.RejectedBy, r.RejectorPub} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+		msg = append(msg, n[:]...)
+		msg = append(msg, s...)
+	}
+	return msg
+}
+
+// decodeKey parses a public key and checks it is the one the controller id was derived from
+func decodeKey(b64, controllerID string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(b64, "ed25519:"))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bad public key length: %d", len(raw))
+	}
+	pub := ed25519.PublicKey(raw)
+	if auth.ControllerID(pub) != controllerID {
+		return nil, fmt.Errorf("public key does not belong to %s", controllerID)
+	}
+	return pub, nil
+}
+
+func verifySig(pub ed25519.PublicKey, msg []byte, sigB64 string) bool {
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, msg, sig)
+}
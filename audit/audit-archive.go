@@ -0,0 +1,471 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	hmac2 "crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	archiveDirName  = "archive"
+	manifestName    = "manifest.json"
+	manifestVersion = 1
+)
+
+// Retention controls how long daily logs stay as they are. Logs older than CompressAfter days
+// are gzipped into the archive; archived logs older than Retain days are deleted, leaving their
+// final hashes in the manifest. Zero turns either step off.
+type Retention struct {
+	CompressAfter int
+	Retain        int
+}
+
+var retention = Retention{CompressAfter: 30}
+
+func SetRetention(r Retention) {
+	retention = r
+}
+
+// ArchiveDir is where compressed logs and their manifest are kept inside an audit directory
+func ArchiveDir(auditDir string) string {
+	return filepath.Join(auditDir, archiveDirName)
+}
+
+// ArchivedDay is the manifest record for one archived daily log
+type ArchivedDay struct {
+	Log             string `json:"log"`
+	File            string `json:"file,omitempty"` // empty once pruned
+	SHA256          string `json:"sha256"`         // of the uncompressed log
+	Entries         uint64 `json:"entries"`
+	PrevLogLastHash string `json:"prev_log_last_hash"`
+	LastHash        string `json:"last_hash"`
+	// NextLink is the hash the following log continues from, when that isn't LastHash
+	NextLink string    `json:"next_link,omitempty"`
+	Sealed   []Problem `json:"sealed,omitempty"`
+	Archived string    `json:"archived"`
+	Pruned   string    `json:"pruned,omitempty"`
+}
+
+func (d ArchivedDay) linksTo(hash string) bool {
+	return hash != "" && (hash == d.LastHash || hash == d.NextLink)
+}
+
+// Manifest lists the archived days oldest first. It is HMAC'd with the audit key so the
+// record of pruned days can't be edited.
+type Manifest struct {
+	Version int           `json:"version"`
+	Days    []ArchivedDay `json:"days"`
+	HMAC    string        `json:"hmac"`
+	KeyID   string        `json:"key_id,omitempty"`
+	SignKey string        `json:"sign_key,omitempty"`
+	Sig     string        `json:"sig,omitempty"`
+}
+
+func (m *Manifest) body() []byte {
+	body, _ := json.Marshal(struct {
+		Version int           `json:"version"`
+		Days    []ArchivedDay `json:"days"`
+	}{m.Version, m.Days})
+	return body
+}
+
+func (m *Manifest) mac(key []byte) string {
+	h := hmac2.New(sha256.New, key)
+	h.Write(m.body())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *Manifest) macOK(v Verifier) bool {
+	for _, k := range v.hmacKeys(m.KeyID) {
+		if hmac2.Equal([]byte(m.HMAC), []byte(m.mac(k))) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadManifest reads and checks the manifest in an archive directory. A missing manifest reads as
+// an empty archive, but logs started after archiving name it in their header, so verifyChain
+// reports it gone. One that lists days must carry an HMAC, and a signature when v has a public key.
+func LoadManifest(archiveDir string, v Verifier) (*Manifest, error) {
+	m, err := readManifest(archiveDir)
+	if err != nil || (m.HMAC == "" && len(m.Days) == 0) {
+		return m, err
+	}
+	if m.HMAC == "" {
+		return nil, errors.New("archive manifest has no HMAC")
+	}
+	if v.hasHMAC() && !m.macOK(v) {
+		return nil, errors.New("archive manifest HMAC is invalid")
+	}
+	if v.PublicKey != nil && m.SignKey == "" {
+		return nil, errors.New("archive manifest is not signed")
+	}
+	signKey, reason := v.headerKey(m.SignKey)
+	if reason != "" {
+		return nil, errors.New("archive manifest: " + reason)
+	}
+	if signKey != nil && !verifySig(signKey, manifestSigTag, m.body(), m.Sig) {
+		return nil, errors.New("archive manifest signature is invalid")
+	}
+	return m, nil
+}
+
+// signLegacyManifest signs a manifest written before audit signing keys existed, once its HMAC
+// shows the days are as they were archived
+func signLegacyManifest(archiveDir string, keys Keys) {
+	m, err := readManifest(archiveDir)
+	if err != nil || keys.Signer == nil || m.SignKey != "" || m.HMAC == "" || !m.macOK(Verifier{HMACKey: keys.HMAC, Keyring: keys.Ring}) {
+		return
+	}
+	_ = m.save(archiveDir, keys)
+}
+
+func readManifest(archiveDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(archiveDir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Version: manifestVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("archive manifest version %d is newer than this TUFWGo supports", m.Version)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) save(archiveDir string, keys Keys) error {
+	m.HMAC, m.KeyID = m.mac(keys.HMAC), KeyID(keys.HMAC)
+	m.SignKey, m.Sig = "", ""
+	if keys.Signer != nil {
+		m.SignKey = EncodePublicKey(keys.Signer.Public().(ed25519.PublicKey))
+		m.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(keys.Signer, append([]byte(manifestSigTag), m.body()...)))
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(archiveDir, manifestName), append(data, '\n'))
+}
+
+// lists reports whether the manifest records an archived log, pruned or not
+func (m *Manifest) lists(log string) bool {
+	for _, d := range m.Days {
+		if d.Log == log {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manifest) last() (ArchivedDay, bool) {
+	if len(m.Days) == 0 {
+		return ArchivedDay{}, false
+	}
+	return m.Days[len(m.Days)-1], true
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ArchiveReport says what a retention run did
+type ArchiveReport struct {
+	Archived []string
+	Pruned   []string
+	// Held is the first old log that was kept live, and why; later logs wait behind it
+	Held string
+}
+
+// Archive applies the retention policy to dir as of now. Logs are archived oldest first and
+// only while their problems, if any, are sealed, so the archive is always the start of the chain.
+// The newest log is never archived.
+func Archive(dir string, keys Keys, r Retention, now time.Time, dryRun bool) (*ArchiveReport, error) {
+	archiveDir := ArchiveDir(dir)
+	m, err := LoadManifest(archiveDir, keys.Verifier())
+	if err != nil {
+		return nil, err
+	}
+	report := &ArchiveReport{}
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	if !dryRun {
+		if err = removeArchivedLeftovers(dir, m); err != nil {
+			return nil, err
+		}
+	}
+	if r.CompressAfter > 0 {
+		links, chains, _, err := verifyChain(dir, keys.Verifier(), m)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		cutoff := today.AddDate(0, 0, -r.CompressAfter)
+		for i := 0; i < len(links)-1; i++ {
+			l := links[i]
+			if !l.Day.Before(cutoff) {
+				break
+			}
+			if !l.OK() {
+				p, _ := FirstBroken(links[i : i+1])
+				report.Held = fmt.Sprintf("%s has an unsealed problem: %s", filepath.Base(l.Path), p.Detail)
+				break
+			}
+			if chains[i].hdr == nil {
+				report.Held = fmt.Sprintf("%s has no readable header", filepath.Base(l.Path))
+				break
+			}
+			if !dryRun {
+				if err = os.MkdirAll(archiveDir, 0700); err != nil {
+					return report, err
+				}
+				if err = archiveLog(m, archiveDir, keys, l, chains[i], chains[i+1], now); err != nil {
+					return report, fmt.Errorf("failed to archive %s: %w", filepath.Base(l.Path), err)
+				}
+			}
+			report.Archived = append(report.Archived, filepath.Base(l.Path))
+		}
+	}
+
+	if r.Retain > 0 {
+		cutoff := today.AddDate(0, 0, -r.Retain)
+		for i, d := range m.Days {
+			day, err := time.Parse(dailyLogLayout, d.Log)
+			if err != nil || d.File == "" || !day.Before(cutoff) {
+				continue
+			}
+			if !dryRun {
+				if err = os.Remove(filepath.Join(archiveDir, d.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return report, err
+				}
+				m.Days[i].File = ""
+				m.Days[i].Pruned = now.Format("2006-01-02 15:04:05")
+				if err = m.save(archiveDir, keys); err != nil {
+					return report, err
+				}
+			}
+			report.Pruned = append(report.Pruned, d.Log)
+		}
+	}
+	return report, nil
+}
+
+// removeArchivedLeftovers deletes live logs that a crash left behind after they were archived
+func removeArchivedLeftovers(dir string, m *Manifest) error {
+	for _, d := range m.Days {
+		p := filepath.Join(dir, d.Log)
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != d.SHA256 {
+			return fmt.Errorf("%s was archived with different contents", d.Log)
+		}
+		if err = os.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveLog compresses one verified log, records it in the manifest and only then removes it
+func archiveLog(m *Manifest, archiveDir string, keys Keys, l ChainLink, fc, next *fileChain, now time.Time) error {
+	name := filepath.Base(l.Path)
+	data, err := os.ReadFile(l.Path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Name = name
+	if _, err = zw.Write(data); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	file := name + ".gz"
+	if err = writeFileAtomic(filepath.Join(archiveDir, file), gz.Bytes()); err != nil {
+		return err
+	}
+
+	d := ArchivedDay{
+		Log:             name,
+		File:            file,
+		SHA256:          hex.EncodeToString(sum[:]),
+		Entries:         fc.result.LastIndex,
+		PrevLogLastHash: fc.hdr.PrevLogLastHash,
+		LastHash:        fc.result.LastHashHex,
+		Sealed:          l.Problems,
+		Archived:        now.Format("2006-01-02 15:04:05"),
+	}
+	if next.hdr != nil && next.hdr.PrevLogLastHash != d.LastHash {
+		if _, ok := fc.hashes[next.hdr.PrevLogLastHash]; ok {
+			d.NextLink = next.hdr.PrevLogLastHash
+		}
+	}
+	m.Days = append(m.Days, d)
+	if err = m.save(archiveDir, keys); err != nil {
+		return err
+	}
+	return os.Remove(l.Path)
+}
+
+func openArchived(archiveDir string, d ArchivedDay) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(archiveDir, d.File))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, file}, nil
+}
+
+// ArchiveCheck is the offline verification result for one archived day
+type ArchiveCheck struct {
+	ArchivedDay
+	OK     bool
+	Reason string
+}
+
+// VerifyArchive checks an archive directory on its own, e.g. a copy taken off the machine: the
+// manifest HMAC, each remaining file against its recorded hash and chain, and the links between
+// days, including days whose files have been pruned.
+func VerifyArchive(archiveDir string, v Verifier) ([]ArchiveCheck, bool, error) {
+	m, err := LoadManifest(archiveDir, v)
+	if err != nil {
+		return nil, false, err
+	}
+	if m.HMAC == "" && len(m.Days) == 0 {
+		return nil, false, fmt.Errorf("no archive manifest in %s", archiveDir)
+	}
+
+	allOK := true
+	checks := make([]ArchiveCheck, len(m.Days))
+	for i, d := range m.Days {
+		reason := ""
+		if i > 0 && !m.Days[i-1].linksTo(d.PrevLogLastHash) && !sealedKind(d.Sealed, linkProblems...) {
+			reason = "does not link to " + m.Days[i-1].Log
+		}
+		if d.File != "" && reason == "" {
+			reason = checkArchivedFile(archiveDir, v, d)
+		}
+		checks[i] = ArchiveCheck{ArchivedDay: d, OK: reason == "", Reason: reason}
+		if reason != "" {
+			allOK = false
+		}
+	}
+	return checks, allOK, nil
+}
+
+var linkProblems = []string{ProblemBrokenLink, ProblemMissing, ProblemTruncated, ProblemReordered}
+
+func sealedKind(sealed []Problem, kinds ...string) bool {
+	for _, p := range sealed {
+		for _, k := range kinds {
+			if p.Kind == k {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkArchivedFile(archiveDir string, v Verifier, d ArchivedDay) string {
+	rc, err := openArchived(archiveDir, d)
+	if err != nil {
+		return err.Error()
+	}
+	defer rc.Close()
+	h := sha256.New()
+	fc, err := scanChainFrom(io.TeeReader(rc, h), v)
+	if err != nil {
+		return err.Error()
+	}
+	// Hash whatever the scan stopped short of
+	if _, err = io.Copy(h, rc); err != nil {
+		return err.Error()
+	}
+
+	switch {
+	case hex.EncodeToString(h.Sum(nil)) != d.SHA256:
+		return "contents do not match the manifest"
+	case fc.hdr == nil || fc.hdr.PrevLogLastHash != d.PrevLogLastHash:
+		return "header does not match the manifest"
+	}
+	if !fc.result.OK {
+		tampered := Problem{Log: d.Log, Kind: ProblemTampered, Detail: fmt.Sprintf("line %d: %s", fc.result.FailedLine, fc.result.Reason)}
+		sealed := false
+		for _, p := range d.Sealed {
+			sealed = sealed || (p.Kind == tampered.Kind && p.Detail == tampered.Detail)
+		}
+		if !sealed {
+			return tampered.Detail
+		}
+	}
+	if fc.result.LastHashHex != d.LastHash || fc.result.LastIndex != d.Entries {
+		return "last hash does not match the manifest"
+	}
+	if d.NextLink != "" {
+		if _, ok := fc.hashes[d.NextLink]; !ok {
+			return "next log's link is not in this log"
+		}
+	}
+	return ""
+}
+
+// readArchivedEntries returns the entries of an archived day; pruned days have none
+func readArchivedEntries(archiveDir string, d ArchivedDay) ([]Entry, error) {
+	if d.File == "" {
+		return nil, nil
+	}
+	rc, err := openArchived(archiveDir, d)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readEntriesFrom(rc)
+}
@@ -1,14 +1,12 @@
 package audit
 
 import (
-	"TUFWGo/system/local"
+	"TUFWGo/system/userdir"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
 	"time"
 )
 
@@ -27,73 +25,95 @@ func loadAuditKey() ([]byte, error) {
 	return key, nil
 }
 
+// AuditDir is where the daily logs are kept
+func AuditDir() string {
+	return filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "audit")
+}
+
+// OpenDailyAuditLog opens today's log. A new day's log continues the chain from the last one
+// before it, live or archived. Problems found in earlier logs don't stop TUFWGo from starting,
+// but they are left unsealed (see Log.Unsealed) until someone runs "tufwgo audit seal". Starting
+// a new day's log also applies the retention policy.
 func OpenDailyAuditLog() (*Log, error) {
-	/*cfgDir, err := os.UserConfigDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user config dir: %w", err)
-	}*/
-	cfgDir := local.GlobalUserCfgDir
-	auditDir := filepath.Join(cfgDir, "tufwgo", "audit")
-	logPath := filepath.Join(auditDir, time.Now().UTC().Format("audit-2006-01-02.log"))
-	key, err := loadAuditKey()
+	auditDir := AuditDir()
+	logPath := filepath.Join(auditDir, time.Now().UTC().Format(dailyLogLayout))
+	keys, err := LoadKeys()
 	if err != nil {
 		return nil, err
 	}
 
-	prevHash, err := prevFileLastHash(auditDir, key, time.Now().UTC())
-	if err != nil {
-		return nil, err
+	if st, err := os.Stat(logPath); err == nil && st.Size() > 0 {
+		return OpenSigned(logPath, keys, "")
 	}
 
-	return Open(logPath, key, prevHash)
-}
-
-func findPreviousLog(auditDir string, day time.Time) (string, error) {
-	entries, err := os.ReadDir(auditDir)
-	if err != nil {
-		return "", err
+	signLegacyManifest(ArchiveDir(auditDir), keys)
+	m, manifestErr := LoadManifest(ArchiveDir(auditDir), keys.Verifier())
+	if manifestErr != nil {
+		m = &Manifest{}
 	}
-
-	prefix := "audit-"
-	suffix := ".log"
-	var candidates []string
-	for _, e := range entries {
-		if e.IsDir() {
+	links, chains, _, err := verifyChain(auditDir, keys.Verifier(), m)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to verify previous logs: %w", err)
+	}
+	var prev prevLink
+	archive, archived := "", false
+	if d, ok := m.last(); ok {
+		prev = prevLink{log: d.Log, lastHash: d.LastHash, lastIndex: d.Entries}
+		archive, archived = d.Log, true
+	}
+	var unsealed []Problem
+	for i, l := range links {
+		if filepath.Base(l.Path) == filepath.Base(logPath) {
 			continue
 		}
-		name := e.Name()
-		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
-			dateStr := strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
-			date, err := time.Parse("2006-01-02", dateStr)
-			if err == nil && date.Before(day.Truncate(24*time.Hour)) {
-				candidates = append(candidates, filepath.Join(auditDir, name))
+		// Keep naming an archive whose manifest can't be read, so the gap stays reported
+		if !archived && chains[i].hdr != nil && chains[i].hdr.Archive != "" {
+			archive = chains[i].hdr.Archive
+		}
+		// Continue from the last entry that still verifies
+		prev = prevLink{log: filepath.Base(l.Path), lastHash: l.Result.LastHashHex, lastIndex: l.Result.LastIndex}
+		for _, p := range l.Problems {
+			if !p.Sealed {
+				unsealed = append(unsealed, p)
 			}
 		}
 	}
-	if len(candidates) == 0 {
-		return "", nil
-	}
-	sort.Strings(candidates)
-	return candidates[len(candidates)-1], nil
-}
 
-func prevFileLastHash(auditDir string, key []byte, day time.Time) (string, error) {
-	prevPath, err := findPreviousLog(auditDir, day)
+	prev.archive = archive
+	log, err := openLinked(logPath, keys, prev)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if prevPath == "" {
-		return "", nil
+	log.unsealed = unsealed
+	if manifestErr != nil {
+		_ = log.Append(&Entry{Actor: hostname(), Action: ActionAuditArchive, Result: "error", Error: manifestErr.Error()})
+	} else {
+		log.applyRetention(auditDir, keys)
 	}
+	return log, nil
+}
 
-	vrf, err := Verify(prevPath, key)
+// applyRetention archives and prunes old logs, recording what it did in the log itself
+func (l *Log) applyRetention(auditDir string, keys Keys) {
+	if retention == (Retention{}) {
+		return
+	}
+	report, err := Archive(auditDir, keys, retention, time.Now(), false)
 	if err != nil {
-		return "", fmt.Errorf("failed to verify previous log: %w", err)
+		_ = l.Append(&Entry{Actor: hostname(), Action: ActionAuditArchive, Result: "error", Error: err.Error()})
+		return
+	}
+	if len(report.Archived) == 0 && len(report.Pruned) == 0 {
+		return
+	}
+	var fields []Field
+	for _, a := range report.Archived {
+		fields = append(fields, Field{Name: "archived", Value: a})
 	}
-	if !vrf.OK {
-		return "", fmt.Errorf("prev log tampered (line %d: %s)", vrf.FailedLine, vrf.Reason)
+	for _, p := range report.Pruned {
+		fields = append(fields, Field{Name: "pruned", Value: p})
 	}
-	return vrf.LastHashHex, nil
+	_ = l.Append(&Entry{Actor: hostname(), Action: ActionAuditArchive, Result: "success", Fields: fields})
 }
 
 var globalAuditor *Log
@@ -0,0 +1,86 @@
+package audit
+
+import "fmt"
+
+// Kinds of problem VerifyChain reports
+const (
+	ProblemTampered   = "tampered"    // a line fails its hash or HMAC, or the file can't be read
+	ProblemTruncated  = "truncated"   // entries were cut from the end of a log the next one linked to
+	ProblemMissing    = "missing"     // a log the chain passes through has been deleted
+	ProblemReordered  = "reordered"   // logs were renamed or link out of order
+	ProblemBrokenLink = "broken_link" // a log's link matches nothing we have
+)
+
+// Problem is one break in the chain, found in the named log
+type Problem struct {
+	Log    string `json:"log"`
+	Kind   string `json:"problem"`
+	Detail string `json:"detail"`
+	Sealed bool   `json:"sealed"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Log, p.Detail, p.Kind)
+}
+
+// Seal records that problems in the chain have been seen and accepted. Each becomes a "break"
+// record in this log, chained and HMAC'd like any other entry, and VerifyChain reports that
+// problem as sealed from then on. A break only seals the exact problem it names: if the log
+// is damaged further, the new problem shows up unsealed.
+//
+// Recovery for a broken chain:
+//  1. Run "tufwgo audit verify" and keep a copy of the damaged logs for investigation.
+//  2. Run "tufwgo audit seal -reason <why>" to write the break records into today's log.
+//  3. Run "tufwgo audit verify" again; the problems show as SEALED and the chain verifies.
+//
+// A new daily log starts anyway but never seals anything itself; see Log.Unsealed.
+func (l *Log) Seal(actor, reason string, problems []Problem) error {
+	for _, p := range problems {
+		err := l.Append(&Entry{
+			Kind:   "break",
+			Actor:  actor,
+			Action: ActionAuditBreak,
+			Result: "sealed",
+			Error:  reason,
+			Fields: []Field{
+				{Name: "log", Value: p.Log},
+				{Name: "problem", Value: p.Kind},
+				{Name: "detail", Value: p.Detail},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unsealed lists the problems in earlier logs that nobody had sealed when this log was started
+func (l *Log) Unsealed() []Problem {
+	return l.unsealed
+}
+
+// Path is the file the log writes to
+func (l *Log) Path() string {
+	return l.path
+}
+
+func sealedBy(breaks []Entry, p Problem) bool {
+	for _, b := range breaks {
+		var log, kind, detail string
+		for _, f := range b.Fields {
+			switch f.Name {
+			case "log":
+				log = f.Value
+			case "problem":
+				kind = f.Value
+			case "detail":
+				detail = f.Value
+			}
+		}
+		if log == p.Log && kind == p.Kind && detail == p.Detail {
+			return true
+		}
+	}
+	return false
+}
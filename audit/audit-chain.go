@@ -0,0 +1,312 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Log files are named after the UTC day they were started on
+const dailyLogLayout = "audit-2006-01-02.log"
+
+// DailyLog is one day's log file in the audit directory
+type DailyLog struct {
+	Path string
+	Day  time.Time
+}
+
+// DailyLogs lists the daily logs in dir, oldest first
+func DailyLogs(dir string) ([]DailyLog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var logs []DailyLog
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		day, err := time.Parse(dailyLogLayout, e.Name())
+		if err != nil {
+			continue
+		}
+		logs = append(logs, DailyLog{Path: filepath.Join(dir, e.Name()), Day: day})
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].Day.Before(logs[j].Day) })
+	return logs, nil
+}
+
+// ChainLink is the verification result for one daily log, including its link to the log before it
+type ChainLink struct {
+	DailyLog
+	Result   *VerifyResult
+	Entries  uint64
+	Problems []Problem
+	// Note explains a link that is fine but unusual, such as a chain that continues from the archive
+	Note string
+}
+
+// OK reports whether the log and its link are intact, or every problem has been sealed
+func (l ChainLink) OK() bool {
+	for _, p := range l.Problems {
+		if !p.Sealed {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyChain verifies every daily log in dir and the hash links between them. Days without a log
+// are normal; a log only has to link to the last one before it. The result is false if any
+// problem has not been sealed with a break record.
+func VerifyChain(dir string, v Verifier) ([]ChainLink, bool, error) {
+	m, err := LoadManifest(ArchiveDir(dir), v)
+	if err != nil {
+		return nil, false, err
+	}
+	links, _, ok, err := verifyChain(dir, v, m)
+	return links, ok, err
+}
+
+// verifyChain also returns each file's chain, and checks the oldest live log against the archive
+func verifyChain(dir string, v Verifier, m *Manifest) ([]ChainLink, []*fileChain, bool, error) {
+	logs, err := DailyLogs(dir)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	chains := make([]*fileChain, len(logs))
+	links := make([]ChainLink, len(logs))
+	names := map[string]int{}
+	for i, l := range logs {
+		names[filepath.Base(l.Path)] = i
+		links[i] = ChainLink{DailyLog: l}
+		fc, err := scanChain(l.Path, v)
+		if err != nil {
+			fc = &fileChain{result: &VerifyResult{OK: false, Reason: err.Error()}}
+		}
+		chains[i] = fc
+		links[i].Result = fc.result
+		links[i].Entries = fc.result.LastIndex
+	}
+
+	var breaks []Entry
+	for i, fc := range chains {
+		breaks = append(breaks, fc.breaks...)
+		name := filepath.Base(logs[i].Path)
+		problem := func(kind, format string, args ...any) {
+			links[i].Problems = append(links[i].Problems, Problem{Log: name, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+		}
+
+		if !fc.result.OK {
+			if fc.result.FailedLine > 0 {
+				problem(ProblemTampered, "line %d: %s", fc.result.FailedLine, fc.result.Reason)
+			} else {
+				problem(ProblemTampered, "%s", fc.result.Reason)
+			}
+		}
+		if fc.hdr == nil {
+			continue
+		}
+		if created, err := time.ParseInLocation("2006-01-02 15:04:05", fc.hdr.Created, time.Local); err == nil {
+			if d := created.UTC().Sub(logs[i].Day); d < -24*time.Hour || d > 48*time.Hour {
+				problem(ProblemReordered, "named for %s but created %s", logs[i].Day.Format("2006-01-02"), fc.hdr.Created)
+			}
+		}
+		if fc.hdr.Archive != "" && !m.lists(fc.hdr.Archive) {
+			problem(ProblemMissing, "archived %s is no longer in the archive manifest", fc.hdr.Archive)
+		}
+		links[i].Note = checkLink(i, fc.hdr, logs, chains, names, m, problem)
+	}
+
+	allOK := true
+	for i := range links {
+		for j := range links[i].Problems {
+			links[i].Problems[j].Sealed = sealedBy(breaks, links[i].Problems[j])
+		}
+		if !links[i].OK() {
+			allOK = false
+		}
+	}
+	return links, chains, allOK, nil
+}
+
+// checkLink finds where a log's header says the chain continues from and reports anything but
+// the end, or an earlier entry, of the log right before it
+func checkLink(i int, hdr *header, logs []DailyLog, chains []*fileChain, names map[string]int, m *Manifest, problem func(kind, format string, args ...any)) string {
+	h := hdr.PrevLogLastHash
+	last, archived := m.last()
+	if h == "" {
+		if i > 0 {
+			problem(ProblemBrokenLink, "does not link to the previous log")
+		} else if archived {
+			problem(ProblemBrokenLink, "does not continue from archived %s", last.Log)
+		}
+		return ""
+	}
+	for j, fc := range chains {
+		idx, ok := fc.hashes[h]
+		if !ok {
+			continue
+		}
+		prevName := filepath.Base(logs[j].Path)
+		switch {
+		case j == i-1:
+			if idx < fc.result.LastIndex && fc.result.OK {
+				return fmt.Sprintf("%s continued after this log was started", prevName)
+			}
+			return ""
+		case j >= i:
+			problem(ProblemReordered, "links to %s, which should come after it", prevName)
+		default:
+			problem(ProblemReordered, "links to %s, skipping %d log(s) in between", prevName, i-1-j)
+		}
+		return ""
+	}
+
+	for _, d := range m.Days {
+		if !d.linksTo(h) {
+			continue
+		}
+		if i == 0 && d.Log == last.Log {
+			return "continues from archived " + d.Log
+		}
+		problem(ProblemReordered, "links to archived %s", d.Log)
+		return ""
+	}
+	if i == 0 && archived {
+		problem(ProblemBrokenLink, "does not continue from archived %s", last.Log)
+		return ""
+	}
+
+	// The hash isn't in any log we have, live or archived. Only the archive may remove old logs, so
+	// a predecessor it doesn't record was deleted.
+	if hdr.PrevLog != "" {
+		if j, ok := names[hdr.PrevLog]; !ok {
+			problem(ProblemMissing, "previous log %s is missing and the archive does not record it", hdr.PrevLog)
+			return ""
+		} else if prev := chains[j].result; prev.OK && hdr.PrevLogLastIndex > prev.LastIndex {
+			problem(ProblemTruncated, "%s ends at entry %d but this log linked to entry %d", hdr.PrevLog, prev.LastIndex, hdr.PrevLogLastIndex)
+			return ""
+		}
+	}
+	if i == 0 {
+		problem(ProblemMissing, "earlier logs are missing and the archive does not record them")
+		return ""
+	}
+	problem(ProblemBrokenLink, "previous log's last hash does not match")
+	return ""
+}
+
+// FirstBroken returns the earliest problem that hasn't been sealed
+func FirstBroken(links []ChainLink) (Problem, bool) {
+	for _, l := range links {
+		for _, p := range l.Problems {
+			if !p.Sealed {
+				return p, true
+			}
+		}
+	}
+	return Problem{}, false
+}
+
+// Filter selects entries for a query. Zero values match everything.
+type Filter struct {
+	From, To time.Time // To is exclusive
+	Actor    string    // substring of the actor
+	Action   string    // exact action, or a pattern such as "ufw.*"
+	Result   string
+}
+
+// EntryTime parses an entry's timestamp, which is written in local time
+func EntryTime(e Entry) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", e.Time, time.Local)
+}
+
+func (f Filter) Match(e Entry) bool {
+	if !f.From.IsZero() || !f.To.IsZero() {
+		t, err := EntryTime(e)
+		if err != nil {
+			return false
+		}
+		if !f.From.IsZero() && t.Before(f.From) {
+			return false
+		}
+		if !f.To.IsZero() && !t.Before(f.To) {
+			return false
+		}
+	}
+	if f.Actor != "" && !strings.Contains(e.Actor, f.Actor) {
+		return false
+	}
+	if f.Action != "" {
+		if ok, _ := path.Match(f.Action, string(e.Action)); !ok {
+			return false
+		}
+	}
+	if f.Result != "" && e.Result != f.Result {
+		return false
+	}
+	return true
+}
+
+// FoundEntry is a matching entry and the log file it came from
+type FoundEntry struct {
+	Entry
+	File string `json:"file"`
+}
+
+// Search returns the entries in dir, including archived days that haven't been pruned, that
+// match f, oldest first. Files whose day can't overlap the date range are skipped; the day in a
+// file name is when it was started.
+func Search(dir string, f Filter) ([]FoundEntry, error) {
+	var found []FoundEntry
+	add := func(entries []Entry, file string) {
+		for _, e := range entries {
+			if f.Match(e) {
+				found = append(found, FoundEntry{Entry: e, File: file})
+			}
+		}
+	}
+	// A log can keep growing past midnight if the process that opened it is still running
+	skip := func(day time.Time) bool {
+		return !f.To.IsZero() && day.After(f.To)
+	}
+
+	archiveDir := ArchiveDir(dir)
+	m, err := readManifest(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range m.Days {
+		day, err := time.Parse(dailyLogLayout, d.Log)
+		if err != nil || skip(day) {
+			continue
+		}
+		entries, err := readArchivedEntries(archiveDir, d)
+		if err != nil {
+			return found, fmt.Errorf("%s: %w", d.File, err)
+		}
+		add(entries, d.File)
+	}
+
+	logs, err := DailyLogs(dir)
+	if err != nil {
+		return found, err
+	}
+	for _, l := range logs {
+		if skip(l.Day) {
+			continue
+		}
+		entries, err := ReadEntries(l.Path)
+		if err != nil {
+			return found, err
+		}
+		add(entries, filepath.Base(l.Path))
+	}
+	return found, nil
+}
@@ -1,8 +1,9 @@
 package audit
 
 import (
-	"TUFWGo/ufw"
+	"TUFWGo/ufw/rules"
 	"bufio"
+	"crypto/ed25519"
 	hmac2 "crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -17,7 +18,9 @@ import (
 	"time"
 )
 
-const currentVersion = 1.0
+// SchemaVersion is the version of the entry format in the header of every new log. Version 2
+// added typed payloads; a log started by an older TUFWGo may have version 2 entries appended.
+const SchemaVersion = 2
 
 type header struct {
 	Kind            string `json:"kind"`
@@ -26,41 +29,72 @@ type header struct {
 	Host            string `json:"host"`
 	SeedHex         string `json:"seed"`
 	PrevLogLastHash string `json:"prev_log_last_hash"`
+	// Name and last index of the log linked to, so a missing or truncated log can be told apart from a forged one
+	PrevLog          string `json:"prev_log,omitempty"`
+	PrevLogLastIndex uint64 `json:"prev_log_last_index,omitempty"`
+	// Archive is the newest archived log when this one was started, so a deleted archive shows up
+	Archive string `json:"archive,omitempty"`
+	// SignKey is the public key every entry in the log is signed with; older logs only have HMACs
+	SignKey string `json:"sign_key,omitempty"`
 }
 type Field struct {
-	Name        string   `json:"name"`
-	Value       string   `json:"value"`
-	Rule        ufw.Form `json:"rule,omitempty"`
-	DeletedRule string   `json:"deleted_rule,omitempty"`
+	Name    string          `json:"name"`
+	Value   string          `json:"value"`
+	Ruleset *rules.Snapshot `json:"ruleset,omitempty"`
 }
 type Entry struct {
-	Kind        string   `json:"kind"`
-	Index       uint64   `json:"index"`
-	Time        string   `json:"time"`
-	Actor       string   `json:"actor"`
-	Action      string   `json:"action"`
-	Command     string   `json:"command,omitempty"`
-	ProfCommand []string `json:"prof_command,omitempty"`
-	Result      string   `json:"result"`
-	Error       string   `json:"error,omitempty"`
-	Fields      []Field  `json:"fields,omitempty"`
+	Kind        string          `json:"kind"`
+	Index       uint64          `json:"index"`
+	Time        string          `json:"time"`
+	Actor       string          `json:"actor"`
+	Action      Action          `json:"action"`
+	Command     string          `json:"command,omitempty"`
+	ProfCommand []string        `json:"prof_command,omitempty"`
+	Result      string          `json:"result"`
+	Error       string          `json:"error,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Fields      []Field         `json:"fields,omitempty"`
 }
 type signedEntry struct {
 	Entry    Entry  `json:"entry"`
 	PrevHash string `json:"prev_hash"`
 	Hash     string `json:"hash"`
 	HMAC     string `json:"hmac"`
+	KeyID    string `json:"key_id,omitempty"`
+	Sig      string `json:"sig,omitempty"`
 }
 type Log struct {
 	mutex       sync.Mutex
 	file        *os.File
 	path        string
 	key         []byte
+	keyID       string
+	signer      ed25519.PrivateKey
 	lastHashHex string
 	nextIndex   uint64
+	unsealed    []Problem
+}
+
+// prevLink is where a new log continues the chain from
+type prevLink struct {
+	log       string
+	lastHash  string
+	lastIndex uint64
+	archive   string
 }
 
 func Open(path string, key []byte, prevLogHashHex string) (*Log, error) {
+	return openLinked(path, Keys{HMAC: key}, prevLink{lastHash: prevLogHashHex})
+}
+
+// OpenSigned is Open for a log whose entries are also signed with keys.Signer. An existing
+// log started without a signing key carries on with HMACs only.
+func OpenSigned(path string, keys Keys, prevLogHashHex string) (*Log, error) {
+	return openLinked(path, keys, prevLink{lastHash: prevLogHashHex})
+}
+
+func openLinked(path string, keys Keys, prev prevLink) (*Log, error) {
+	key := keys.HMAC
 	if len(key) == 0 {
 		return nil, errors.New("HMAC key is empty")
 	}
@@ -73,7 +107,7 @@ func Open(path string, key []byte, prevLogHashHex string) (*Log, error) {
 	if err != nil {
 		return nil, err
 	}
-	log := &Log{file: file, path: path, key: key}
+	log := &Log{file: file, path: path, key: key, keyID: KeyID(key)}
 
 	stat, _ := file.Stat()
 	if stat.Size() == 0 {
@@ -83,12 +117,19 @@ func Open(path string, key []byte, prevLogHashHex string) (*Log, error) {
 			return nil, fmt.Errorf("failed to generate random seed: %w", err)
 		}
 		hdr := header{
-			Kind:            "hdr",
-			Version:         currentVersion,
-			Created:         time.Now().Format("2006-01-02 15:04:05"),
-			Host:            hostname(),
-			SeedHex:         hex.EncodeToString(seed),
-			PrevLogLastHash: prevLogHashHex,
+			Kind:             "hdr",
+			Version:          SchemaVersion,
+			Created:          time.Now().Format("2006-01-02 15:04:05"),
+			Host:             hostname(),
+			SeedHex:          hex.EncodeToString(seed),
+			PrevLogLastHash:  prev.lastHash,
+			PrevLog:          prev.log,
+			PrevLogLastIndex: prev.lastIndex,
+			Archive:          prev.archive,
+		}
+		if keys.Signer != nil {
+			hdr.SignKey = EncodePublicKey(keys.Signer.Public().(ed25519.PublicKey))
+			log.signer = keys.Signer
 		}
 		if err = writeJSONLine(file, hdr); err != nil {
 			_ = file.Close()
@@ -97,11 +138,18 @@ func Open(path string, key []byte, prevLogHashHex string) (*Log, error) {
 		log.lastHashHex = hdr.SeedHex
 		log.nextIndex = 1
 	} else {
-		lastHash, nextIdx, err := scanTail(path)
+		lastHash, nextIdx, signKey, err := scanTail(path)
 		if err != nil {
 			_ = file.Close()
 			return nil, fmt.Errorf("failed to read existing log: %w", err)
 		}
+		if signKey != "" {
+			if keys.Signer == nil || signKey != EncodePublicKey(keys.Signer.Public().(ed25519.PublicKey)) {
+				_ = file.Close()
+				return nil, fmt.Errorf("%s was started with a different signing key (%s)", filepath.Base(path), signKey)
+			}
+			log.signer = keys.Signer
+		}
 		log.lastHashHex = lastHash
 		log.nextIndex = nextIdx
 	}
@@ -135,10 +183,10 @@ func syncFile(w io.Writer) error {
 	return nil
 }
 
-func scanTail(path string) (lastHashHex string, nextIndex uint64, err error) {
+func scanTail(path string) (lastHashHex string, nextIndex uint64, signKey string, err error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return "", 0, err
+		return "", 0, "", err
 	}
 	defer file.Close()
 
@@ -147,31 +195,32 @@ func scanTail(path string) (lastHashHex string, nextIndex uint64, err error) {
 	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024) // 10MB max line size
 
 	if !scanner.Scan() {
-		return "", 0, errors.New("empty file missing header")
+		return "", 0, "", errors.New("empty file missing header")
 	}
 	if err = json.Unmarshal(scanner.Bytes(), &hdr); err != nil || hdr.Kind != "hdr" {
-		return "", 0, errors.New("invalid header")
+		return "", 0, "", errors.New("invalid header")
 	}
 
 	lastHashHex = hdr.SeedHex
 	nextIndex = 1
+	signKey = hdr.SignKey
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		var se signedEntry
 		if err = json.Unmarshal(line, &se); err != nil {
-			return "", 0, fmt.Errorf("invalid log entry: %w", err)
+			return "", 0, "", fmt.Errorf("invalid log entry: %w", err)
 		}
-		if se.Entry.Kind != "entry" {
-			return "", 0, errors.New("invalid log entry kind")
+		if se.Entry.Kind != "entry" && se.Entry.Kind != "break" {
+			return "", 0, "", errors.New("invalid log entry kind")
 		}
 		lastHashHex = se.Hash
 		nextIndex = se.Entry.Index + 1
 	}
 	if err = scanner.Err(); err != nil {
-		return "", 0, err
+		return "", 0, "", err
 	}
-	return lastHashHex, nextIndex, nil
+	return lastHashHex, nextIndex, signKey, nil
 }
 
 func (l *Log) Append(e *Entry) error {
@@ -181,6 +230,9 @@ func (l *Log) Append(e *Entry) error {
 	if e.Kind == "" {
 		e.Kind = "entry"
 	}
+	if err := checkPayload(e); err != nil {
+		return err
+	}
 	e.Index = l.nextIndex
 	e.Time = time.Now().Format("2006-01-02 15:04:05")
 
@@ -209,12 +261,30 @@ func (l *Log) Append(e *Entry) error {
 		PrevHash: l.lastHashHex,
 		Hash:     hashHex,
 		HMAC:     hmacHex,
+		KeyID:    l.keyID,
+	}
+	if l.signer != nil {
+		se.Sig = signEntry(l.signer, sum)
 	}
 	if err = writeJSONLine(l.file, se); err != nil {
 		return err
 	}
 
+	forward(Record{Host: hostname(), Log: filepath.Base(l.path), Entry: *e, PrevHash: l.lastHashHex, Hash: hashHex, Sig: se.Sig})
 	l.lastHashHex = hashHex
 	l.nextIndex++
 	return nil
 }
+
+// LastHash is the hash of the newest entry, or the seed for an empty log
+func (l *Log) LastHash() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.lastHashHex
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
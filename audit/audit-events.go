@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"TUFWGo/ufw/rules"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Action names the kind of event an entry records
+type Action string
+
+const (
+	ActionUFWAdd         Action = "ufw.add"
+	ActionUFWDelete      Action = "ufw.delete"
+	ActionProfileCreate  Action = "profile.create"
+	ActionProfileAdd     Action = "profile.add"
+	ActionProfileExecute Action = "profile.execute"
+	ActionProfileDeploy  Action = "profile.deploy"
+
+	ActionSSHTest          Action = "ssh.test"
+	ActionHostKeyTrust     Action = "ssh.hostkey.trust"
+	ActionHostKeyRemove    Action = "ssh.hostkey.remove"
+	ActionApprovalPropose  Action = "approval.propose"
+	ActionApprovalApprove  Action = "approval.approve"
+	ActionApprovalReject   Action = "approval.reject"
+	ActionControllerRotate Action = "controller.rotate"
+	ActionControllerPass   Action = "controller.key.passphrase"
+
+	ActionEmailAPI        Action = "email.api"
+	ActionEmailRecipients Action = "email.recipients"
+	ActionEmailSend       Action = "email.send"
+
+	ActionAuditArchive   Action = "audit.archive"
+	ActionAuditBreak     Action = "audit.break"
+	ActionAuditKeyRotate Action = "audit.key.rotate"
+	ActionAuditSinkTest  Action = "audit.sink.test"
+
+	// Written by tufwgo-auth to the managed host's own log
+	ActionAuthHandshake Action = "auth.handshake"
+	ActionAuthExec      Action = "auth.exec"
+	ActionAuthRecord    Action = "auth.record"
+	ActionAuthApproval  Action = "auth.approval"
+)
+
+// RuleAdd is the payload of ufw.add when the rule came from the TUI form
+type RuleAdd struct {
+	Rule rules.Form `json:"rule"`
+}
+
+// RuleDelete is the payload of ufw.delete: the rule as ufw listed it before it was deleted
+type RuleDelete struct {
+	Rule string `json:"rule"`
+}
+
+// Event describes one action in the catalog. Payload is the zero value of the event's payload
+// type, or nil when its details are only in the entry's fields.
+type Event struct {
+	Action      Action
+	Description string
+	Payload     any
+	Fields      []string // field names the event is known to carry
+}
+
+// Fields shared by the events that change ufw, whichever way the change was made
+var changeFields = []string{
+	"ssh_active", "fanout", "target", "failed_command", "role",
+	"ruleset_before", "ruleset_after", "ruleset_diff", "host_ref", "host_log", "host_log_hash",
+	"approval_id", "proposed_by", "proposer_sig", "approved_by", "approver_sig",
+	"label", "source", "ref", "controller", "controller_log", "deleted_rule",
+}
+
+var approvalFields = []string{"approval_id", "proposed_by", "proposer_sig", "approved_by", "approver_sig"}
+
+var hostLogFields = []string{"label", "source", "client_version", "role"}
+
+// Events is the catalog of every action TUFWGo writes
+var Events = []Event{
+	{ActionUFWAdd, "A ufw rule was added", RuleAdd{}, changeFields},
+	{ActionUFWDelete, "A ufw rule was deleted", RuleDelete{}, changeFields},
+	{ActionProfileCreate, "A rule profile was created", nil, nil},
+	{ActionProfileAdd, "Rules were added to a profile", nil, nil},
+	{ActionProfileExecute, "A profile's rules were applied", nil, changeFields},
+	{ActionProfileDeploy, "A profile was deployed through the IaC flow", nil, nil},
+	{ActionSSHTest, "The SSH connection was tested", nil, nil},
+	{ActionHostKeyTrust, "A host key was trusted; the command is the host", nil, []string{"fingerprint", "policy"}},
+	{ActionHostKeyRemove, "Host keys were removed from known_hosts; the command is the pattern", nil, nil},
+	{ActionApprovalPropose, "A change was queued for approval", nil, approvalFields},
+	{ActionApprovalApprove, "A queued change was approved", nil, approvalFields},
+	{ActionApprovalReject, "A queued change was rejected", nil, approvalFields},
+	{ActionControllerRotate, "One host's step of a controller key rotation", nil, []string{"phase", "old_controller", "new_controller", "target"}},
+	{ActionControllerPass, "The controller key passphrase was changed", nil, nil},
+	{ActionEmailAPI, "The mail API could not be used", nil, nil},
+	{ActionEmailRecipients, "The alert recipients could not be read", nil, nil},
+	{ActionEmailSend, "An alert email was sent, or failed to send", nil, nil},
+	{ActionAuditArchive, "Old logs were archived and pruned", nil, []string{"archived", "pruned"}},
+	{ActionAuditBreak, "A broken chain was sealed; the error is the reason given", nil, []string{"log", "problem", "detail"}},
+	{ActionAuditKeyRotate, "The HMAC key was rotated; the proof is made with the new key", nil, []string{"old_key_id", "new_key_id", "new_key_proof"}},
+	{ActionAuditSinkTest, "A test entry for the audit sinks", nil, nil},
+	{ActionAuthHandshake, "A controller handshake with tufwgo-auth", nil, hostLogFields},
+	{ActionAuthExec, "A command run through tufwgo-auth", nil, hostLogFields},
+	{ActionAuthRecord, "A refused host record", nil, hostLogFields},
+	{ActionAuthApproval, "An approval checked, or a rejection recorded, by tufwgo-auth", nil, hostLogFields},
+}
+
+// LookupEvent finds an action in the catalog
+func LookupEvent(action Action) (Event, bool) {
+	for _, ev := range Events {
+		if ev.Action == action {
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+// SetPayload stores p as the entry's payload
+func (e *Entry) SetPayload(p any) {
+	e.Payload = MarshalPayload(p)
+}
+
+// MarshalPayload encodes a catalog payload, e.g. for a host record
+func MarshalPayload(p any) json.RawMessage {
+	// Payloads are plain structs, which always marshal
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// DecodePayload returns the entry's payload as its catalog type, or nil when it has none
+func (e Entry) DecodePayload() (any, error) {
+	if len(e.Payload) == 0 {
+		return nil, nil
+	}
+	ev, ok := LookupEvent(e.Action)
+	if !ok || ev.Payload == nil {
+		return nil, fmt.Errorf("%s has no payload type", e.Action)
+	}
+	p := reflect.New(reflect.TypeOf(ev.Payload))
+	if err := json.Unmarshal(e.Payload, p.Interface()); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %w", e.Action, err)
+	}
+	return p.Elem().Interface(), nil
+}
+
+// checkPayload refuses to write a payload the catalog can't read back. Actions missing from the
+// catalog are let through, since a newer controller may record them on this host.
+func checkPayload(e *Entry) error {
+	if _, ok := LookupEvent(e.Action); !ok {
+		return nil
+	}
+	_, err := e.DecodePayload()
+	return err
+}
+
+// legacyField is a Field as schema version 1 wrote it, when the rule rode along in the fields
+type legacyField struct {
+	Field
+	Rule        *rules.Form `json:"rule,omitempty"`
+	DeletedRule string      `json:"deleted_rule,omitempty"`
+}
+
+// UnmarshalJSON moves the rule of a version 1 entry into its payload. The hash is always taken
+// over the bytes on disk, so reading an entry this way doesn't affect verification.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type plain Entry
+	var raw struct {
+		plain
+		Fields []legacyField `json:"fields,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = Entry(raw.plain)
+	e.Fields = nil
+	for _, f := range raw.Fields {
+		switch {
+		case f.Rule != nil && *f.Rule != (rules.Form{}) && len(e.Payload) == 0:
+			e.SetPayload(RuleAdd{Rule: *f.Rule})
+		case f.DeletedRule != "" && len(e.Payload) == 0:
+			e.SetPayload(RuleDelete{Rule: f.DeletedRule})
+		}
+		if f.Name != "" || f.Value != "" || f.Ruleset != nil {
+			e.Fields = append(e.Fields, f.Field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"TUFWGo/auth"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// HostRecorder sends a record of a change to the managed host's own audit log
+type HostRecorder func(ctx context.Context, client *cryptossh.Client, rec auth.HostRecord) (*auth.RecordReply, error)
+
+var (
+	hostRecorderMutex sync.Mutex
+	hostRecorder      HostRecorder
+)
+
+// SetHostRecorder turns on host-side records for remote changes; nil turns them off
+func SetHostRecorder(r HostRecorder) {
+	hostRecorderMutex.Lock()
+	hostRecorder = r
+	hostRecorderMutex.Unlock()
+}
+
+// RecordOnHost writes rec to the host's log and returns the fields that cross-reference it from
+// the controller's entry. The same ref goes into both, and the host entry names the controller
+// log, so either side can be traced to the other. Failures are recorded rather than returned,
+// since the change itself has already happened.
+func RecordOnHost(ctx context.Context, client *cryptossh.Client, rec auth.HostRecord) []Field {
+	hostRecorderMutex.Lock()
+	record := hostRecorder
+	hostRecorderMutex.Unlock()
+	if record == nil || client == nil {
+		return nil
+	}
+
+	if rec.Ref == "" {
+		rec.Ref = newRef()
+	}
+	if auditor, actor := GetGlobalAuditor(); auditor != nil {
+		if rec.Controller == "" {
+			rec.Controller = actor
+		}
+		if rec.ControllerLog == "" {
+			rec.ControllerLog = filepath.Base(auditor.Path())
+		}
+	}
+	fields := []Field{{Name: "host_ref", Value: rec.Ref}}
+	rep, err := record(ctx, client, rec)
+	if err != nil {
+		return append(fields, Field{Name: "host_log", Value: "unavailable: " + err.Error()})
+	}
+	return append(fields,
+		Field{Name: "host_log", Value: fmt.Sprintf("%s#%d", rep.Log, rep.Index)},
+		Field{Name: "host_log_hash", Value: rep.Hash},
+	)
+}
+
+// HostRecordFields copies the name/value fields worth keeping on the host; full ruleset
+// snapshots stay on the controller and the host gets their hashes
+func HostRecordFields(fields []Field) []auth.RecordField {
+	var out []auth.RecordField
+	for _, f := range fields {
+		if f.Name != "" {
+			out = append(out, auth.RecordField{Name: f.Name, Value: f.Value})
+		}
+	}
+	return out
+}
+
+func newRef() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Reconciliation outcomes for one cross-referenced change
+const (
+	ReconcileOK          = "ok"
+	ReconcileNotSent     = "not sent"
+	ReconcileMissingHost = "missing on host"
+	ReconcileMismatch    = "mismatch"
+	ReconcileOnlyOnHost  = "only on host"
+)
+
+// ReconcileRow pairs a controller entry with the host's record of the same change
+type ReconcileRow struct {
+	Ref        string
+	Status     string
+	Detail     string
+	Controller *FoundEntry
+	Host       *Entry
+}
+
+// Reconcile matches the controller's entries for host (every host when empty) against the entries
+// read from that host's log by their shared ref
+func Reconcile(dir, host string, hostEntries []Entry) ([]ReconcileRow, error) {
+	found, err := Search(dir, Filter{})
+	if err != nil {
+		return nil, err
+	}
+	onHost := make(map[string]*Entry)
+	for i := range hostEntries {
+		if ref := fieldValue(hostEntries[i].Fields, "ref"); ref != "" {
+			onHost[ref] = &hostEntries[i]
+		}
+	}
+
+	var rows []ReconcileRow
+	seen := make(map[string]bool)
+	for i := range found {
+		e := &found[i]
+		ref := fieldValue(e.Fields, "host_ref")
+		if ref == "" || (host != "" && EntryHost(e.Entry) != host) {
+			continue
+		}
+		seen[ref] = true
+		row := ReconcileRow{Ref: ref, Controller: e, Host: onHost[ref]}
+		hostLog := fieldValue(e.Fields, "host_log")
+		switch {
+		case strings.HasPrefix(hostLog, "unavailable"):
+			row.Status, row.Detail = ReconcileNotSent, hostLog
+		case row.Host == nil:
+			row.Status, row.Detail = ReconcileMissingHost, "host_log says "+hostLog
+		case !strings.HasSuffix(hostLog, fmt.Sprintf("#%d", row.Host.Index)):
+			row.Status, row.Detail = ReconcileMismatch, fmt.Sprintf("controller has %s, host entry is #%d", hostLog, row.Host.Index)
+		case row.Host.Action != e.Action || row.Host.Result != e.Result:
+			row.Status, row.Detail = ReconcileMismatch, fmt.Sprintf("controller has %s/%s, host has %s/%s", e.Action, e.Result, row.Host.Action, row.Host.Result)
+		default:
+			row.Status = ReconcileOK
+		}
+		rows = append(rows, row)
+	}
+	for i := range hostEntries {
+		h := &hostEntries[i]
+		ref := fieldValue(h.Fields, "ref")
+		if ref == "" || seen[ref] {
+			continue
+		}
+		row := ReconcileRow{Ref: ref, Status: ReconcileOnlyOnHost, Host: h,
+			Detail: fmt.Sprintf("from %s (%s)", fieldValue(h.Fields, "controller"), fieldValue(h.Fields, "controller_log"))}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func fieldValue(fields []Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,301 @@
+package audit
+
+import (
+	hmac2 "crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const keyTransitionTag = "TUFWGO-AUDIT-KEY-TRANSITION\x00"
+
+// KeyringPath holds every HMAC key this machine has used, so logs stay verifiable after a
+// rotation or if vars/auditkey.env is lost
+func KeyringPath() string {
+	return filepath.Join(filepath.Dir(SigningKeyPath()), "audit-keyring.json")
+}
+
+// RingKey is one HMAC key in the keyring. The last key that isn't retired is the current one.
+type RingKey struct {
+	ID      string `json:"id"`
+	Key     string `json:"key_b64"`
+	Added   string `json:"added"`
+	Retired string `json:"retired,omitempty"`
+}
+
+type keyring struct {
+	Keys []RingKey `json:"keys"`
+}
+
+// KeyID names an HMAC key in entries and the keyring without giving the key away
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("TUFWGO-AUDIT-KEY-ID\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewHMACKey returns a fresh base64 audit key
+func NewHMACKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate audit key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func loadKeyring() (*keyring, error) {
+	data, err := os.ReadFile(KeyringPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &keyring{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r keyring
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid audit keyring: %w", err)
+	}
+	return &r, nil
+}
+
+func (r *keyring) save() error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(KeyringPath()), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(KeyringPath(), append(data, '\n'))
+}
+
+func (r *keyring) current() ([]byte, bool) {
+	for i := len(r.Keys) - 1; i >= 0; i-- {
+		if r.Keys[i].Retired == "" {
+			key, err := base64.StdEncoding.DecodeString(r.Keys[i].Key)
+			return key, err == nil
+		}
+	}
+	return nil, false
+}
+
+func (r *keyring) find(id string) (RingKey, bool) {
+	for _, k := range r.Keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return RingKey{}, false
+}
+
+// promote makes key the current one, retiring the rest
+func (r *keyring) promote(key []byte) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	id := KeyID(key)
+	for i := range r.Keys {
+		if r.Keys[i].ID != id && r.Keys[i].Retired == "" {
+			r.Keys[i].Retired = now
+		}
+	}
+	if _, ok := r.find(id); !ok {
+		r.Keys = append(r.Keys, RingKey{ID: id, Key: base64.StdEncoding.EncodeToString(key), Added: now})
+	}
+}
+
+func (r *keyring) byID() map[string][]byte {
+	ring := make(map[string][]byte, len(r.Keys))
+	for _, k := range r.Keys {
+		if key, err := base64.StdEncoding.DecodeString(k.Key); err == nil {
+			ring[k.ID] = key
+		}
+	}
+	return ring
+}
+
+// Keyring lists the keys in the keyring without their secret halves
+func Keyring() ([]RingKey, error) {
+	r, err := loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]RingKey, len(r.Keys))
+	for i, k := range r.Keys {
+		k.Key = ""
+		out[i] = k
+	}
+	return out, nil
+}
+
+// InitialKey returns the keyring's current key for a fresh vars/auditkey.env, generating and
+// recording a new one only when the keyring is empty
+func InitialKey() (string, error) {
+	r, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	if key, ok := r.current(); ok {
+		return base64.StdEncoding.EncodeToString(key), nil
+	}
+	b64, err := NewHMACKey()
+	if err != nil {
+		return "", err
+	}
+	key, _ := base64.StdEncoding.DecodeString(b64)
+	r.promote(key)
+	if err = r.save(); err != nil {
+		return "", fmt.Errorf("failed to save audit keyring: %w", err)
+	}
+	return b64, nil
+}
+
+// currentHMACKey picks the key to write with. The keyring is authoritative; a TUFWGO_AUDIT_KEY
+// it has never seen is adopted as the new current key, and one it has retired is ignored.
+func currentHMACKey() ([]byte, map[string][]byte, error) {
+	r, err := loadKeyring()
+	if err != nil {
+		return nil, nil, err
+	}
+	envKey, envErr := loadAuditKey()
+	if envErr == nil {
+		if _, known := r.find(KeyID(envKey)); !known {
+			r.promote(envKey)
+			if err = r.save(); err != nil {
+				return nil, nil, fmt.Errorf("failed to save audit keyring: %w", err)
+			}
+		}
+	}
+	key, ok := r.current()
+	if !ok {
+		if envErr != nil {
+			return nil, nil, envErr
+		}
+		return nil, nil, errors.New("audit keyring has no current key")
+	}
+	return key, r.byID(), nil
+}
+
+// RotateKey adds a fresh HMAC key to the keyring and makes it current. Logs open in this
+// process keep the old key until Log.RotateKey is called.
+func RotateKey() ([]byte, error) {
+	if _, _, err := currentHMACKey(); err != nil {
+		return nil, err
+	}
+	r, err := loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	b64, err := NewHMACKey()
+	if err != nil {
+		return nil, err
+	}
+	key, _ := base64.StdEncoding.DecodeString(b64)
+	r.promote(key)
+	if err = r.save(); err != nil {
+		return nil, fmt.Errorf("failed to save audit keyring: %w", err)
+	}
+	return key, nil
+}
+
+// RotateKey switches the log to newKey. The transition record is written under the old key and
+// carries a proof made with the new one, so whoever holds either can check the handover.
+func (l *Log) RotateKey(actor string, newKey []byte) error {
+	oldID, newID := l.KeyID(), KeyID(newKey)
+	err := l.Append(&Entry{
+		Actor:  actor,
+		Action: ActionAuditKeyRotate,
+		Result: "success",
+		Fields: []Field{
+			{Name: "old_key_id", Value: oldID},
+			{Name: "new_key_id", Value: newID},
+			{Name: "new_key_proof", Value: keyTransitionProof(newKey, oldID, newID)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	l.key, l.keyID = newKey, newID
+	l.mutex.Unlock()
+	return nil
+}
+
+// KeyID is the id of the HMAC key the log is currently written with
+func (l *Log) KeyID() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.keyID
+}
+
+func keyTransitionProof(newKey []byte, oldID, newID string) string {
+	h := hmac2.New(sha256.New, newKey)
+	h.Write([]byte(keyTransitionTag + oldID + "\x00" + newID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkTransition verifies a key rotation record's proof when the new key is known
+func (v Verifier) checkTransition(e Entry) bool {
+	var oldID, newID, proof string
+	for _, f := range e.Fields {
+		switch f.Name {
+		case "old_key_id":
+			oldID = f.Value
+		case "new_key_id":
+			newID = f.Value
+		case "new_key_proof":
+			proof = f.Value
+		}
+	}
+	keys := v.hmacKeys(newID)
+	if len(keys) == 0 {
+		return !v.hasHMAC()
+	}
+	return hmac2.Equal([]byte(proof), []byte(keyTransitionProof(keys[0], oldID, newID)))
+}
+
+func (v Verifier) hasHMAC() bool {
+	return v.HMACKey != nil || len(v.Keyring) > 0
+}
+
+// hmacKeys returns the keys an entry may have been HMAC'd with. Entries written before the
+// keyring existed carry no key id, so every known key is a candidate.
+func (v Verifier) hmacKeys(id string) [][]byte {
+	if id == "" {
+		var keys [][]byte
+		if v.HMACKey != nil {
+			keys = append(keys, v.HMACKey)
+		}
+		for _, k := range v.Keyring {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	if k, ok := v.Keyring[id]; ok {
+		return [][]byte{k}
+	}
+	if v.HMACKey != nil && KeyID(v.HMACKey) == id {
+		return [][]byte{v.HMACKey}
+	}
+	return nil
+}
+
+// checkHMAC explains why mac isn't a valid HMAC of sum under key id, or returns ""
+func (v Verifier) checkHMAC(id string, sum []byte, mac string) string {
+	keys := v.hmacKeys(id)
+	if len(keys) == 0 {
+		return "HMAC key " + id + " is not in the keyring"
+	}
+	for _, k := range keys {
+		h := hmac2.New(sha256.New, k)
+		h.Write(sum)
+		if hmac2.Equal([]byte(mac), []byte(hex.EncodeToString(h.Sum(nil)))) {
+			return ""
+		}
+	}
+	return "invalid entry HMAC"
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadEntries returns every entry in a log file in order. It does not check the chain; use Verify for that.
+func ReadEntries(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readEntriesFrom(file)
+}
+
+func readEntriesFrom(r io.Reader) ([]Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024) // 10MB max line size
+
+	if !scanner.Scan() {
+		return nil, errors.New("audit: empty file")
+	}
+	var hdr header
+	if err := json.Unmarshal(scanner.Bytes(), &hdr); err != nil || hdr.Kind != "hdr" {
+		return nil, errors.New("audit: invalid header")
+	}
+
+	var entries []Entry
+	lineNo := 1
+	for scanner.Scan() {
+		lineNo++
+		var se signedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &se); err != nil {
+			return entries, fmt.Errorf("audit: line %d: %w", lineNo, err)
+		}
+		entries = append(entries, se.Entry)
+	}
+	return entries, scanner.Err()
+}
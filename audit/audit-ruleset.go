@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"TUFWGo/ufw/rules"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RulesetFields records the firewall either side of a change: each side's hash with its full
+// snapshot, so the state at any entry can be rebuilt from the log alone, and the rules that changed
+func RulesetFields(c *rules.RulesetChange) []Field {
+	if c == nil {
+		return nil
+	}
+	fields := []Field{
+		snapshotField("ruleset_before", c.Before, c.BeforeErr),
+		snapshotField("ruleset_after", c.After, c.AfterErr),
+	}
+	if c.Before != nil && c.After != nil {
+		diff := "unchanged"
+		if c.Before.SHA256 != c.After.SHA256 {
+			diff = strings.Join(c.Before.Diff(c.After), "\n")
+		}
+		fields = append(fields, Field{Name: "ruleset_diff", Value: diff})
+	}
+	return fields
+}
+
+func snapshotField(name string, s *rules.Snapshot, err error) Field {
+	switch {
+	case s != nil:
+		return Field{Name: name, Value: "sha256:" + s.SHA256, Ruleset: s}
+	case err != nil:
+		return Field{Name: name, Value: "unavailable: " + err.Error()}
+	}
+	return Field{Name: name, Value: "not captured"}
+}
+
+// RulesetState is a host's firewall as of a point in the log
+type RulesetState struct {
+	Snapshot *rules.Snapshot
+	Entry    FoundEntry // the change that left the firewall this way
+	Drift    []string   // gaps where the firewall changed outside TUFWGo
+}
+
+// EntryHost is the host a change was made on: the via_ssh host in its actor, or "local"
+func EntryHost(e Entry) string {
+	if _, host, ok := strings.Cut(e.Actor, " via_ssh="); ok {
+		return strings.Fields(host + " ")[0]
+	}
+	return "local"
+}
+
+// RulesetAt rebuilds host's firewall as it was at the given time from the snapshots in the log.
+// It returns nil when no change on that host before then recorded one.
+func RulesetAt(dir, host string, at time.Time) (*RulesetState, error) {
+	found, err := Search(dir, Filter{To: at})
+	if err != nil {
+		return nil, err
+	}
+	var state *RulesetState
+	var drift []string
+	for _, e := range found {
+		if EntryHost(e.Entry) != host {
+			continue
+		}
+		var before, after *rules.Snapshot
+		for _, f := range e.Fields {
+			switch f.Name {
+			case "ruleset_before":
+				before = f.Ruleset
+			case "ruleset_after":
+				after = f.Ruleset
+			}
+		}
+		if after == nil {
+			continue
+		}
+		if state != nil && before != nil && before.SHA256 != state.Snapshot.SHA256 {
+			drift = append(drift, fmt.Sprintf("changed outside TUFWGo between %s and %s", state.Entry.Time, e.Time))
+		}
+		state = &RulesetState{Snapshot: after, Entry: e}
+	}
+	if state != nil {
+		state.Drift = drift
+	}
+	return state, nil
+}
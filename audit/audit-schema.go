@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"TUFWGo/ufw/rules"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaID names the JSON Schema for the current log format
+func SchemaID() string {
+	return fmt.Sprintf("urn:tufwgo:audit-log:v%d", SchemaVersion)
+}
+
+// Types that get their own definition and are referred to everywhere else
+var schemaRefs = map[reflect.Type]string{
+	reflect.TypeOf(Entry{}):          "entry",
+	reflect.TypeOf(Field{}):          "field",
+	reflect.TypeOf(rules.Snapshot{}): "ruleset",
+	reflect.TypeOf(rules.Form{}):     "rule_form",
+}
+
+// JSONSchema describes one line of a log, header or entry, as a draft 2020-12 JSON Schema.
+// Every action in the catalog is listed with its description, payload and known field names;
+// actions it doesn't know are still valid so older tools can read newer logs.
+func JSONSchema() ([]byte, error) {
+	defs := map[string]any{
+		"header":       headerSchema(),
+		"signed_entry": typeSchema(reflect.TypeOf(signedEntry{}), ""),
+		"entry":        entrySchema(),
+		"field":        fieldSchema(),
+		"ruleset":      typeSchema(reflect.TypeOf(rules.Snapshot{}), "ruleset"),
+		"rule_form":    typeSchema(reflect.TypeOf(rules.Form{}), "rule_form"),
+	}
+	for _, ev := range Events {
+		if ev.Payload != nil {
+			defs[payloadDef(ev.Action)] = typeSchema(reflect.TypeOf(ev.Payload), "")
+		}
+	}
+	schema := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         SchemaID(),
+		"title":       "TUFWGo audit log line",
+		"description": "Each line of a log is a header (the first line) or a signed entry.",
+		"oneOf":       []any{ref("header"), ref("signed_entry")},
+		"$defs":       defs,
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func headerSchema() map[string]any {
+	s := typeSchema(reflect.TypeOf(header{}), "")
+	props := s["properties"].(map[string]any)
+	props["kind"] = map[string]any{"const": "hdr"}
+	props["version"] = map[string]any{
+		"type": "integer", "minimum": 1, "maximum": SchemaVersion,
+		"description": "Schema version the log was started with; 1 carried rules in fields rather than payloads",
+	}
+	return s
+}
+
+func entrySchema() map[string]any {
+	s := typeSchema(reflect.TypeOf(Entry{}), "entry")
+	props := s["properties"].(map[string]any)
+	props["kind"] = map[string]any{"enum": []string{"entry", "break"}}
+
+	actions := make([]string, len(Events))
+	var perAction []any
+	for i, ev := range Events {
+		actions[i] = string(ev.Action)
+		then := map[string]any{"description": ev.Description}
+		thenProps := map[string]any{}
+		if ev.Payload != nil {
+			thenProps["payload"] = ref(payloadDef(ev.Action))
+		} else {
+			thenProps["payload"] = false
+		}
+		if len(ev.Fields) > 0 {
+			thenProps["fields"] = map[string]any{
+				"items": map[string]any{"properties": map[string]any{"name": map[string]any{"examples": ev.Fields}}},
+			}
+		}
+		then["properties"] = thenProps
+		perAction = append(perAction, map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"action": map[string]any{"const": ev.Action}}, "required": []string{"action"}},
+			"then": then,
+		})
+	}
+	props["action"] = map[string]any{"type": "string", "examples": actions}
+	props["payload"] = map[string]any{"type": "object", "description": "Typed by the action; see the payload definitions"}
+	s["allOf"] = perAction
+	return s
+}
+
+// fieldSchema also allows the rule and deleted_rule of version 1 logs
+func fieldSchema() map[string]any {
+	s := typeSchema(reflect.TypeOf(Field{}), "field")
+	props := s["properties"].(map[string]any)
+	props["rule"] = map[string]any{"$ref": "#/$defs/rule_form", "deprecated": true}
+	props["deleted_rule"] = map[string]any{"type": "string", "deprecated": true}
+	return s
+}
+
+func payloadDef(a Action) string {
+	return "payload." + string(a)
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+// typeSchema describes t from its JSON tags. self is t's own definition name, so it's
+// spelled out rather than referred to.
+func typeSchema(t reflect.Type, self string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if name, ok := schemaRefs[t]; ok && name != self {
+		return ref(name)
+	}
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), "")}
+	case reflect.Struct:
+		props := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = typeSchema(f.Type, "")
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "required": required}
+	}
+	return map[string]any{}
+}
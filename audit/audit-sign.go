@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"TUFWGo/system/userdir"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	entrySigTag    = "TUFWGO-AUDIT-ENTRY\x00"
+	manifestSigTag = "TUFWGO-AUDIT-MANIFEST\x00"
+)
+
+// Keys are what a machine that writes audit logs holds. Entries are HMAC'd with the shared key
+// as before and, when Signer is set, also signed so they can be checked with just the public key.
+// Ring holds the earlier HMAC keys by id for verifying older entries.
+type Keys struct {
+	HMAC   []byte
+	Signer ed25519.PrivateKey
+	Ring   map[string][]byte
+}
+
+// Verifier is what is trusted when checking logs. An auditor needs only PublicKey; logs written
+// before signing was added can only be checked with HMACKey.
+type Verifier struct {
+	HMACKey   []byte
+	Keyring   map[string][]byte
+	PublicKey ed25519.PublicKey
+}
+
+func (k Keys) Verifier() Verifier {
+	v := Verifier{HMACKey: k.HMAC, Keyring: k.Ring}
+	if k.Signer != nil {
+		v.PublicKey = k.Signer.Public().(ed25519.PublicKey)
+	}
+	return v
+}
+
+// LoadKeys returns the current HMAC key from the keyring (seeded from TUFWGO_AUDIT_KEY) and
+// this machine's signing key, creating the signing key the first time
+func LoadKeys() (Keys, error) {
+	hmacKey, ring, err := currentHMACKey()
+	if err != nil {
+		return Keys{}, err
+	}
+	signer, err := ensureSigningKey()
+	if err != nil {
+		return Keys{}, fmt.Errorf("unable to load audit signing key: %w", err)
+	}
+	return Keys{HMAC: hmacKey, Signer: signer, Ring: ring}, nil
+}
+
+// SigningKeyPath is kept apart from vars/auditkey.env; only its public half is ever shared
+func SigningKeyPath() string {
+	return filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "keys", "audit-signing.key")
+}
+
+type signingKeyFile struct {
+	Private string `json:"private_b64"`
+	Public  string `json:"public"`
+}
+
+func ensureSigningKey() (ed25519.PrivateKey, error) {
+	path := SigningKeyPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateSigningKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kf signingKeyFile
+	if err = json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(kf.Private)
+	if err != nil || len(raw) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key in key file")
+	}
+	_ = os.Chmod(path, 0600)
+	return ed25519.PrivateKey(raw), nil
+}
+
+func generateSigningKey(path string) (ed25519.PrivateKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(signingKeyFile{
+		Private: base64.StdEncoding.EncodeToString(priv),
+		Public:  EncodePublicKey(pub),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// O_EXCL so two processes starting at once can't each write their own key
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return ensureSigningKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Write(append(data, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return priv, nil
+}
+
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return "ed25519:" + base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey reads an "ed25519:<base64>" public key, or a file holding one
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "ed25519:") {
+		data, err := os.ReadFile(s)
+		if err != nil {
+			return nil, fmt.Errorf("not an ed25519: key or a readable file: %w", err)
+		}
+		s = strings.TrimSpace(string(data))
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "ed25519:"))
+	if err != nil {
+		return nil, fmt.Errorf("bad public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bad public key length: %d", len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+func signEntry(priv ed25519.PrivateKey, sum []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, append([]byte(entrySigTag), sum...)))
+}
+
+func verifySig(pub ed25519.PublicKey, tag string, msg []byte, sigB64 string) bool {
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, append([]byte(tag), msg...), sig)
+}
+
+// headerKey works out which key a log's entries must be signed with
+func (v Verifier) headerKey(signKey string) (ed25519.PublicKey, string) {
+	if signKey == "" {
+		if !v.hasHMAC() {
+			return nil, "log is not signed; checking it needs the HMAC key"
+		}
+		return nil, ""
+	}
+	pub, err := ParsePublicKey(signKey)
+	if err != nil {
+		return nil, "invalid signing key in header"
+	}
+	if v.PublicKey != nil && !pub.Equal(v.PublicKey) {
+		return nil, "signed with an untrusted key " + signKey
+	}
+	return pub, ""
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Records per POST when a backlog is being delivered
+const httpSinkBatch = 500
+
+// httpSink POSTs newline-delimited JSON. TUFWGO_AUDIT_SINK_TOKEN, if set, is sent as a bearer token.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(url string) *httpSink {
+	return &httpSink{url: url, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (s *httpSink) Name() string { return s.url }
+
+func (s *httpSink) Send(recs []Record) (int, error) {
+	sent := 0
+	for sent < len(recs) {
+		batch := recs[sent:min(sent+httpSinkBatch, len(recs))]
+		if err := s.post(batch); err != nil {
+			return sent, err
+		}
+		sent += len(batch)
+	}
+	return sent, nil
+}
+
+func (s *httpSink) post(recs []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range recs {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if token := os.Getenv("TUFWGO_AUDIT_SINK_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink writes to the journal's native protocol, with the entry's fields as TUFWGO_* fields
+type journaldSink struct {
+	socket string
+}
+
+func newJournaldSink(socket string) *journaldSink {
+	return &journaldSink{socket: socket}
+}
+
+func (s *journaldSink) Name() string { return "journald" }
+
+func (s *journaldSink) Send(recs []Record) (int, error) {
+	conn, err := net.Dial("unixgram", s.socket)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	for i, r := range recs {
+		if _, err = conn.Write(journalMessage(r.summary())); err != nil {
+			return i, err
+		}
+	}
+	return len(recs), nil
+}
+
+// journalMessage builds a native journal datagram. A record too big for one datagram is left out,
+// keeping the hash that ties the message to the log.
+func journalMessage(r Record) []byte {
+	priority := "6"
+	if r.Entry.Result != "" && r.Entry.Result != "success" {
+		priority = "4"
+	}
+	entry, _ := json.Marshal(r)
+	if len(entry) > maxDatagram-4096 {
+		entry = []byte(`{"omitted":"record too large for one datagram; see the audit log"}`)
+	}
+	msg := fmt.Sprintf("%s %s by %s: %s", r.Entry.Action, r.Entry.Result, r.Entry.Actor, r.Entry.Command)
+	if len(msg) > 1024 {
+		msg = msg[:1024]
+	}
+	var b bytes.Buffer
+	for _, kv := range [][2]string{
+		{"MESSAGE", strings.TrimSuffix(msg, ": ")},
+		{"PRIORITY", priority},
+		{"SYSLOG_IDENTIFIER", "tufwgo"},
+		{"TUFWGO_ACTOR", r.Entry.Actor},
+		{"TUFWGO_ACTION", string(r.Entry.Action)},
+		{"TUFWGO_RESULT", r.Entry.Result},
+		{"TUFWGO_INDEX", fmt.Sprint(r.Entry.Index)},
+		{"TUFWGO_LOG", r.Log},
+		{"TUFWGO_HASH", r.Hash},
+		{"TUFWGO_RECORD", string(entry)},
+	} {
+		writeJournalField(&b, kv[0], kv[1])
+	}
+	return b.Bytes()
+}
+
+// writeJournalField uses the length-prefixed form for values that contain a newline
+func writeJournalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
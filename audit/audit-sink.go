@@ -0,0 +1,357 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A spool stops growing at this size; later records are dropped and show up as index gaps
+const maxSpoolBytes = 64 << 20
+
+// How often a sink that is down is retried
+const sinkRetryInterval = 30 * time.Second
+
+// Record is what is forwarded for each appended entry, with enough of the chain for a
+// collector to check it
+type Record struct {
+	Host     string `json:"host"`
+	Log      string `json:"log"`
+	Entry    Entry  `json:"entry"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+	Sig      string `json:"sig,omitempty"`
+}
+
+// maxDatagram keeps a syslog or journald message inside a single UDP or unix datagram
+const maxDatagram = 60 * 1024
+
+// summary is the record as the datagram sinks send it. Rulesets are cut down to the hashes already
+// in their field values, so a change to a large ruleset still fits in one message; a collector that
+// checks the chain needs the HTTP sink, which sends whole entries.
+func (r Record) summary() Record {
+	if len(r.Entry.Fields) == 0 {
+		return r
+	}
+	fields := make([]Field, len(r.Entry.Fields))
+	for i, f := range r.Entry.Fields {
+		f.Ruleset = nil
+		fields[i] = f
+	}
+	r.Entry.Fields = fields
+	return r
+}
+
+// Sink is somewhere audit records are mirrored to
+type Sink interface {
+	Name() string
+	// Send delivers records in order and returns how many got through before any error
+	Send(recs []Record) (int, error)
+}
+
+// ParseSink builds a sink from a spec:
+//
+//	syslog://host:514          RFC 5424 over UDP
+//	syslog+tcp://host:601      RFC 5424 over TCP, octet-counted
+//	syslog+unix:///dev/log     RFC 5424 to a local socket
+//	journald                   the systemd journal
+//	http(s)://host/path        newline-delimited JSON POSTs
+func ParseSink(spec string) (Sink, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "journald" {
+		return newJournaldSink(journaldSocket), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("bad audit sink %q: %w", spec, err)
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		return newSyslogSink(spec, "udp", u.Host)
+	case "syslog+tcp":
+		return newSyslogSink(spec, "tcp", u.Host)
+	case "syslog+unix":
+		return newSyslogSink(spec, "unixgram", u.Path)
+	case "http", "https":
+		return newHTTPSink(spec), nil
+	}
+	return nil, fmt.Errorf("unknown audit sink %q (want syslog://, syslog+tcp://, syslog+unix://, journald or http(s)://)", spec)
+}
+
+// sinkWorker delivers one sink's spool in the background. Records are spooled before they are
+// sent, so nothing is lost if TUFWGo exits while a collector is down.
+type sinkWorker struct {
+	sink  Sink
+	spool string
+	kick  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	mutex   sync.Mutex
+	lastErr error
+}
+
+var (
+	sinksMutex sync.Mutex
+	sinks      []*sinkWorker
+)
+
+// SetSinks starts forwarding to the given sinks, picking up anything left in their spools
+func SetSinks(specs []string) error {
+	var workers []*sinkWorker
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		s, err := ParseSink(spec)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, &sinkWorker{
+			sink:  s,
+			spool: filepath.Join(SpoolDir(), spoolName(s.Name())),
+			kick:  make(chan struct{}, 1),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		})
+	}
+	sinksMutex.Lock()
+	sinks = workers
+	sinksMutex.Unlock()
+	for _, w := range workers {
+		go w.run()
+		w.kick <- struct{}{}
+	}
+	return nil
+}
+
+// FlushSinks makes a last delivery attempt and stops the workers, waiting at most timeout.
+// Whatever isn't delivered stays spooled for next time.
+func FlushSinks(timeout time.Duration) {
+	sinksMutex.Lock()
+	workers := sinks
+	sinks = nil
+	sinksMutex.Unlock()
+	deadline := time.After(timeout)
+	for _, w := range workers {
+		close(w.stop)
+	}
+	for _, w := range workers {
+		select {
+		case <-w.done:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// SpoolDir holds records waiting to be delivered, one file per sink
+func SpoolDir() string {
+	return filepath.Join(AuditDir(), "spool")
+}
+
+var unsafeSpoolChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func spoolName(sinkName string) string {
+	return strings.Trim(unsafeSpoolChars.ReplaceAllString(sinkName, "_"), "_") + ".ndjson"
+}
+
+// SinkStatus describes a configured sink for "tufwgo audit sinks"
+type SinkStatus struct {
+	Name    string
+	Spool   string
+	Pending int
+	LastErr error
+}
+
+func Sinks() []SinkStatus {
+	sinksMutex.Lock()
+	workers := sinks
+	sinksMutex.Unlock()
+	var out []SinkStatus
+	for _, w := range workers {
+		st := SinkStatus{Name: w.sink.Name(), Spool: w.spool}
+		if lines, err := readSpool(w.spool); err == nil {
+			st.Pending = len(lines)
+		}
+		w.mutex.Lock()
+		st.LastErr = w.lastErr
+		w.mutex.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// DrainSinks delivers every spool now and reports the first error from each sink
+func DrainSinks() []SinkStatus {
+	sinksMutex.Lock()
+	workers := sinks
+	sinksMutex.Unlock()
+	for _, w := range workers {
+		w.drain()
+	}
+	return Sinks()
+}
+
+// forward spools a newly appended entry for every sink and wakes their workers
+func forward(rec Record) {
+	sinksMutex.Lock()
+	workers := sinks
+	sinksMutex.Unlock()
+	if len(workers) == 0 {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	for _, w := range workers {
+		if err = appendSpool(w.spool, line); err != nil {
+			w.setErr(err)
+			continue
+		}
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(sinkRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.kick:
+		case <-ticker.C:
+		case <-w.stop:
+			w.drain()
+			return
+		}
+		w.drain()
+	}
+}
+
+func (w *sinkWorker) setErr(err error) {
+	w.mutex.Lock()
+	w.lastErr = err
+	w.mutex.Unlock()
+}
+
+// drain sends the spool oldest first and keeps whatever wasn't delivered. The spool lock is only
+// held to read and trim the spool, so entries can still be spooled while a slow sink is sending;
+// the drain lock keeps two drains from sending the same records.
+func (w *sinkWorker) drain() {
+	err := withLock(w.spool+".drain", func() error {
+		var lines [][]byte
+		err := withSpoolLock(w.spool, func() (err error) {
+			lines, err = readSpool(w.spool)
+			return err
+		})
+		if err != nil || len(lines) == 0 {
+			return err
+		}
+		recs := make([]Record, 0, len(lines))
+		lineOf := make([]int, 0, len(lines))
+		for i, l := range lines {
+			var r Record
+			if json.Unmarshal(l, &r) == nil {
+				recs = append(recs, r)
+				lineOf = append(lineOf, i)
+			}
+		}
+		sent, sendErr := w.sink.Send(recs)
+		// Lines are only ever appended while we send, so the ones read above are still first
+		done := len(lines)
+		if sent < len(recs) {
+			done = lineOf[sent]
+		}
+		if done > 0 {
+			if err = withSpoolLock(w.spool, func() error { return trimSpool(w.spool, done) }); err != nil {
+				return err
+			}
+		}
+		return sendErr
+	})
+	w.setErr(err)
+}
+
+func withSpoolLock(spool string, fn func() error) error {
+	return withLock(spool+".lock", fn)
+}
+
+// withLock runs fn holding an exclusive flock on path
+func withLock(path string, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+func appendSpool(spool string, line []byte) error {
+	return withSpoolLock(spool, func() error {
+		if st, err := os.Stat(spool); err == nil && st.Size() >= maxSpoolBytes {
+			return fmt.Errorf("spool %s is full; dropping records until the sink recovers", filepath.Base(spool))
+		}
+		file, err := os.OpenFile(spool, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = file.Write(append(line, '\n'))
+		return err
+	})
+}
+
+func readSpool(spool string) ([][]byte, error) {
+	file, err := os.Open(spool)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// trimSpool drops the first n lines of the spool
+func trimSpool(spool string, n int) error {
+	lines, err := readSpool(spool)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, l := range lines[min(n, len(lines)):] {
+		buf.Write(append(l, '\n'))
+	}
+	return writeFileAtomic(spool, buf.Bytes())
+}
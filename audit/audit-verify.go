@@ -2,97 +2,161 @@ package audit
 
 import (
 	"bufio"
-	hmac2 "crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 )
 
+// VerifyResult describes one log file. When OK is false, LastIndex and LastHashHex are those
+// of the last entry that still verified.
 type VerifyResult struct {
 	OK          bool
 	FailedLine  int
 	Reason      string
 	LastIndex   uint64
 	LastHashHex string
+	// SignKey is the key the log's entries are signed with, if any
+	SignKey string
 }
 
+// Verify checks a log with the HMAC key
 func Verify(path string, key []byte) (*VerifyResult, error) {
+	return VerifyWith(path, Verifier{HMACKey: key})
+}
+
+// VerifyWith checks a log with whatever v trusts. Signed logs can be checked with the public
+// key alone; with the HMAC key too, both are checked.
+func VerifyWith(path string, v Verifier) (*VerifyResult, error) {
+	fc, err := scanChain(path, v)
+	if err != nil {
+		return nil, err
+	}
+	return fc.result, nil
+}
+
+// fileChain is what a verified pass over one file yields. Only hashes and break records
+// from before the first failure are kept, since nothing after it can be trusted.
+type fileChain struct {
+	hdr    *header
+	result *VerifyResult
+	hashes map[string]uint64 // entry hash -> index; the seed maps to 0
+	breaks []Entry
+}
+
+func scanChain(path string, v Verifier) (*fileChain, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
+	return scanChainFrom(file, v)
+}
 
-	scanner := bufio.NewScanner(file)
+func scanChainFrom(r io.Reader, v Verifier) (*fileChain, error) {
+	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024) // 10MB max line size
 
 	lineNo := 0
+	var err error
 
 	if !scanner.Scan() {
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("audit: empty file")
 	}
 	lineNo++
 	var hdr header
 	if err = json.Unmarshal(scanner.Bytes(), &hdr); err != nil || hdr.Kind != "hdr" {
-		return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "invalid header"}, nil
+		return &fileChain{result: &VerifyResult{OK: false, FailedLine: lineNo, Reason: "invalid header"}}, nil
 	}
 
+	fc := &fileChain{hdr: &hdr, hashes: map[string]uint64{hdr.SeedHex: 0}}
 	prevHashHex := hdr.SeedHex
 	var lastIdx uint64
+	fail := func(reason string) (*fileChain, error) {
+		fc.result = &VerifyResult{OK: false, FailedLine: lineNo, Reason: reason, LastIndex: lastIdx, LastHashHex: prevHashHex, SignKey: hdr.SignKey}
+		return fc, nil
+	}
+	if hdr.Version > SchemaVersion {
+		return fail(fmt.Sprintf("log schema version %d is newer than this TUFWGo understands (%d)", hdr.Version, SchemaVersion))
+	}
+	signKey, reason := v.headerKey(hdr.SignKey)
+	if reason != "" {
+		return fail(reason)
+	}
 
 	for scanner.Scan() {
 		lineNo++
 		var se signedEntry
+		// The hash covers the entry as written, whatever shape this version reads it into
+		var raw struct {
+			Entry json.RawMessage `json:"entry"`
+		}
 		if err = json.Unmarshal(scanner.Bytes(), &se); err != nil {
-			return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "corrupted log"}, nil
+			return fail("corrupted log")
 		}
-		if se.Entry.Kind != "entry" {
-			return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "invalid log entry kind"}, nil
+		if err = json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			return fail("corrupted log")
+		}
+		if se.Entry.Kind != "entry" && se.Entry.Kind != "break" {
+			return fail("invalid log entry kind")
 		}
 
 		if subtle.ConstantTimeCompare([]byte(se.PrevHash), []byte(prevHashHex)) != 1 {
-			return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "broken hash chain"}, nil
+			return fail("broken hash chain")
 		}
 
-		entryJSON, _ := json.Marshal(se.Entry)
 		prevBytes, err := hex.DecodeString(prevHashHex)
 		if err != nil {
 			return nil, fmt.Errorf("audit: invalid prev hash: %v", err)
 		}
 		hash := sha256.New()
 		hash.Write(prevBytes)
-		hash.Write(entryJSON)
+		hash.Write(raw.Entry)
 		sum := hash.Sum(nil)
 		hashHex := hex.EncodeToString(sum)
 
 		if subtle.ConstantTimeCompare([]byte(se.Hash), []byte(hashHex)) != 1 {
-			return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "invalid entry hash"}, nil
+			return fail("invalid entry hash")
 		}
 
-		hmac := hmac2.New(sha256.New, key)
-		hmac.Write(sum)
-		expectedHMAC := hex.EncodeToString(hmac.Sum(nil))
-		if subtle.ConstantTimeCompare([]byte(se.HMAC), []byte(expectedHMAC)) != 1 {
-			return &VerifyResult{OK: false, FailedLine: lineNo, Reason: "invalid entry HMAC"}, nil
+		if v.hasHMAC() {
+			if reason := v.checkHMAC(se.KeyID, sum, se.HMAC); reason != "" {
+				return fail(reason)
+			}
+		}
+		if signKey != nil && !verifySig(signKey, entrySigTag, sum, se.Sig) {
+			return fail("invalid entry signature")
+		}
+		if se.Entry.Action == ActionAuditKeyRotate && !v.checkTransition(se.Entry) {
+			return fail("invalid audit key transition")
 		}
 
 		prevHashHex = hashHex
 		lastIdx = se.Entry.Index
+		fc.hashes[hashHex] = lastIdx
+		if se.Entry.Kind == "break" {
+			fc.breaks = append(fc.breaks, se.Entry)
+		}
 	}
 
 	if err = scanner.Err(); err != nil {
 		return nil, err
 	}
 
-	return &VerifyResult{
+	fc.result = &VerifyResult{
 		OK:          true,
 		FailedLine:  0,
 		Reason:      "",
 		LastIndex:   lastIdx,
 		LastHashHex: prevHashHex,
-	}, nil
+		SignKey:     hdr.SignKey,
+	}
+	return fc, nil
 }
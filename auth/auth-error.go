@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is the machine-readable reason tufwgo-auth gives for refusing a controller
+type ErrorCode string
+
+const (
+	ErrUnknownClient      ErrorCode = "unknown_client"
+	ErrRevoked            ErrorCode = "revoked"
+	ErrExpired            ErrorCode = "expired"
+	ErrBadSignature       ErrorCode = "bad_signature"
+	ErrClockSkew          ErrorCode = "clock_skew"
+	ErrReplay             ErrorCode = "replay"
+	ErrUnsupportedVersion ErrorCode = "unsupported_version"
+	ErrRoleDenied         ErrorCode = "role_denied"
+	ErrBadRequest         ErrorCode = "bad_request"
+	ErrInternal           ErrorCode = "internal"
+)
+
+// ProtocolVersions lists the handshake versions this build speaks, newest first.
+// Version 2 adds the client nonce and SSH session binding to the signed message.
+var ProtocolVersions = []string{"2"}
+
+// legacyProtocol is what a helper from before negotiation speaks; its CHALLENGE names no version.
+// Only the login handshake falls back to it, since such a helper has no executor or host log.
+const legacyProtocol = "1"
+
+// allowLegacy lets the login handshake fall back to legacyProtocol, which has no replay
+// protection, session binding or roles. It is off unless the operator opts in.
+var allowLegacy bool
+
+// SetAllowLegacy sets whether a helper that only speaks legacyProtocol is accepted at login
+func SetAllowLegacy(allow bool) {
+	allowLegacy = allow
+}
+
+// Algorithms lists the signature algorithms this build can prove a controller key with
+var Algorithms = []string{"ed25519"}
+
+// AuthError is a refusal from tufwgo-auth, or a negotiation failure detected by the client
+type AuthError struct {
+	Code   ErrorCode `json:"code"`
+	Reason string    `json:"reason"`
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+// NewAuthError builds a coded refusal; used by both ends of the protocol
+func NewAuthError(code ErrorCode, format string, args ...any) *AuthError {
+	return &AuthError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// remoteError turns an ERR message into an AuthError. Helpers that predate error codes only send a reason.
+func remoteError(code, reason string) *AuthError {
+	if reason == "" {
+		reason = "unknown error"
+	}
+	if code == "" {
+		code = string(ErrInternal)
+	}
+	return &AuthError{Code: ErrorCode(code), Reason: reason}
+}
+
+// Describe gives a short title and an explanation with the likely fix for an authentication error.
+// ok is false for errors that didn't come from the auth protocol.
+func Describe(err error) (title, message string, ok bool) {
+	var ae *AuthError
+	if !errors.As(err, &ae) {
+		return "", "", false
+	}
+	switch ae.Code {
+	case ErrUnknownClient:
+		return "Controller not recognised", "This host has no record of your controller key. Ask an administrator to add it with \"tufwgo-auth add-controller\".", true
+	case ErrRevoked:
+		return "Controller revoked", "Your controller key has been revoked on this host. If this is unexpected, ask an administrator; after a key rotation, make sure you are using the new key.", true
+	case ErrExpired:
+		return "Controller expired", "Your controller key has passed its expiry date on this host. Ask an administrator to extend it with \"tufwgo-auth set-expiry\".", true
+	case ErrBadSignature:
+		return "Signature rejected", "The host could not verify your controller signature. Your local key may not match the key registered on this host.", true
+	case ErrClockSkew:
+		return "Clock out of sync", "The time on this machine and the host differ by more than two minutes. Sync both clocks (e.g. with NTP) and try again.", true
+	case ErrReplay:
+		return "Request replay rejected", "The host has already seen this request. Try again; if it keeps happening, something may be replaying your traffic.", true
+	case ErrUnsupportedVersion:
+		return "Incompatible versions", "TUFWGo and the tufwgo-auth helper on this host don't share a protocol version. Update whichever is older.", true
+	case ErrRoleDenied:
+		return "Not permitted", "Your controller role on this host does not allow this action: " + ae.Reason, true
+	case ErrBadRequest:
+		return "Malformed request", "The host could not understand the request: " + ae.Reason, true
+	}
+	return "Authentication failed", ae.Reason, true
+}
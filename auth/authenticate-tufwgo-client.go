@@ -4,94 +4,183 @@ import (
 	"bufio"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 type hello struct {
-	Type          string `json:"type"`
-	ClientID      string `json:"client_id"`
-	ClientVersion string `json:"client_version"`
-	Algo          string `json:"algo"`
+	Type             string   `json:"type"`
+	ClientID         string   `json:"client_id"`
+	ClientVersion    string   `json:"client_version"`
+	Algo             string   `json:"algo"`
+	ProtocolVersions []string `json:"protocol_versions"`
+	Algos            []string `json:"algos"`
 }
 type challenge struct {
-	Type     string `json:"type"`
-	HostID   string `json:"host_id"`
-	NonceB64 string `json:"nonce_base64"`
+	Type            string `json:"type"`
+	HostID          string `json:"host_id"`
+	NonceB64        string `json:"nonce_base64"`
+	SessionB64      string `json:"session_base64"`
+	Connection      string `json:"connection"`
+	ProtocolVersion string `json:"protocol_version"`
+	Algo            string `json:"algo"`
+	// Set instead of the above when the helper refuses at HELLO
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
 }
 type proof struct {
 	Type     string `json:"type"`
 	ClientID string `json:"client_id"`
 	TSUnix   int64  `json:"ts_unix"`
+	Nonce    string `json:"nonce,omitempty"`
 	SigB64   string `json:"sig_base64"`
 }
-type ok struct{ Type string }
+type ok struct {
+	Type string `json:"type"`
+	Role string `json:"role"`
+}
 type er struct {
-	Type, Reason string
+	Type   string `json:"type"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func newHello(controllerID, clientVersion string) hello {
+	return hello{
+		Type:             "HELLO",
+		ClientID:         controllerID,
+		ClientVersion:    clientVersion,
+		Algo:             Algorithms[0],
+		ProtocolVersions: ProtocolVersions,
+		Algos:            Algorithms,
+	}
+}
+
+// readChallenge reads the helper's answer to HELLO and checks it picked something this build speaks.
+// legacyOK accepts a helper that predates negotiation, reporting it as legacyProtocol.
+func readChallenge(dec *json.Decoder, legacyOK bool) (*challenge, error) {
+	var chal challenge
+	if err := dec.Decode(&chal); err != nil {
+		return nil, fmt.Errorf("read CHALLENGE: %w", err)
+	}
+	if chal.Type == "ERR" {
+		return nil, remoteError(chal.Code, chal.Reason)
+	}
+	if chal.Type != "CHALLENGE" || chal.NonceB64 == "" {
+		return nil, errors.New("invalid CHALLENGE")
+	}
+	if chal.ProtocolVersion == "" {
+		if !legacyOK {
+			return nil, NewAuthError(ErrUnsupportedVersion, "tufwgo-auth on the host only speaks protocol 1, which has no replay protection or roles; install one built from auth/tufwgo-auth, or accept it with -legacy-auth")
+		}
+		chal.ProtocolVersion = legacyProtocol
+		return &chal, nil
+	}
+	if !slices.Contains(ProtocolVersions, chal.ProtocolVersion) {
+		return nil, NewAuthError(ErrUnsupportedVersion, "helper chose protocol %q, this build speaks %v", chal.ProtocolVersion, ProtocolVersions)
+	}
+	if !slices.Contains(Algorithms, chal.Algo) {
+		return nil, NewAuthError(ErrUnsupportedVersion, "helper chose algorithm %q, this build supports %v", chal.Algo, Algorithms)
+	}
+	return &chal, nil
+}
+
+// SessionBinding is the value a proof is bound to: sshd's SSH_CONNECTION for the helper's session,
+// naming both endpoints of that connection
+func SessionBinding(connection string) []byte {
+	sum := sha256.Sum256([]byte("TUFWGO-SESSION\x00" + connection))
+	return sum[:]
+}
+
+// binding derives the session binding from the connection the helper reports instead of signing
+// whatever bytes it sent, and checks the helper derived the same value
+func (c *challenge) binding() ([]byte, error) {
+	fields := strings.Fields(c.Connection)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("helper reported an invalid SSH connection %q", c.Connection)
+	}
+	for _, port := range []string{fields[1], fields[3]} {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("helper reported an invalid SSH connection %q", c.Connection)
+		}
+	}
+	session := SessionBinding(c.Connection)
+	if c.SessionB64 != base64.StdEncoding.EncodeToString(session) {
+		return nil, NewAuthError(ErrBadSignature, "helper's session binding does not match its connection %q", c.Connection)
+	}
+	return session, nil
 }
 
 // AuthenticateOverSSH runs the Ed25519 handshake with the remote helper.
 // controllerID: your short ID string (must exist in remote allowlist)
 // controllerPriv: your Ed25519 private key (64 bytes)
 // remoteCmd: executable on remote (e.g. "tufw-authd")
-func AuthenticateOverSSH(client *ssh.Client, controllerID, clientVersion, remoteCmd string, controllerPriv ed25519.PrivateKey) error {
+// It returns the role the remote allowlist grants this controller.
+func AuthenticateOverSSH(client *ssh.Client, controllerID, clientVersion, remoteCmd string, controllerPriv ed25519.PrivateKey) (Role, error) {
 	sess, err := client.NewSession()
 	if err != nil {
-		return fmt.Errorf("new session: %w", err)
+		return "", fmt.Errorf("new session: %w", err)
 	}
 	defer sess.Close()
 
 	stdin, err := sess.StdinPipe()
 	if err != nil {
-		return err
+		return "", err
 	}
 	stdout, err := sess.StdoutPipe()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if err = sess.Start(remoteCmd); err != nil {
-		return fmt.Errorf("start remote auth helper: %w", err)
+		return "", fmt.Errorf("start remote auth helper: %w", err)
 	}
 
 	enc := json.NewEncoder(stdin)
 	dec := json.NewDecoder(bufio.NewReader(stdout))
 
 	// 1) HELLO
-	h := hello{
-		Type:          "HELLO",
-		ClientID:      controllerID,
-		ClientVersion: clientVersion,
-		Algo:          "ed25519",
-	}
-	if err = enc.Encode(h); err != nil {
-		return fmt.Errorf("send HELLO: %w", err)
+	if err = enc.Encode(newHello(controllerID, clientVersion)); err != nil {
+		return "", fmt.Errorf("send HELLO: %w", err)
 	}
 
 	// 2) CHALLENGE
-	var chal challenge
-	if err = dec.Decode(&chal); err != nil {
-		return fmt.Errorf("read CHALLENGE: %w", err)
-	}
-	if chal.Type != "CHALLENGE" || chal.NonceB64 == "" {
-		return errors.New("invalid CHALLENGE")
+	chal, err := readChallenge(dec, allowLegacy)
+	if err != nil {
+		_ = sess.Wait()
+		return "", err
 	}
 	nonce, err := base64.StdEncoding.DecodeString(chal.NonceB64)
 	if err != nil {
-		return fmt.Errorf("nonce decode: %w", err)
+		return "", fmt.Errorf("nonce decode: %w", err)
+	}
+	// A version 1 helper signs over neither a client nonce nor the session, and has no roles
+	legacy := chal.ProtocolVersion == legacyProtocol
+	var session, clientNonce []byte
+	if !legacy {
+		if session, err = chal.binding(); err != nil {
+			return "", err
+		}
+		// The helper remembers client nonces so a captured proof can't be accepted twice
+		clientNonce = make([]byte, 16)
+		if _, err = rand.Read(clientNonce); err != nil {
+			return "", fmt.Errorf("client nonce: %w", err)
+		}
 	}
-	// optional: mix in a bit of client-side randomness (not required)
-	_, _ = rand.Read(make([]byte, 1))
 
 	// 3) PROOF (sign M)
 	now := time.Now().Unix()
-	M := buildMsg(nonce, chal.HostID, controllerID, now)
+	M := buildMsg(nonce, chal.HostID, controllerID, now, clientNonce, session)
 	sig := ed25519.Sign(controllerPriv, M)
 
 	p := proof{
@@ -100,34 +189,38 @@ func AuthenticateOverSSH(client *ssh.Client, controllerID, clientVersion, remote
 		TSUnix:   now,
 		SigB64:   base64.StdEncoding.EncodeToString(sig),
 	}
+	if !legacy {
+		p.Nonce = base64.StdEncoding.EncodeToString(clientNonce)
+	}
 	if err = enc.Encode(p); err != nil {
-		return fmt.Errorf("send PROOF: %w", err)
+		return "", fmt.Errorf("send PROOF: %w", err)
 	}
 
 	// 4) Receive final
 	// The helper returns either OK or ERR; decode into a generic map first.
 	var raw map[string]any
 	if err = dec.Decode(&raw); err != nil {
-		return fmt.Errorf("read final: %w", err)
+		return "", fmt.Errorf("read final: %w", err)
 	}
 	if t, _ := raw["type"].(string); t == "OK" {
+		role, _ := raw["role"].(string)
 		// wait for remote to exit cleanly
 		_ = sess.Wait()
-		return nil
+		if legacy {
+			return RoleFull, nil
+		}
+		return ParseRole(role), nil
 	}
 	// try parse reason
 	var e er
 	b, _ := json.Marshal(raw)
 	_ = json.Unmarshal(b, &e)
 	_ = sess.Wait()
-	if e.Reason == "" {
-		e.Reason = "unknown error"
-	}
-	return fmt.Errorf("remote auth failed: %s", e.Reason)
+	return "", remoteError(e.Code, e.Reason)
 }
 
-func buildMsg(nonce []byte, hostID, clientID string, ts int64) []byte {
-	msg := make([]byte, 0, len("TUFWGO-AUTH\x00")+len(nonce)+len(hostID)+len(clientID)+8)
+func buildMsg(nonce []byte, hostID, clientID string, ts int64, clientNonce, session []byte) []byte {
+	msg := make([]byte, 0, len("TUFWGO-AUTH\x00")+len(nonce)+len(hostID)+len(clientID)+8+len(clientNonce)+len(session))
 	msg = append(msg, []byte("TUFWGO-AUTH\x00")...)
 	msg = append(msg, nonce...)
 	msg = append(msg, []byte(hostID)...)
@@ -135,5 +228,7 @@ func buildMsg(nonce []byte, hostID, clientID string, ts int64) []byte {
 	var tsb [8]byte
 	binary.BigEndian.PutUint64(tsb[:], uint64(ts))
 	msg = append(msg, tsb[:]...)
+	msg = append(msg, clientNonce...)
+	msg = append(msg, session...)
 	return msg
 }
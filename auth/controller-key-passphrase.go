@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv lets unattended runs unlock the controller key without a prompt
+const PassphraseEnv = "TUFWGO_CONTROLLER_PASSPHRASE"
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	sealedAAD    = "tufwgo-controller-key"
+	maxUnlockTry = 3
+)
+
+type sealedKey struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt_b64"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce_b64"`
+	Ciphertext string `json:"ciphertext_b64"`
+}
+
+// readPassword reads a line from the terminal without echoing it. The controller supplies it, so
+// this package needs no terminal library when tufwgo-auth imports it.
+var readPassword func() ([]byte, error)
+
+// SetPasswordReader sets how passphrases are read from the terminal
+func SetPasswordReader(fn func() ([]byte, error)) {
+	readPassword = fn
+}
+
+// keySession remembers the passphrase and unlocked keys so the user is asked once per run
+var keySession = struct {
+	sync.Mutex
+	passphrase string
+	unlocked   map[string]ed25519.PrivateKey
+}{unlocked: map[string]ed25519.PrivateKey{}}
+
+func deriveKey(passphrase string, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, n, r, p, chacha20poly1305.KeySize)
+}
+
+func sealPrivKey(priv ed25519.PrivateKey, passphrase string) (*sealedKey, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &sealedKey{
+		KDF:        "scrypt",
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Cipher:     "xchacha20poly1305",
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, priv, []byte(sealedAAD))),
+	}, nil
+}
+
+func openPrivKey(sk *sealedKey, passphrase string) (ed25519.PrivateKey, error) {
+	if sk.KDF != "scrypt" || sk.Cipher != "xchacha20poly1305" {
+		return nil, fmt.Errorf("unsupported key encryption: %s/%s", sk.KDF, sk.Cipher)
+	}
+	salt, err := base64.StdEncoding.DecodeString(sk.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sk.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := base64.StdEncoding.DecodeString(sk.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(passphrase, salt, sk.N, sk.R, sk.P)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("bad nonce size")
+	}
+	priv, err := aead.Open(nil, nonce, ct, []byte(sealedAAD))
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key file")
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("bad private key size: %d", len(priv))
+	}
+	return priv, nil
+}
+
+func unlockPrivKey(sk *sealedKey) (ed25519.PrivateKey, error) {
+	keySession.Lock()
+	defer keySession.Unlock()
+
+	if priv, ok := keySession.unlocked[sk.Ciphertext]; ok {
+		return priv, nil
+	}
+
+	var candidates []string
+	if keySession.passphrase != "" {
+		candidates = append(candidates, keySession.passphrase)
+	}
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		candidates = append(candidates, env)
+	}
+	for _, pass := range candidates {
+		if priv, err := openPrivKey(sk, pass); err == nil {
+			keySession.passphrase = pass
+			keySession.unlocked[sk.Ciphertext] = priv
+			return priv, nil
+		}
+	}
+
+	var lastErr error
+	for i := 0; i < maxUnlockTry; i++ {
+		pass, err := promptPassphrase("Controller key passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		priv, err := openPrivKey(sk, pass)
+		if err != nil {
+			lastErr = err
+			fmt.Println("Incorrect passphrase.")
+			continue
+		}
+		keySession.passphrase = pass
+		keySession.unlocked[sk.Ciphertext] = priv
+		return priv, nil
+	}
+	return nil, fmt.Errorf("unable to unlock controller key: %w", lastErr)
+}
+
+// sessionPassphrase returns the passphrase used to seal keys written during this run
+func sessionPassphrase(allowPrompt bool) (string, error) {
+	keySession.Lock()
+	defer keySession.Unlock()
+
+	if keySession.passphrase != "" {
+		return keySession.passphrase, nil
+	}
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		keySession.passphrase = env
+		return env, nil
+	}
+	if !allowPrompt {
+		return "", errors.New("no controller key passphrase available")
+	}
+	pass, err := promptNewPassphrase()
+	if err != nil {
+		return "", err
+	}
+	keySession.passphrase = pass
+	return pass, nil
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	if readPassword == nil {
+		return "", fmt.Errorf("no terminal to read the passphrase from; set %s", PassphraseEnv)
+	}
+	fmt.Print(prompt)
+	pass, err := readPassword()
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("unable to read passphrase: %w", err)
+	}
+	return string(pass), nil
+}
+
+func promptNewPassphrase() (string, error) {
+	pass, err := promptPassphrase("New controller key passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass == "" {
+		return "", errors.New("a passphrase is required to protect the controller key")
+	}
+	confirm, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != confirm {
+		return "", errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// ChangeControllerPassphrase re-encrypts every stored controller key (current, pending and previous)
+// under a new passphrase. Keys still in plaintext are encrypted as part of the change.
+func ChangeControllerPassphrase() (int, error) {
+	type stored struct {
+		path  string
+		label string
+		priv  ed25519.PrivateKey
+	}
+	var keys []stored
+	for _, rel := range []string{controllerKeyPath, nextControllerKeyPath, prevControllerKeyPath} {
+		path := assertUserKeyPath(rel)
+		kf, err := readControllerKeyFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		priv, err := readControllerKey(path)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, stored{path: path, label: kf.Label, priv: priv})
+	}
+	if len(keys) == 0 {
+		return 0, errors.New("no controller key found")
+	}
+
+	pass, err := promptNewPassphrase()
+	if err != nil {
+		return 0, err
+	}
+	keySession.Lock()
+	keySession.passphrase = pass
+	keySession.Unlock()
+
+	for _, k := range keys {
+		if err = writeControllerKey(k.path, k.label, k.priv); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
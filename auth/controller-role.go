@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+)
+
+// Role is the controller role granted by the remote allowlist during the handshake
+type Role string
+
+const (
+	RoleReadOnly      Role = "read-only"
+	RoleAddOnly       Role = "add-only"
+	RoleProfileDeploy Role = "profile-deploy"
+	RoleFull          Role = "full"
+)
+
+// Permission is a kind of change TUFWGo may make on a remote host
+type Permission string
+
+const (
+	PermView     Permission = "view"
+	PermAdd      Permission = "add"
+	PermDelete   Permission = "delete"
+	PermProfile  Permission = "profile"
+	PermDefaults Permission = "defaults"
+)
+
+// ParseRole maps the helper's answer onto a Role. Anything unknown is treated as read-only.
+func ParseRole(s string) Role {
+	switch r := Role(strings.TrimSpace(s)); r {
+	case RoleReadOnly, RoleAddOnly, RoleProfileDeploy, RoleFull:
+		return r
+	}
+	return RoleReadOnly
+}
+
+func (r Role) Allows(p Permission) bool {
+	switch r {
+	case RoleFull:
+		return true
+	case RoleAddOnly:
+		return p == PermView || p == PermAdd
+	case RoleProfileDeploy:
+		return p == PermView || p == PermProfile
+	case RoleReadOnly:
+		return p == PermView
+	}
+	return false
+}
+
+// PermissionForCommand classifies a ufw command line by the permission it needs
+func PermissionForCommand(cmd string) Permission {
+	fields := strings.Fields(cmd)
+	if len(fields) > 0 && fields[0] == "sudo" {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || fields[0] != "ufw" {
+		return PermDefaults
+	}
+	var words []string
+	for _, f := range fields[1:] {
+		if !strings.HasPrefix(f, "-") {
+			words = append(words, f)
+		}
+	}
+	// route only says which table the rule goes in, the word after it is the action
+	if len(words) > 0 && words[0] == "route" {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return PermDefaults
+	}
+	switch words[0] {
+	case "status", "show", "version":
+		return PermView
+	case "app":
+		// app default and app update change policy, only list and info are reads
+		if len(words) > 1 && (words[1] == "list" || words[1] == "info") {
+			return PermView
+		}
+		return PermDefaults
+	case "allow", "deny", "reject", "limit", "insert", "prepend":
+		return PermAdd
+	case "delete":
+		return PermDelete
+	}
+	// enable, disable, default, reset, reload, logging and anything unrecognised
+	return PermDefaults
+}
+
+var (
+	roleMutex   sync.RWMutex
+	sessionRole = RoleFull
+)
+
+// SetSessionRole records the role granted for the SSH session the TUI is driving
+func SetSessionRole(r Role) {
+	roleMutex.Lock()
+	sessionRole = r
+	roleMutex.Unlock()
+}
+
+func GetSessionRole() Role {
+	roleMutex.RLock()
+	defer roleMutex.RUnlock()
+	return sessionRole
+}
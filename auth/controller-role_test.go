@@ -0,0 +1,39 @@
+package auth
+
+import "testing"
+
+func TestPermissionForCommand(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		want Permission
+	}{
+		{"ufw status numbered", PermView},
+		{"sudo ufw status verbose", PermView},
+		{"ufw app list", PermView},
+		{"ufw app info OpenSSH", PermView},
+		{"ufw app default allow", PermDefaults},
+		{"ufw app default deny", PermDefaults},
+		{"ufw app update --add-new OpenSSH", PermDefaults},
+		{"ufw app", PermDefaults},
+		{"ufw allow 22/tcp", PermAdd},
+		{"ufw --dry-run allow 22/tcp", PermAdd},
+		{"ufw insert 1 deny from 10.0.0.1", PermAdd},
+		{"ufw route allow in on eth0 out on eth1", PermAdd},
+		{"ufw route insert 1 allow in on eth0", PermAdd},
+		{"ufw delete 3", PermDelete},
+		{"ufw --force delete 3", PermDelete},
+		{"ufw route delete 3", PermDelete},
+		{"sudo ufw route delete allow in on eth0", PermDelete},
+		{"ufw route", PermDefaults},
+		{"ufw default deny incoming", PermDefaults},
+		{"ufw --force reset", PermDefaults},
+		{"ufw disable", PermDefaults},
+		{"ufw logging high", PermDefaults},
+		{"iptables -F", PermDefaults},
+	}
+	for _, c := range cases {
+		if got := PermissionForCommand(c.cmd); got != c.want {
+			t.Errorf("PermissionForCommand(%q) = %s, want %s", c.cmd, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const execTag = "TUFWGO-EXEC\x00"
+
+// ExecRequest is one ufw invocation sent to the remote executor. Sudo is "", "nopasswd" or "password"
+// and tells the executor how to get root; the sudo password itself travels unsigned over the SSH channel.
+// ApprovalB64 is the co-signed approval.Request a delete or default policy change runs under, if any.
+type ExecRequest struct {
+	Type         string   `json:"type"`
+	ClientID     string   `json:"client_id"`
+	Argv         []string `json:"argv"`
+	Input        string   `json:"input,omitempty"`
+	Sudo         string   `json:"sudo,omitempty"`
+	SudoPassword string   `json:"sudo_password,omitempty"`
+	ApprovalB64  string   `json:"approval_b64,omitempty"`
+	TSUnix       int64    `json:"ts_unix"`
+	Nonce        string   `json:"nonce"`
+	SigB64       string   `json:"sig_base64"`
+}
+
+// ExecReply is streamed back by the executor: OUT lines while ufw runs, then EXIT (or ERR if refused)
+type ExecReply struct {
+	Type      string `json:"type"`
+	Stream    string `json:"stream,omitempty"`
+	Line      string `json:"line,omitempty"`
+	Code      int    `json:"exit_code,omitempty"`
+	ErrorCode string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ExecMessage is what the controller signs for a request. Every variable-length part is length
+// prefixed so no two requests can encode to the same bytes. The approval is only appended when there
+// is one, so requests without it sign the same bytes as before.
+func ExecMessage(serverNonce []byte, hostID, clientID string, ts int64, clientNonce, session []byte, argv []string, input, sudo, approval string) []byte {
+	var msg []byte
+	put := func(b []byte) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+		msg = append(msg, n[:]...)
+		msg = append(msg, b...)
+	}
+	msg = append(msg, []byte(execTag)...)
+	put(serverNonce)
+	put([]byte(hostID))
+	put([]byte(clientID))
+	var tsb [8]byte
+	binary.BigEndian.PutUint64(tsb[:], uint64(ts))
+	msg = append(msg, tsb[:]...)
+	put(clientNonce)
+	put(session)
+	put([]byte(sudo))
+	var argc [4]byte
+	binary.BigEndian.PutUint32(argc[:], uint32(len(argv)))
+	msg = append(msg, argc[:]...)
+	for _, a := range argv {
+		put([]byte(a))
+	}
+	put([]byte(input))
+	if approval != "" {
+		put([]byte(approval))
+	}
+	return msg
+}
+
+// ExecOverSSH asks the remote executor to run argv after proving, for this request alone, that it
+// comes from controllerID. Output lines are passed to emit as they arrive and also returned.
+// approvalB64 is sent along with commands that run under a co-signed approval, and is otherwise empty.
+func ExecOverSSH(ctx context.Context, client *ssh.Client, controllerID string, controllerPriv ed25519.PrivateKey, remoteCmd string,
+	argv []string, input, sudo, sudoPassword, approvalB64 string, emit func(line string, stderr bool)) (string, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("new session: %w", err)
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err = sess.Start(remoteCmd + " exec"); err != nil {
+		return "", fmt.Errorf("start remote executor: %w", err)
+	}
+
+	// Closing the session unblocks the decoder if the caller gives up
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Signal(ssh.SIGKILL)
+		_ = sess.Close()
+	})
+	defer stop()
+
+	out, err := execExchange(stdin, stdout, controllerID, controllerPriv, argv, input, sudo, sudoPassword, approvalB64, emit)
+	if ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return out, fmt.Errorf("remote command timed out: %w", ctx.Err())
+		}
+		return out, fmt.Errorf("remote command cancelled: %w", ctx.Err())
+	}
+	_ = sess.Wait()
+	return out, err
+}
+
+func execExchange(w io.Writer, r io.Reader, controllerID string, controllerPriv ed25519.PrivateKey,
+	argv []string, input, sudo, sudoPassword, approvalB64 string, emit func(line string, stderr bool)) (string, error) {
+	enc := json.NewEncoder(w)
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	if err := enc.Encode(newHello(controllerID, "1.0")); err != nil {
+		return "", fmt.Errorf("send HELLO: %w", err)
+	}
+
+	chal, err := readChallenge(dec, false)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(chal.NonceB64)
+	if err != nil {
+		return "", fmt.Errorf("nonce decode: %w", err)
+	}
+	session, err := chal.binding()
+	if err != nil {
+		return "", err
+	}
+	clientNonce := make([]byte, 16)
+	if _, err = rand.Read(clientNonce); err != nil {
+		return "", fmt.Errorf("client nonce: %w", err)
+	}
+
+	now := time.Now().Unix()
+	sig := ed25519.Sign(controllerPriv, ExecMessage(nonce, chal.HostID, controllerID, now, clientNonce, session, argv, input, sudo, approvalB64))
+	req := ExecRequest{
+		Type:         "EXEC",
+		ClientID:     controllerID,
+		Argv:         argv,
+		Input:        input,
+		Sudo:         sudo,
+		SudoPassword: sudoPassword,
+		ApprovalB64:  approvalB64,
+		TSUnix:       now,
+		Nonce:        base64.StdEncoding.EncodeToString(clientNonce),
+		SigB64:       base64.StdEncoding.EncodeToString(sig),
+	}
+	if err = enc.Encode(req); err != nil {
+		return "", fmt.Errorf("send EXEC: %w", err)
+	}
+
+	var out, errOut strings.Builder
+	for {
+		var rep ExecReply
+		if err = dec.Decode(&rep); err != nil {
+			return out.String(), fmt.Errorf("read executor reply: %w", err)
+		}
+		switch rep.Type {
+		case "OUT":
+			isStderr := rep.Stream == "stderr"
+			out.WriteString(rep.Line + "\n")
+			if isStderr {
+				errOut.WriteString(rep.Line + "\n")
+			}
+			if emit != nil {
+				emit(rep.Line, isStderr)
+			}
+		case "EXIT":
+			if rep.Code != 0 {
+				return out.String(), errors.New(fmt.Sprint("stderr:", errOut.String()))
+			}
+			return out.String(), nil
+		case "ERR":
+			return out.String(), remoteError(rep.ErrorCode, rep.Reason)
+		default:
+			return out.String(), fmt.Errorf("unexpected executor reply %q", rep.Type)
+		}
+	}
+}
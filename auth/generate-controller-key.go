@@ -28,6 +28,11 @@ func EnsureControllerKey(label string) (string, string, ed25519.PrivateKey, bool
 		return "", "", nil, false, fmt.Errorf("unable to find controller key file: %w", err)
 	}
 
+	if _, err := migrateControllerKey(path); err != nil {
+		// Keep working with the plaintext key rather than locking the user out
+		fmt.Println("WARNING: controller key left unencrypted:", err)
+	}
+
 	priv, err := loadControllerPrivKey()
 	if err != nil {
 		return "", "", nil, false, fmt.Errorf("unable to load controller key file: %w", err)
@@ -55,63 +60,107 @@ func deriveIDsFromPrivKey(priv ed25519.PrivateKey) (clientID, pubKeyB64 string,
 	pub = make(ed25519.PublicKey, ed25519.PublicKeySize)
 	copy(pub, priv[ed25519.SeedSize:])
 
-	sum := sha256.Sum256(pub)
-	clientID = "ed25519:" + base64.RawStdEncoding.EncodeToString(sum[:8])
+	clientID = ControllerID(pub)
 	pubKeyB64 = "ed25519:" + base64.StdEncoding.EncodeToString(pub)
 	return
 }
 
+// ControllerID is the short id hosts know a controller public key by
+func ControllerID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "ed25519:" + base64.RawStdEncoding.EncodeToString(sum[:8])
+}
+
 func generateControllerID(label string) (clientID string, pubKeyB64 string, priv ed25519.PrivateKey, err error) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return "", "", nil, fmt.Errorf("unable to generate ed25519 key: %w", err)
 	}
 
-	sum := sha256.Sum256(pub)
-	clientID = "ed25519:" + base64.RawStdEncoding.EncodeToString(sum[:8])
-
+	clientID = ControllerID(pub)
 	pubKeyB64 = "ed25519:" + base64.StdEncoding.EncodeToString(pub)
 
-	path := assertUserKeyPath(controllerKeyPath)
-	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-		return "", "", nil, fmt.Errorf("unable to create directory: %w", err)
+	if err = writeControllerKey(assertUserKeyPath(controllerKeyPath), label, priv); err != nil {
+		return "", "", nil, err
 	}
+	return clientID, pubKeyB64, priv, nil
+}
+
+type controllerKeyFile struct {
+	Label string `json:"label"`
+	// Private holds an unencrypted key written before passphrase support; it is migrated on load
+	Private string     `json:"private_b64,omitempty"`
+	Sealed  *sealedKey `json:"sealed,omitempty"`
+}
 
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+// writeControllerKey seals the key with the session passphrase and replaces path atomically
+func writeControllerKey(path, label string, priv ed25519.PrivateKey) error {
+	passphrase, err := sessionPassphrase(true)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealPrivKey(priv, passphrase)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("unable to create key file: %w", err)
+		return fmt.Errorf("unable to encrypt controller key: %w", err)
 	}
-	defer file.Close()
+	return writeControllerKeyFile(path, controllerKeyFile{Label: label, Sealed: sealed})
+}
 
-	raw := struct {
-		Label   string `json:"label"`
-		Private string `json:"private_b64"`
-	}{
-		Label:   label,
-		Private: base64.StdEncoding.EncodeToString(priv),
+func writeControllerKeyFile(path string, kf controllerKeyFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
 	}
 
-	if err = json.NewEncoder(file).Encode(raw); err != nil {
-		return "", "", nil, fmt.Errorf("unable to write key file: %w", err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".controller.key-*")
+	if err != nil {
+		return fmt.Errorf("unable to create key file: %w", err)
 	}
-	return clientID, pubKeyB64, priv, nil
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(kf); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write key file: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write key file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write key file: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 func loadControllerPrivKey() (ed25519.PrivateKey, error) {
-	path := assertUserKeyPath(controllerKeyPath)
+	return readControllerKey(assertUserKeyPath(controllerKeyPath))
+}
+
+func readControllerKeyFile(path string) (*controllerKeyFile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-
-	var raw struct {
-		Private string `json:"private_b64"`
+	var kf controllerKeyFile
+	if err = json.Unmarshal(data, &kf); err != nil {
+		return nil, err
 	}
-	if err = json.Unmarshal(data, &raw); err != nil {
+	return &kf, nil
+}
+
+// readControllerKey unlocks a sealed key, asking for the passphrase at most once per session
+func readControllerKey(path string) (ed25519.PrivateKey, error) {
+	kf, err := readControllerKeyFile(path)
+	if err != nil {
 		return nil, err
 	}
+	if kf.Sealed != nil {
+		return unlockPrivKey(kf.Sealed)
+	}
 
-	b64, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw.Private))
+	b64, err := base64.StdEncoding.DecodeString(strings.TrimSpace(kf.Private))
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +171,26 @@ func loadControllerPrivKey() (ed25519.PrivateKey, error) {
 	return b64, nil
 }
 
+// migrateControllerKey encrypts a key file still stored in plaintext. It reports whether the file was rewritten.
+func migrateControllerKey(path string) (bool, error) {
+	kf, err := readControllerKeyFile(path)
+	if err != nil {
+		return false, err
+	}
+	if kf.Sealed != nil {
+		return false, nil
+	}
+	priv, err := readControllerKey(path)
+	if err != nil {
+		return false, err
+	}
+	fmt.Println("The controller key is stored unencrypted; choose a passphrase to encrypt it.")
+	if err = writeControllerKey(path, kf.Label, priv); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func assertUserKeyPath(rel string) string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, rel)
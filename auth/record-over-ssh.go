@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const recordTag = "TUFWGO-RECORD\x00"
+
+// HostRecord is the controller's account of a change, appended to the managed host's own audit
+// log. Ref is shared with the controller's entry so the two logs can be reconciled.
+type HostRecord struct {
+	Ref           string   `json:"ref"`
+	Controller    string   `json:"controller"`
+	ControllerLog string   `json:"controller_log,omitempty"`
+	Action        string   `json:"action"`
+	Result        string   `json:"result"`
+	Command       string   `json:"command,omitempty"`
+	ProfCommand   []string `json:"prof_command,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	// Payload is the controller entry's typed payload, written to the host entry as is
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Fields  []RecordField   `json:"fields,omitempty"`
+}
+
+type RecordField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// RecordRequest carries the record as the exact bytes that were signed
+type RecordRequest struct {
+	Type      string `json:"type"`
+	ClientID  string `json:"client_id"`
+	RecordB64 string `json:"record_base64"`
+	TSUnix    int64  `json:"ts_unix"`
+	Nonce     string `json:"nonce"`
+	SigB64    string `json:"sig_base64"`
+}
+
+// RecordReply says where the host wrote the record, or why it refused
+type RecordReply struct {
+	Type      string `json:"type"`
+	Log       string `json:"log,omitempty"`
+	Index     uint64 `json:"index,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	ErrorCode string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// RecordMessage is what the controller signs for a record, laid out like ExecMessage
+func RecordMessage(serverNonce []byte, hostID, clientID string, ts int64, clientNonce, session, record []byte) []byte {
+	var msg []byte
+	put := func(b []byte) {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+		msg = append(msg, n[:]...)
+		msg = append(msg, b...)
+	}
+	msg = append(msg, []byte(recordTag)...)
+	put(serverNonce)
+	put([]byte(hostID))
+	put([]byte(clientID))
+	var tsb [8]byte
+	binary.BigEndian.PutUint64(tsb[:], uint64(ts))
+	msg = append(msg, tsb[:]...)
+	put(clientNonce)
+	put(session)
+	put(record)
+	return msg
+}
+
+// RecordOverSSH has the remote helper append rec to the host's audit log, signed with the
+// controller key so the host knows which controller it came from
+func RecordOverSSH(ctx context.Context, client *ssh.Client, controllerID string, controllerPriv ed25519.PrivateKey, remoteCmd string, rec HostRecord) (*RecordReply, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new session: %w", err)
+	}
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = sess.Start(remoteCmd + " record"); err != nil {
+		return nil, fmt.Errorf("start remote helper: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Signal(ssh.SIGKILL)
+		_ = sess.Close()
+	})
+	defer stop()
+
+	rep, err := recordExchange(stdin, stdout, controllerID, controllerPriv, rec)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("host audit record: %w", ctx.Err())
+	}
+	_ = sess.Wait()
+	return rep, err
+}
+
+func recordExchange(w io.Writer, r io.Reader, controllerID string, controllerPriv ed25519.PrivateKey, rec HostRecord) (*RecordReply, error) {
+	enc := json.NewEncoder(w)
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	if err := enc.Encode(newHello(controllerID, "1.0")); err != nil {
+		return nil, fmt.Errorf("send HELLO: %w", err)
+	}
+	chal, err := readChallenge(dec, false)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(chal.NonceB64)
+	if err != nil {
+		return nil, fmt.Errorf("nonce decode: %w", err)
+	}
+	session, err := chal.binding()
+	if err != nil {
+		return nil, err
+	}
+	clientNonce := make([]byte, 16)
+	if _, err = rand.Read(clientNonce); err != nil {
+		return nil, fmt.Errorf("client nonce: %w", err)
+	}
+	record, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	sig := ed25519.Sign(controllerPriv, RecordMessage(nonce, chal.HostID, controllerID, now, clientNonce, session, record))
+	req := RecordRequest{
+		Type:      "RECORD",
+		ClientID:  controllerID,
+		RecordB64: base64.StdEncoding.EncodeToString(record),
+		TSUnix:    now,
+		Nonce:     base64.StdEncoding.EncodeToString(clientNonce),
+		SigB64:    base64.StdEncoding.EncodeToString(sig),
+	}
+	if err = enc.Encode(req); err != nil {
+		return nil, fmt.Errorf("send RECORD: %w", err)
+	}
+
+	var rep RecordReply
+	if err = dec.Decode(&rep); err != nil {
+		return nil, fmt.Errorf("read helper reply: %w", err)
+	}
+	switch rep.Type {
+	case "RECORDED":
+		return &rep, nil
+	case "ERR":
+		return nil, remoteError(rep.ErrorCode, rep.Reason)
+	}
+	return nil, errors.New("unexpected helper reply " + rep.Type)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	nextControllerKeyPath = ".config/tufwgo/controller.key.next"
+	prevControllerKeyPath = ".config/tufwgo/controller.key.prev"
+	rotateTag             = "TUFWGO-ROTATE\x00"
+)
+
+// PrepareNextControllerKey returns the pending replacement key, generating it on first use.
+// An interrupted rotation picks up the same key so hosts that already accepted it stay valid.
+func PrepareNextControllerKey(label string) (clientID, pubKeyB64 string, priv ed25519.PrivateKey, err error) {
+	path := assertUserKeyPath(nextControllerKeyPath)
+
+	if _, err = os.Stat(path); err == nil {
+		priv, err = readControllerKey(path)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("unable to load pending controller key: %w", err)
+		}
+		clientID, pubKeyB64, _, err = deriveIDsFromPrivKey(priv)
+		return clientID, pubKeyB64, priv, err
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", "", nil, fmt.Errorf("unable to find pending controller key: %w", err)
+	}
+
+	_, priv, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("unable to generate ed25519 key: %w", err)
+	}
+	if err = writeControllerKey(path, label, priv); err != nil {
+		return "", "", nil, err
+	}
+	clientID, pubKeyB64, _, err = deriveIDsFromPrivKey(priv)
+	return clientID, pubKeyB64, priv, err
+}
+
+// EndorseControllerKey signs the replacement public key with the current controller key,
+// letting hosts check that the rotation came from a controller they already trust
+func EndorseControllerKey(oldPriv ed25519.PrivateKey, oldID, newPubKeyB64 string) string {
+	sig := ed25519.Sign(oldPriv, RotateMessage(oldID, newPubKeyB64))
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// PromoteNextControllerKey makes the pending key current and keeps the old one as controller.key.prev
+func PromoteNextControllerKey() error {
+	cur := assertUserKeyPath(controllerKeyPath)
+	next := assertUserKeyPath(nextControllerKeyPath)
+	prev := assertUserKeyPath(prevControllerKeyPath)
+
+	if _, err := os.Stat(next); err != nil {
+		return fmt.Errorf("no pending controller key: %w", err)
+	}
+	if err := os.Rename(cur, prev); err != nil {
+		return fmt.Errorf("unable to keep previous controller key: %w", err)
+	}
+	if err := os.Rename(next, cur); err != nil {
+		_ = os.Rename(prev, cur)
+		return fmt.Errorf("unable to promote controller key: %w", err)
+	}
+	return nil
+}
+
+// DiscardNextControllerKey drops a pending key after a rotation has been abandoned
+func DiscardNextControllerKey() error {
+	err := os.Remove(assertUserKeyPath(nextControllerKeyPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PreviousControllerID returns the ID of the key retired by the last rotation
+func PreviousControllerID() (string, error) {
+	priv, err := readControllerKey(assertUserKeyPath(prevControllerKeyPath))
+	if err != nil {
+		return "", fmt.Errorf("no previous controller key: %w", err)
+	}
+	clientID, _, _, err := deriveIDsFromPrivKey(priv)
+	return clientID, err
+}
+
+// ForgetPreviousControllerKey removes the retired key once every host has revoked it
+func ForgetPreviousControllerKey() error {
+	err := os.Remove(assertUserKeyPath(prevControllerKeyPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RotateMessage is what the old controller key signs to endorse its replacement; tufwgo-auth
+// checks the endorsement against the same bytes
+func RotateMessage(oldID, newPubKeyB64 string) []byte {
+	buf := make([]byte, 0, len(rotateTag)+len(oldID)+1+len(newPubKeyB64))
+	buf = append(buf, []byte(rotateTag)...)
+	buf = append(buf, []byte(oldID)...)
+	buf = append(buf, 0)
+	buf = append(buf, []byte(newPubKeyB64)...)
+	return buf
+}
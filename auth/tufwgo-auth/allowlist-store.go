@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const allowlistRelPath = ".config/tufwgo/authorised_controllers.json"
+
+// allowlistVersion is the format written by this build. Version 2 stores every role explicitly
+// and keeps public keys in their "ed25519:" form.
+const allowlistVersion = 2
+
+// errUnchanged lets an update callback skip the write
+var errUnchanged = errors.New("allowlist unchanged")
+
+func allowlistPath() string {
+	return assertUserFilepath(allowlistRelPath)
+}
+
+// lockAllowlist serialises access to the allowlist across tufwgo-auth processes. The lock lives in
+// its own file because the allowlist itself is replaced by rename on every write.
+func lockAllowlist(how int) (func(), error) {
+	p := allowlistPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+	lock, err := os.OpenFile(p+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open allowlist lock: %w", err)
+	}
+	if err = syscall.Flock(int(lock.Fd()), how); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("lock allowlist: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
+
+// loadAllowlist reads the allowlist under a shared lock, upgrading older formats in memory
+func loadAllowlist() (*allowListFile, error) {
+	unlock, err := lockAllowlist(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return readAllowlist()
+}
+
+// updateAllowlist runs fn on the current allowlist and saves the result, holding an exclusive lock
+// throughout so concurrent edits can't overwrite each other
+func updateAllowlist(fn func(af *allowListFile) error) error {
+	unlock, err := lockAllowlist(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	af, err := readAllowlist()
+	if err != nil {
+		return err
+	}
+	if err = fn(af); err != nil {
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		return err
+	}
+	return writeAllowlist(af)
+}
+
+func readAllowlist() (*allowListFile, error) {
+	data, err := os.ReadFile(allowlistPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &allowListFile{Version: allowlistVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open allowlist: %w", err)
+	}
+	var af allowListFile
+	if err = json.Unmarshal(data, &af); err != nil {
+		return nil, fmt.Errorf("parse allowlist: %w", err)
+	}
+	if err = migrateAllowlist(&af); err != nil {
+		return nil, err
+	}
+	return &af, nil
+}
+
+// migrateAllowlist brings an older allowlist up to allowlistVersion. The upgraded form is only
+// written back by the next edit, so a read never modifies the file.
+func migrateAllowlist(af *allowListFile) error {
+	if af.Version > allowlistVersion {
+		return fmt.Errorf("allowlist version %d is newer than this tufwgo-auth supports (%d)", af.Version, allowlistVersion)
+	}
+	if af.Version < 2 {
+		for i := range af.Controllers {
+			c := &af.Controllers[i]
+			// Entries written before roles existed were full controllers
+			if c.Role == "" {
+				c.Role = "full"
+			}
+			if pub, _, err := normalizePubB64(c.PubKeyB64); err == nil {
+				c.PubKeyB64 = pub
+			}
+		}
+	}
+	af.Version = allowlistVersion
+	return nil
+}
+
+// writeAllowlist replaces the allowlist atomically, syncing the data and the rename to disk
+func writeAllowlist(af *allowListFile) error {
+	p := allowlistPath()
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".authorised_controllers-*.tmp")
+	if err != nil {
+		return fmt.Errorf("open tmp: %w", err)
+	}
+	tmp := f.Name()
+	fail := func(err error) error {
+		f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	af.Version = allowlistVersion
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(af); err != nil {
+		return fail(fmt.Errorf("encode: %w", err))
+	}
+	if err = f.Chmod(0600); err != nil {
+		return fail(err)
+	}
+	if err = f.Sync(); err != nil {
+		return fail(fmt.Errorf("sync: %w", err))
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// verifyCmd checks the allowlist for anything that would make a handshake behave unexpectedly
+// and exits non-zero if it finds a problem
+func verifyCmd() error {
+	p := allowlistPath()
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Older builds saved relative to the working directory, so edits may have landed elsewhere
+	if wd, err := os.Getwd(); err == nil {
+		stray := filepath.Join(wd, allowlistRelPath)
+		if stray != p {
+			if _, err = os.Stat(stray); err == nil {
+				report("stray allowlist at %s was written by an older tufwgo-auth and is ignored; merge it with add-controller", stray)
+			}
+		}
+	}
+
+	unlock, err := lockAllowlist(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("No allowlist at", p)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		report("%s is readable by others (mode %v); run chmod 600", p, info.Mode().Perm())
+	}
+	if dirInfo, err := os.Stat(filepath.Dir(p)); err == nil && dirInfo.Mode().Perm()&0022 != 0 {
+		report("%s is writable by others (mode %v)", filepath.Dir(p), dirInfo.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var af allowListFile
+	if err = json.Unmarshal(data, &af); err != nil {
+		return fmt.Errorf("parse allowlist: %w", err)
+	}
+	switch {
+	case af.Version > allowlistVersion:
+		report("version %d is newer than this tufwgo-auth supports (%d)", af.Version, allowlistVersion)
+	case af.Version < allowlistVersion:
+		fmt.Printf("Allowlist is version %d; it will be upgraded to %d on the next change\n", af.Version, allowlistVersion)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range af.Controllers {
+		if seen[c.ID] {
+			report("%s: duplicate id", c.ID)
+		}
+		seen[c.ID] = true
+	}
+	for _, c := range af.Controllers {
+		_, raw, err := normalizePubB64(c.PubKeyB64)
+		if err != nil {
+			report("%s: bad public key: %v", c.ID, err)
+		} else if id := shortIDFromPub(raw); id != c.ID {
+			report("%s: id does not match its public key (want %s)", c.ID, id)
+		}
+		if c.Role != "" && !validRole(c.Role) {
+			report("%s: unknown role %q", c.ID, c.Role)
+		}
+		if c.Expires != "" {
+			if _, err = parseExpiry(c.Expires); err != nil {
+				report("%s: %v", c.ID, err)
+			}
+		}
+		for name, ts := range map[string]string{"created": c.Created, "last_used": c.LastUsed, "revoke_at": c.RevokeAt} {
+			if ts == "" {
+				continue
+			}
+			if _, err = time.Parse(time.RFC3339, ts); err != nil {
+				report("%s: bad %s time %q", c.ID, name, ts)
+			}
+		}
+		if c.EndorsedBy != "" && !seen[c.EndorsedBy] {
+			report("%s: endorsed by unknown controller %s", c.ID, c.EndorsedBy)
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, pr := range problems {
+			fmt.Println("  -", pr)
+		}
+		return fmt.Errorf("%d problem(s) found in %s", len(problems), p)
+	}
+	fmt.Printf("Allowlist OK: %s (version %d, %d controller(s))\n", p, af.Version, len(af.Controllers))
+	return nil
+}
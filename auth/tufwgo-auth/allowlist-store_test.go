@@ -0,0 +1,86 @@
+package main
+
+import (
+	"TUFWGo/auth"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Each call opens its own lock file descriptor, so goroutines contend on the flock just as
+// separate tufwgo-auth processes do
+func TestConcurrentAddAndRevoke(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	const n = 20
+
+	newKey := func() (string, string) {
+		pub, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(pub), auth.ControllerID(pub)
+	}
+	var toRevoke, toAdd []string
+	var revokeIDs, addIDs []string
+	for i := 0; i < n; i++ {
+		pub, id := newKey()
+		toRevoke, revokeIDs = append(toRevoke, pub), append(revokeIDs, id)
+		pub, id = newKey()
+		toAdd, addIDs = append(toAdd, pub), append(addIDs, id)
+	}
+	for _, pub := range toRevoke {
+		if err := addControllerCmd(pub, "old", "", "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(pub string) {
+			defer wg.Done()
+			errs <- addControllerCmd(pub, "new", "", "", "", "")
+		}(toAdd[i])
+		go func(id string) {
+			defer wg.Done()
+			errs <- revokeControllerCmd(id, "")
+		}(revokeIDs[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(allowlistPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var af allowListFile
+	if err = json.Unmarshal(data, &af); err != nil {
+		t.Fatalf("allowlist is not valid JSON: %v", err)
+	}
+	byID := map[string]allowEntry{}
+	for _, e := range af.Controllers {
+		byID[e.ID] = e
+	}
+	if len(byID) != 2*n {
+		t.Fatalf("allowlist has %d controllers, want %d", len(byID), 2*n)
+	}
+	for _, id := range addIDs {
+		if e, ok := byID[id]; !ok || e.Revoked {
+			t.Errorf("added controller %s is missing or revoked", id)
+		}
+	}
+	for _, id := range revokeIDs {
+		if !byID[id].Revoked {
+			t.Errorf("controller %s was not revoked", id)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"TUFWGo/approval"
+	"TUFWGo/auth"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostKeyGlob finds this host's public SSH host keys, which approvals name their targets by
+var hostKeyGlob = "/etc/ssh/ssh_host_*_key.pub"
+
+// checkApproval confirms a co-signed change may run on this host. Nothing is recorded here: the
+// executor marks each approved command used once it has run, so a failed apply can be retried.
+func checkApproval(reqB64 string, hs *handshake) error {
+	r, err := decodeApproval(reqB64)
+	if err != nil {
+		return err
+	}
+	hs.clientID = r.ProposedBy
+	hs.label = fmt.Sprintf("approval %s, approved by %s", r.ID, r.ApprovedBy)
+	hs.command = strings.Join(r.Commands, "; ")
+
+	if err = verifyApproval(r, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("Approval %s accepted\n", r.ID)
+	return nil
+}
+
+// rejectApproval records a signed rejection, so this host refuses the request until it expires even
+// if a copy of the file is edited back to approved
+func rejectApproval(reqB64 string, hs *handshake) error {
+	r, err := decodeApproval(reqB64)
+	if err != nil {
+		return err
+	}
+	hs.clientID = r.RejectedBy
+	hs.label = fmt.Sprintf("approval %s rejected", r.ID)
+	hs.command = strings.Join(r.Commands, "; ")
+
+	if err = r.VerifyRejection(); err != nil {
+		return auth.NewAuthError(auth.ErrBadSignature, "%v", err)
+	}
+	pub, _, err := lookUpController(r.RejectedBy)
+	if err != nil {
+		return err
+	}
+	if base64.StdEncoding.EncodeToString(pub) != strings.TrimPrefix(r.RejectorPub, "ed25519:") {
+		return auth.NewAuthError(auth.ErrBadSignature, "%s signed with a key this host doesn't know", r.RejectedBy)
+	}
+	expires, err := time.Parse(time.RFC3339, r.Expires)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "bad expiry: %v", err)
+	}
+	now := time.Now()
+	if !now.Before(expires) {
+		fmt.Printf("Approval %s has already expired\n", r.ID)
+		return nil
+	}
+	// Rejecting twice is fine, the rejection only has to be on record
+	err = rememberUntil(assertUserFilepath(replayCachePath), rejectionID(r.ID), now, expires)
+	if err != nil && !errors.Is(err, errReplay) {
+		return err
+	}
+	fmt.Printf("Approval %s rejected\n", r.ID)
+	return nil
+}
+
+func rejectionID(approvalID string) string {
+	return proofID("reject\x00"+approvalID, nil)
+}
+
+func decodeApproval(reqB64 string) (*approval.Request, error) {
+	data, err := base64.StdEncoding.DecodeString(reqB64)
+	if err != nil {
+		return nil, auth.NewAuthError(auth.ErrBadRequest, "cannot decode approval: %v", err)
+	}
+	var r approval.Request
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, auth.NewAuthError(auth.ErrBadRequest, "cannot parse approval: %v", err)
+	}
+	return &r, nil
+}
+
+// verifyApproval checks both signatures, that the change names this host and hasn't been rejected
+// here, then that both controllers are active here with the keys they signed with and that both
+// roles allow every command in the change
+func verifyApproval(r *approval.Request, now time.Time) error {
+	if err := r.Verify(now); err != nil {
+		return auth.NewAuthError(auth.ErrBadSignature, "%v", err)
+	}
+	keys, err := hostKeyFingerprints()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(keys, func(k string) bool { return slices.Contains(r.HostKeys, k) }) {
+		return auth.NewAuthError(auth.ErrRoleDenied, "approval %s is not for this host", r.ID)
+	}
+	rejected, err := isRemembered(assertUserFilepath(replayCachePath), rejectionID(r.ID), now)
+	if err != nil {
+		return err
+	}
+	if rejected {
+		return auth.NewAuthError(auth.ErrRoleDenied, "approval %s has been rejected", r.ID)
+	}
+	signers := []struct{ id, pub string }{{r.ProposedBy, r.ProposerPub}, {r.ApprovedBy, r.ApproverPub}}
+	for _, s := range signers {
+		pub, entry, err := lookUpController(s.id)
+		if err != nil {
+			return err
+		}
+		if base64.StdEncoding.EncodeToString(pub) != strings.TrimPrefix(s.pub, "ed25519:") {
+			return auth.NewAuthError(auth.ErrBadSignature, "%s signed with a key this host doesn't know", s.id)
+		}
+		role := auth.ParseRole(entryRole(entry))
+		for _, p := range r.Permissions() {
+			if !role.Allows(p) {
+				return auth.NewAuthError(auth.ErrRoleDenied, "%s has role %s, which does not allow %s", s.id, role, p)
+			}
+		}
+	}
+	return nil
+}
+
+// execApproval decides whether an executor request may run as far as approvals go. A delete or
+// default policy change needs an approval covering that exact command when the host requires
+// approvals; any approval sent is checked either way. It returns the replay cache id reserving the
+// command under the approval, or "" when the request runs without one.
+func execApproval(req auth.ExecRequest, command string, requireApproval bool, now time.Time) (string, error) {
+	perm := auth.PermissionForCommand(command)
+	needed := perm == auth.PermDelete || perm == auth.PermDefaults
+	if req.ApprovalB64 == "" {
+		if needed && requireApproval {
+			return "", auth.NewAuthError(auth.ErrRoleDenied, "this host requires a co-signed approval for %s; propose it with 'tufwgo approvals'", perm)
+		}
+		return "", nil
+	}
+
+	r, err := decodeApproval(req.ApprovalB64)
+	if err != nil {
+		return "", err
+	}
+	if err = verifyApproval(r, now); err != nil {
+		return "", err
+	}
+	// Only the two signers may use the approval, not any controller that gets hold of the file
+	if req.ClientID != r.ProposedBy && req.ClientID != r.ApprovedBy {
+		return "", auth.NewAuthError(auth.ErrRoleDenied, "approval %s was not signed by %s", r.ID, req.ClientID)
+	}
+	approved := false
+	for _, c := range r.Commands {
+		if approvalCommand(c) == approvalCommand(command) {
+			approved = true
+			break
+		}
+	}
+	if !approved {
+		return "", auth.NewAuthError(auth.ErrRoleDenied, "approval %s does not cover %q", r.ID, command)
+	}
+
+	// Each approved command runs once per host; the reservation is dropped again if it fails
+	id := proofID("approval\x00"+r.ID, []byte(approvalCommand(command)))
+	expires, _ := time.Parse(time.RFC3339, r.Expires)
+	err = rememberUntil(assertUserFilepath(replayCachePath), id, now, expires)
+	if errors.Is(err, errReplay) {
+		return "", auth.NewAuthError(auth.ErrReplay, "approval %s has already been used for %q on this host", r.ID, command)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// approvalCommand normalises a command line so the approved text and the argv the executor gets compare equal
+func approvalCommand(cmd string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer(`"`, "", `'`, "").Replace(cmd)), " ")
+}
+
+// hostKeyFingerprints returns the SHA256 fingerprints of this host's SSH host keys
+func hostKeyFingerprints() ([]string, error) {
+	files, err := filepath.Glob(hostKeyGlob)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read host key: %w", err)
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f, err)
+		}
+		keys = append(keys, ssh.FingerprintSHA256(pub))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found at %s", hostKeyGlob)
+	}
+	return keys, nil
+}
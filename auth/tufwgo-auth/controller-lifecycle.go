@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// parseExpiry accepts either a date (expires at the start of that day, UTC) or an RFC3339 time
+func parseExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad expiry %q: want YYYY-MM-DD or RFC3339", s)
+	}
+	return t, nil
+}
+
+// entryState reports whether a controller may still authenticate: active, revoked or expired
+func entryState(e allowEntry, now time.Time) string {
+	if e.Revoked || revokeDue(e) {
+		return "revoked"
+	}
+	if e.Expires != "" {
+		at, err := parseExpiry(e.Expires)
+		// An unreadable expiry fails closed
+		if err != nil || !now.Before(at) {
+			return "expired"
+		}
+	}
+	return "active"
+}
+
+// lastActivity is when the controller was last used, falling back to when it was added
+func lastActivity(e allowEntry) (time.Time, bool) {
+	for _, s := range []string{e.LastUsed, e.Created} {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func humanAge(s string, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return "never"
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// touchLastUsed stamps a successful handshake on the controller's entry
+func touchLastUsed(id string, now time.Time) error {
+	return updateAllowlist(func(af *allowListFile) error {
+		for i := range af.Controllers {
+			if af.Controllers[i].ID == id {
+				af.Controllers[i].LastUsed = now.UTC().Format(time.RFC3339)
+				return nil
+			}
+		}
+		return fmt.Errorf("client id not found: %s", id)
+	})
+}
+
+func setExpiryCmd(id, expires string) error {
+	if id == "" {
+		return errors.New("missing --id")
+	}
+	if expires != "" {
+		if _, err := parseExpiry(expires); err != nil {
+			return err
+		}
+	}
+	err := updateAllowlist(func(af *allowListFile) error {
+		for i := range af.Controllers {
+			if af.Controllers[i].ID == id {
+				af.Controllers[i].Expires = expires
+				return nil
+			}
+		}
+		return fmt.Errorf("controller not found: %s", id)
+	})
+	if err != nil {
+		return err
+	}
+	if expires == "" {
+		fmt.Println("Expiry cleared:", id)
+	} else {
+		fmt.Println("Expiry set:", id, expires)
+	}
+	return nil
+}
+
+// pruneCmd revokes active controllers that haven't completed a handshake in the last days days
+func pruneCmd(days int, dryRun bool) error {
+	if days < 1 {
+		return errors.New("--days must be at least 1")
+	}
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+	pruned := 0
+	err := updateAllowlist(func(af *allowListFile) error {
+		for i := range af.Controllers {
+			c := &af.Controllers[i]
+			if entryState(*c, now) != "active" {
+				continue
+			}
+			last, ok := lastActivity(*c)
+			if ok && last.After(cutoff) {
+				continue
+			}
+			pruned++
+			fmt.Printf("%s  %-20s  last used %s\n", c.ID, c.Label, humanAge(c.LastUsed, now))
+			c.Revoked = true
+		}
+		if pruned == 0 || dryRun {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case pruned == 0:
+		fmt.Println("(nothing to prune)")
+	case dryRun:
+		fmt.Printf("%d controller(s) would be revoked\n", pruned)
+	default:
+		fmt.Printf("%d controller(s) revoked\n", pruned)
+	}
+	return nil
+}
+
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"TUFWGo/auth"
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runExec handles one signed ufw request. Unlike the login handshake, every request carries its
+// own proof, so a plain SSH login can't drive ufw through the executor without the controller key.
+// Nothing stops that login running ufw itself, though, unless sshd forces it through force-command
+// (see 'tufwgo-auth sshd-config').
+func runExec(in io.Reader, out io.Writer, hs *handshake) error {
+	decode := json.NewDecoder(bufio.NewReader(in))
+	encode := json.NewEncoder(out)
+
+	g, err := greet(decode, encode, hs)
+	if err != nil {
+		return err
+	}
+
+	var req auth.ExecRequest
+	if err = decode.Decode(&req); err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode request: %v", err)
+	}
+	if req.Type != "EXEC" || req.ClientID != hs.clientID {
+		return auth.NewAuthError(auth.ErrBadRequest, "invalid request")
+	}
+	hs.command = strings.Join(req.Argv, " ")
+
+	sig, err := base64.StdEncoding.DecodeString(req.SigB64)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode signature: %v", err)
+	}
+	clientNonce, err := base64.StdEncoding.DecodeString(req.Nonce)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode client nonce: %v", err)
+	}
+	if len(clientNonce) < minClientNonce {
+		return auth.NewAuthError(auth.ErrBadRequest, "missing or short client nonce")
+	}
+
+	msg := auth.ExecMessage(g.nonce, g.hostID, hs.clientID, req.TSUnix, clientNonce, g.session, req.Argv, req.Input, req.Sudo, req.ApprovalB64)
+	if !ed25519.Verify(g.pubkey, msg, sig) {
+		return auth.NewAuthError(auth.ErrBadSignature, "invalid signature")
+	}
+
+	if err = checkClock(req.TSUnix); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err = rememberProof(assertUserFilepath(replayCachePath), proofID("exec\x00"+hs.clientID, clientNonce), now); err != nil {
+		return err
+	}
+
+	if len(req.Argv) == 0 || req.Argv[0] != "ufw" {
+		return auth.NewAuthError(auth.ErrRoleDenied, "only ufw may be run through the executor")
+	}
+	role := auth.ParseRole(entryRole(g.entry))
+	hs.role = string(role)
+	if perm := auth.PermissionForCommand(hs.command); !role.Allows(perm) {
+		return auth.NewAuthError(auth.ErrRoleDenied, "role %s does not allow %s", role, perm)
+	}
+	af, err := loadAllowlist()
+	if err != nil {
+		return err
+	}
+	approvalUse, err := execApproval(req, hs.command, af.RequireApproval, now)
+	if err != nil {
+		return err
+	}
+	// A command that didn't run, or failed, leaves its approval free for a retry
+	ran := false
+	defer func() {
+		if approvalUse != "" && !ran {
+			if err := forgetProof(assertUserFilepath(replayCachePath), approvalUse, time.Now()); err != nil {
+				warnf("cannot release approval: %v", err)
+			}
+		}
+	}()
+	if err = touchLastUsed(hs.clientID, now); err != nil {
+		warnf("cannot record last use: %v", err)
+	}
+
+	cmd, stdin, err := ufwCommand(req)
+	if err != nil {
+		return err
+	}
+	code, err := streamCommand(cmd, stdin, encode)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		hs.exitCode = code
+	} else {
+		ran = true
+	}
+	return encode.Encode(auth.ExecReply{Type: "EXIT", Code: code})
+}
+
+// ufwCommand builds the ufw invocation without a shell, elevating through sudo as requested
+func ufwCommand(req auth.ExecRequest) (*exec.Cmd, string, error) {
+	ufw, err := exec.LookPath("ufw")
+	if err != nil {
+		ufw = "/usr/sbin/ufw"
+	}
+	args := req.Argv[1:]
+	switch req.Sudo {
+	case "":
+		return exec.Command(ufw, args...), req.Input, nil
+	case "nopasswd":
+		return exec.Command("sudo", append([]string{"-n", "--", ufw}, args...)...), req.Input, nil
+	case "password":
+		return exec.Command("sudo", append([]string{"-S", "-p", "", "--", ufw}, args...)...), req.SudoPassword + "\n" + req.Input, nil
+	}
+	return nil, "", fmt.Errorf("unknown sudo mode %q", req.Sudo)
+}
+
+// streamCommand relays each output line to the controller as it is written and returns the exit code
+func streamCommand(cmd *exec.Cmd, stdin string, encode *json.Encoder) (int, error) {
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err = cmd.Start(); err != nil {
+		return 0, fmt.Errorf("cannot start ufw: %w", err)
+	}
+
+	var encMutex sync.Mutex
+	var wg sync.WaitGroup
+	relay := func(r io.Reader, stream string) {
+		defer wg.Done()
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for s.Scan() {
+			encMutex.Lock()
+			_ = encode.Encode(auth.ExecReply{Type: "OUT", Stream: stream, Line: s.Text()})
+			encMutex.Unlock()
+		}
+	}
+	wg.Add(2)
+	go relay(stdout, "stdout")
+	go relay(stderr, "stderr")
+	wg.Wait()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The executor only restricts a controller if its SSH login can't run ufw directly. sshd does that
+// with a ForceCommand pointing at force-command, which lets through the helper subcommands below and
+// the controller's sudo preflight, and refuses everything else.
+
+// restrictedSubcommands are what a controller runs through tufwgo-auth; "" is the login handshake
+var restrictedSubcommands = []string{"", "exec", "record", "check-approval", "reject-approval"}
+
+// preflightCommands are the read-only checks the controller makes before choosing how to elevate
+var preflightCommands = map[string][]string{
+	"id -u":                {"id", "-u"},
+	"sudo -n -l ufw":       {"sudo", "-n", "-l", "ufw"},
+	"sudo -S -p '' -l ufw": {"sudo", "-S", "-p", "", "-l", "ufw"},
+}
+
+// forceCommand runs the command the SSH client asked for if a restricted login may run it
+func forceCommand() error {
+	requested := strings.TrimSpace(os.Getenv("SSH_ORIGINAL_COMMAND"))
+	if argv, ok := preflightCommands[requested]; ok {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+
+	args := strings.Fields(requested)
+	if len(args) == 0 || (args[0] != "tufwgo-auth" && args[0] != "/usr/bin/tufwgo-auth") {
+		return fmt.Errorf("this login only runs ufw through tufwgo-auth; refusing %q", requested)
+	}
+	args = args[1:]
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	if !slices.Contains(restrictedSubcommands, sub) {
+		return fmt.Errorf("tufwgo-auth %s has to be run on the host by an administrator, not over a restricted login", sub)
+	}
+	// The controller quotes flag values with %q; base64 and ids have nothing else to unescape
+	for i, a := range args {
+		if u, err := strconv.Unquote(a); err == nil {
+			args[i] = u
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(self, append([]string{self}, args...), os.Environ())
+}
+
+// sshdConfigCmd prints the sshd_config block that forces a user's logins through force-command
+func sshdConfigCmd(user string) error {
+	if user == "" {
+		return errors.New("missing --user")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Printf(`# Add to /etc/ssh/sshd_config, then reload sshd. TUFWGo logins as %[1]s can then only reach
+# ufw through the signed, role-checked executor, so controllers have to connect with -agent.
+# Adding, rotating and revoking controllers has to be done on the host from another account.
+Match User %[1]s
+	ForceCommand %[2]s force-command
+	DisableForwarding yes
+	PermitTTY no
+`, user, self)
+	return nil
+}
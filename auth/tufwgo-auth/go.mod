@@ -1,3 +1,12 @@
 module tufwgo-auth
 
 go 1.25
+
+require (
+	TUFWGo v0.0.0
+	golang.org/x/crypto v0.46.0
+)
+
+require golang.org/x/sys v0.39.0 // indirect
+
+replace TUFWGo => ../..
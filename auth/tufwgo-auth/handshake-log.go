@@ -0,0 +1,188 @@
+package main
+
+import (
+	"TUFWGo/audit"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	handshakeLogPath = ".config/tufwgo/auth-log/handshake.log"
+	handshakeKeyPath = ".config/tufwgo/auth-log/handshake.key"
+)
+
+// handshake collects what run learned about an attempt so it can be logged whatever the outcome
+type handshake struct {
+	action        audit.Action
+	clientID      string
+	clientVersion string
+	label         string
+	role          string
+	command       string // executor requests only
+	exitCode      int
+}
+
+// handshakeSource is the controller's address as seen by sshd
+func handshakeSource() string {
+	fields := strings.Fields(os.Getenv("SSH_CONNECTION"))
+	if len(fields) < 2 {
+		return "local"
+	}
+	return fields[0] + ":" + fields[1]
+}
+
+// loadHandshakeKey returns the host's HMAC key for the handshake log, creating it on first use
+func loadHandshakeKey() ([]byte, error) {
+	path := assertUserFilepath(handshakeKeyPath)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another handshake created it first
+		return loadHandshakeKey()
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err = file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// appendHostLog adds an entry to the host's chained log and returns its hash. Handshakes can run
+// concurrently, so the chain tail is read and extended under an exclusive lock.
+func appendHostLog(entry *audit.Entry) (string, error) {
+	key, err := loadHandshakeKey()
+	if err != nil {
+		return "", fmt.Errorf("handshake log key: %w", err)
+	}
+	path := assertUserFilepath(handshakeLogPath)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return "", err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	log, err := audit.Open(path, key, "")
+	if err != nil {
+		return "", err
+	}
+	defer log.Close()
+	if err = log.Append(entry); err != nil {
+		return "", err
+	}
+	return log.LastHash(), nil
+}
+
+// logHandshake appends one attempt to the chained log
+func logHandshake(hs *handshake, runErr error) error {
+	action := hs.action
+	if action == "" {
+		action = audit.ActionAuthHandshake
+	}
+	entry := &audit.Entry{
+		Actor:   hs.clientID,
+		Action:  action,
+		Command: hs.command,
+		Result:  "success",
+		Fields: []audit.Field{
+			{Name: "label", Value: hs.label},
+			{Name: "source", Value: handshakeSource()},
+			{Name: "client_version", Value: hs.clientVersion},
+		},
+	}
+	if hs.role != "" {
+		entry.Fields = append(entry.Fields, audit.Field{Name: "role", Value: hs.role})
+	}
+	if runErr != nil {
+		entry.Result = "failure"
+		entry.Error = runErr.Error()
+	} else if hs.exitCode != 0 {
+		entry.Result = "error"
+		entry.Error = fmt.Sprintf("ufw exited with status %d", hs.exitCode)
+	}
+	_, err := appendHostLog(entry)
+	return err
+}
+
+// showLogCmd verifies the handshake log and prints its most recent entries
+func showLogCmd(n int) error {
+	path := assertUserFilepath(handshakeLogPath)
+	key, err := loadHandshakeKey()
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		fmt.Println("(no handshakes logged)")
+		return nil
+	}
+
+	vr, err := audit.Verify(path, key)
+	if err != nil {
+		return err
+	}
+	entries, err := audit.ReadEntries(path)
+	if err != nil {
+		return err
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	fmt.Printf("%-6s  %-19s  %-20s  %-22s  %-8s  %s\n", "#", "TIME", "CONTROLLER", "SOURCE", "RESULT", "LABEL / REASON")
+	for _, e := range entries {
+		detail := fieldValue(e.Fields, "label")
+		if ref := fieldValue(e.Fields, "ref"); ref != "" {
+			detail = strings.TrimSpace(detail + "  ref=" + ref)
+		}
+		if e.Command != "" {
+			detail = strings.TrimSpace(detail + "  $ " + e.Command)
+		}
+		if e.Error != "" {
+			detail = strings.TrimSpace(detail + "  " + e.Error)
+		}
+		fmt.Printf("%-6d  %-19s  %-20s  %-22s  %-8s  %s\n", e.Index, e.Time, e.Actor, fieldValue(e.Fields, "source"), e.Result, detail)
+	}
+	fmt.Println()
+	if !vr.OK {
+		return fmt.Errorf("handshake log failed verification at line %d: %s", vr.FailedLine, vr.Reason)
+	}
+	fmt.Printf("Chain verified: %d entries intact\n", vr.LastIndex)
+	return nil
+}
+
+func fieldValue(fields []audit.Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
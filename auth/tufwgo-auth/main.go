@@ -1,6 +1,8 @@
 package main
 
 import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
 	"bufio"
 	"crypto/ed25519"
 	"crypto/rand"
@@ -14,14 +16,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
 
 const (
-	protoTag      = "TUFWGO-AUTH\x00"
-	allowListPath = ".config/tufwgo/authorised_controllers.json"
-	clockSkew     = 120 * time.Second
+	protoTag  = "TUFWGO-AUTH\x00"
+	clockSkew = 120 * time.Second
+	// minClientNonce is the least client randomness accepted in a proof
+	minClientNonce = 16
 )
 
 type Hello struct {
@@ -29,12 +33,21 @@ type Hello struct {
 	ClientID      string `json:"client_id"`
 	ClientVersion string `json:"client_version"`
 	Algo          string `json:"algo"`
+	// Offered by clients that negotiate; older ones only send Algo
+	ProtocolVersions []string `json:"protocol_versions"`
+	Algos            []string `json:"algos"`
 }
 
 type Challenge struct {
 	Type        string `json:"type"`
 	HostID      string `json:"host_id"`
 	NonceBase64 string `json:"nonce_base64"`
+	// SessionBase64 binds the proof to this SSH connection so it can't be replayed over another
+	SessionBase64 string `json:"session_base64"`
+	// Connection is what the session binding is derived from, so the controller can derive it too
+	Connection      string `json:"connection"`
+	ProtocolVersion string `json:"protocol_version"`
+	Algo            string `json:"algo"`
 }
 
 type Proof struct {
@@ -47,16 +60,30 @@ type Proof struct {
 
 type OK struct {
 	Type string `json:"type"`
+	Role string `json:"role"`
 }
 
 type ERR struct {
 	Type   string `json:"type"`
+	Code   string `json:"code"`
 	Reason string `json:"reason"`
 }
 
+// errReply carries the error code when there is one, so the controller can explain the refusal
+func errReply(err error) ERR {
+	var ae *auth.AuthError
+	if errors.As(err, &ae) {
+		return ERR{Type: "ERR", Code: string(ae.Code), Reason: ae.Reason}
+	}
+	return ERR{Type: "ERR", Code: string(auth.ErrInternal), Reason: err.Error()}
+}
+
 type allowListFile struct {
 	Version     int          `json:"version"`
 	Controllers []allowEntry `json:"controllers"`
+	// RequireApproval has the executor refuse deletes and default policy changes without a co-signed
+	// approval. It only covers every path when sshd forces controller logins through force-command.
+	RequireApproval bool `json:"require_approval,omitempty"`
 }
 
 type allowEntry struct {
@@ -66,6 +93,32 @@ type allowEntry struct {
 	Revoked   bool   `json:"revoked"`
 	Created   string `json:"created,omitempty"`
 	LastUsed  string `json:"last_used,omitempty"`
+	// EndorsedBy is the controller whose key signed this one in during a rotation
+	EndorsedBy string `json:"endorsed_by,omitempty"`
+	// RevokeAt retires the controller automatically once a rotation overlap window ends
+	RevokeAt string `json:"revoke_at,omitempty"`
+	// Role limits what the controller may change; empty means full for entries made before roles
+	Role string `json:"role,omitempty"`
+	// Expires is an optional YYYY-MM-DD or RFC3339 time after which the controller is refused
+	Expires string `json:"expires,omitempty"`
+}
+
+var roles = []string{"read-only", "add-only", "profile-deploy", "full"}
+
+func validRole(r string) bool {
+	for _, v := range roles {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+func entryRole(e allowEntry) string {
+	if e.Role == "" {
+		return "full"
+	}
+	return e.Role
 }
 
 func main() {
@@ -75,8 +128,12 @@ func main() {
 			fs := flag.NewFlagSet("add-controller", flag.ExitOnError)
 			pub := fs.String("pub", "", "controller public key (ed25519:BASE64 or BASE64)")
 			lbl := fs.String("label", "", "label for this controller")
+			endorsedBy := fs.String("endorsed-by", "", "id of the existing controller rotating to this key")
+			endorsement := fs.String("endorsement", "", "signature over the new key by --endorsed-by (base64)")
+			role := fs.String("role", "", "role for a new controller: "+strings.Join(roles, ", ")+" (default full)")
+			expires := fs.String("expires", "", "optional expiry (YYYY-MM-DD or RFC3339)")
 			_ = fs.Parse(os.Args[2:])
-			if err := addControllerCmd(*pub, *lbl); err != nil {
+			if err := addControllerCmd(*pub, *lbl, *endorsedBy, *endorsement, *role, *expires); err != nil {
 				fmt.Fprintln(os.Stderr, "error:", err)
 				os.Exit(1)
 			}
@@ -87,11 +144,131 @@ func main() {
 				os.Exit(1)
 			}
 			return
+		case "set-role":
+			fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+			id := fs.String("id", "", "controller id")
+			role := fs.String("role", "", "new role: "+strings.Join(roles, ", "))
+			_ = fs.Parse(os.Args[2:])
+			if err := setRoleCmd(*id, *role); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "require-approval":
+			fs := flag.NewFlagSet("require-approval", flag.ExitOnError)
+			off := fs.Bool("off", false, "let the executor run deletes and default policy changes without an approval again")
+			_ = fs.Parse(os.Args[2:])
+			if err := requireApprovalCmd(!*off); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "exec":
+			hs := &handshake{action: audit.ActionAuthExec}
+			err := runExec(os.Stdin, os.Stdout, hs)
+			if logErr := logHandshake(hs, err); logErr != nil {
+				warnf("cannot write handshake log: %v", logErr)
+			}
+			if err != nil {
+				_ = json.NewEncoder(os.Stdout).Encode(errReply(err))
+				os.Exit(1)
+			}
+			return
+		case "record":
+			hs := &handshake{action: audit.ActionAuthRecord}
+			if err := runRecord(os.Stdin, os.Stdout, hs); err != nil {
+				// A record that made it into the log is its own entry; only refusals are logged here
+				if logErr := logHandshake(hs, err); logErr != nil {
+					warnf("cannot write handshake log: %v", logErr)
+				}
+				_ = json.NewEncoder(os.Stdout).Encode(errReply(err))
+				os.Exit(1)
+			}
+			return
+		case "check-approval":
+			fs := flag.NewFlagSet("check-approval", flag.ExitOnError)
+			req := fs.String("request", "", "co-signed approval request (base64 JSON)")
+			_ = fs.Parse(os.Args[2:])
+			hs := &handshake{action: audit.ActionAuthApproval}
+			err := checkApproval(*req, hs)
+			if logErr := logHandshake(hs, err); logErr != nil {
+				warnf("cannot write handshake log: %v", logErr)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "reject-approval":
+			fs := flag.NewFlagSet("reject-approval", flag.ExitOnError)
+			req := fs.String("request", "", "approval request with a signed rejection (base64 JSON)")
+			_ = fs.Parse(os.Args[2:])
+			hs := &handshake{action: audit.ActionAuthApproval}
+			err := rejectApproval(*req, hs)
+			if logErr := logHandshake(hs, err); logErr != nil {
+				warnf("cannot write handshake log: %v", logErr)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "force-command":
+			if err := forceCommand(); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "sshd-config":
+			fs := flag.NewFlagSet("sshd-config", flag.ExitOnError)
+			user := fs.String("user", "", "account TUFWGo controllers log in as")
+			_ = fs.Parse(os.Args[2:])
+			if err := sshdConfigCmd(*user); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "verify":
+			if err := verifyCmd(); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "log":
+			fs := flag.NewFlagSet("log", flag.ExitOnError)
+			n := fs.Int("n", 50, "show the last n entries (0 for all)")
+			_ = fs.Parse(os.Args[2:])
+			if err := showLogCmd(*n); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "set-expiry":
+			fs := flag.NewFlagSet("set-expiry", flag.ExitOnError)
+			id := fs.String("id", "", "controller id")
+			expires := fs.String("expires", "", "expiry (YYYY-MM-DD or RFC3339); empty clears it")
+			_ = fs.Parse(os.Args[2:])
+			if err := setExpiryCmd(*id, *expires); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		case "prune":
+			fs := flag.NewFlagSet("prune", flag.ExitOnError)
+			days := fs.Int("days", 90, "revoke controllers unused for this many days")
+			dryRun := fs.Bool("dry-run", false, "only list what would be revoked")
+			_ = fs.Parse(os.Args[2:])
+			if err := pruneCmd(*days, *dryRun); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
 		case "revoke-controller":
 			fs := flag.NewFlagSet("revoke-controller", flag.ExitOnError)
 			id := fs.String("id", "", "controller id to revoke")
+			after := fs.String("after", "", "revoke once this RFC3339 time has passed instead of now")
 			_ = fs.Parse(os.Args[2:])
-			if err := revokeControllerCmd(*id); err != nil {
+			if err := revokeControllerCmd(*id, *after); err != nil {
 				fmt.Fprintln(os.Stderr, "error:", err)
 				os.Exit(1)
 			}
@@ -99,81 +276,171 @@ func main() {
 		}
 	}
 
-	err := run(os.Stdin, os.Stdout, os.Stderr)
+	hs := &handshake{}
+	err := run(os.Stdin, os.Stdout, os.Stderr, hs)
+	if logErr := logHandshake(hs, err); logErr != nil {
+		warnf("cannot write handshake log: %v", logErr)
+	}
 	if err != nil {
-		_ = json.NewEncoder(os.Stdout).Encode(ERR{Type: "ERR", Reason: err.Error()})
+		_ = json.NewEncoder(os.Stdout).Encode(errReply(err))
 		os.Exit(1)
 	}
 }
 
-func run(in io.Reader, out io.Writer, _ io.Writer) error {
-	decode := json.NewDecoder(bufio.NewReader(in))
-	encode := json.NewEncoder(out)
+// nonceSource is where challenge nonces come from; tests swap in a fixed one to replay a proof
+var nonceSource = rand.Reader
 
+// greeting is what the server has committed to once the controller has been challenged
+type greeting struct {
+	pubkey  ed25519.PublicKey
+	entry   allowEntry
+	hostID  string
+	nonce   []byte
+	session []byte
+}
+
+// greet reads HELLO, looks the controller up and sends a fresh CHALLENGE
+func greet(decode *json.Decoder, encode *json.Encoder, hs *handshake) (*greeting, error) {
 	var hello Hello
 	err := decode.Decode(&hello)
 	if err != nil {
-		return fmt.Errorf("cannot decode hello: %w", err)
+		return nil, auth.NewAuthError(auth.ErrBadRequest, "cannot decode hello: %v", err)
 	}
 
-	if hello.Type != "HELLO" || strings.ToLower(hello.Algo) != "ed25519" || hello.ClientID == "" {
-		return errors.New("invalid hello")
+	hs.clientID = hello.ClientID
+	hs.clientVersion = hello.ClientVersion
+	if hello.Type != "HELLO" || hello.ClientID == "" {
+		return nil, auth.NewAuthError(auth.ErrBadRequest, "invalid hello")
+	}
+	version, algo, err := negotiate(hello)
+	if err != nil {
+		return nil, err
 	}
 
-	pubkey, err := lookUpControllerPubKey(hello.ClientID)
+	pubkey, entry, err := lookUpController(hello.ClientID)
+	hs.label = entry.Label
 	if err != nil {
-		return fmt.Errorf("cannot look up controller pubkey: %w", err)
+		return nil, err
 	}
 
-	hostID := getHostID()
-	nonce := make([]byte, 32)
-	if _, err = rand.Read(nonce); err != nil {
-		return fmt.Errorf("cannot generate nonce: %w", err)
+	g := &greeting{pubkey: pubkey, entry: entry, hostID: getHostID(), nonce: make([]byte, 32), session: auth.SessionBinding(sshConnection())}
+	if _, err = io.ReadFull(nonceSource, g.nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %w", err)
 	}
 
 	challenge := Challenge{
-		Type:        "CHALLENGE",
-		HostID:      hostID,
-		NonceBase64: base64.StdEncoding.EncodeToString(nonce),
+		Type:            "CHALLENGE",
+		HostID:          g.hostID,
+		NonceBase64:     base64.StdEncoding.EncodeToString(g.nonce),
+		SessionBase64:   base64.StdEncoding.EncodeToString(g.session),
+		Connection:      sshConnection(),
+		ProtocolVersion: version,
+		Algo:            algo,
+	}
+	if err = encode.Encode(challenge); err != nil {
+		return nil, fmt.Errorf("cannot encode challenge: %w", err)
+	}
+	return g, nil
+}
+
+// negotiate picks the newest protocol version and an algorithm both sides support.
+// A client that offers no versions predates negotiation and speaks version 1.
+func negotiate(hello Hello) (string, string, error) {
+	offered := hello.ProtocolVersions
+	if len(offered) == 0 {
+		offered = []string{"1"}
+	}
+	version := ""
+	for _, v := range auth.ProtocolVersions {
+		if slices.Contains(offered, v) {
+			version = v
+			break
+		}
+	}
+	if version == "" {
+		return "", "", auth.NewAuthError(auth.ErrUnsupportedVersion, "client offers protocol %v, host supports %v", offered, auth.ProtocolVersions)
+	}
+
+	algos := hello.Algos
+	if len(algos) == 0 {
+		algos = []string{strings.ToLower(hello.Algo)}
+	}
+	for _, a := range auth.Algorithms {
+		if slices.Contains(algos, a) {
+			return version, a, nil
+		}
 	}
-	err = encode.Encode(challenge)
+	return "", "", auth.NewAuthError(auth.ErrUnsupportedVersion, "client offers algorithms %v, host supports %v", algos, auth.Algorithms)
+}
+
+func run(in io.Reader, out io.Writer, _ io.Writer, hs *handshake) error {
+	decode := json.NewDecoder(bufio.NewReader(in))
+	encode := json.NewEncoder(out)
+
+	g, err := greet(decode, encode, hs)
 	if err != nil {
-		return fmt.Errorf("cannot encode challenge: %w", err)
+		return err
 	}
+	pubkey, entry, nonce, hostID, session := g.pubkey, g.entry, g.nonce, g.hostID, g.session
 
 	var proof Proof
 	err = decode.Decode(&proof)
 	if err != nil {
-		return fmt.Errorf("cannot decode proof: %w", err)
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode proof: %v", err)
 	}
-	if proof.Type != "PROOF" || proof.ClientID != hello.ClientID {
-		return errors.New("invalid proof")
+	if proof.Type != "PROOF" || proof.ClientID != hs.clientID {
+		return auth.NewAuthError(auth.ErrBadRequest, "invalid proof")
 	}
 
 	sig, err := base64.StdEncoding.DecodeString(proof.SigBase64)
 	if err != nil {
-		return fmt.Errorf("cannot decode signature: %w", err)
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode signature: %v", err)
+	}
+	clientNonce, err := base64.StdEncoding.DecodeString(proof.Nonce)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode client nonce: %v", err)
+	}
+	if len(clientNonce) < minClientNonce {
+		return auth.NewAuthError(auth.ErrBadRequest, "missing or short client nonce")
 	}
 
-	M := buildMessage(nonce, hostID, hello.ClientID, proof.TSUnix)
+	M := buildMessage(nonce, hostID, hs.clientID, proof.TSUnix, clientNonce, session)
 	if !ed25519.Verify(pubkey, M, sig) {
-		return errors.New("invalid signature")
+		return auth.NewAuthError(auth.ErrBadSignature, "invalid signature")
 	}
+	if err = checkClock(proof.TSUnix); err != nil {
+		return err
+	}
+	now := time.Now()
 
+	// Only signed proofs reach the cache, so it can't be filled by unauthenticated callers
+	if err = rememberProof(assertUserFilepath(replayCachePath), proofID(hs.clientID, clientNonce), now); err != nil {
+		return err
+	}
+	if err = touchLastUsed(hs.clientID, now); err != nil {
+		// Bookkeeping only; the controller has already proven itself
+		warnf("cannot record last use: %v", err)
+	}
+
+	role := entryRole(entry)
+	hs.role = role
+	return encode.Encode(OK{Type: "OK", Role: role})
+}
+
+func checkClock(tsUnix int64) error {
 	now := time.Now()
-	ts := time.Unix(proof.TSUnix, 0)
+	ts := time.Unix(tsUnix, 0)
 	if ts.Before(now.Add(-clockSkew)) || ts.After(now.Add(clockSkew)) {
-		return errors.New("invalid timestamp")
+		return auth.NewAuthError(auth.ErrClockSkew, "controller clock is %s off the host's (limit %s)", now.Sub(ts).Round(time.Second), clockSkew)
 	}
-
-	return encode.Encode(OK{Type: "OK"})
+	return nil
 }
 
-func buildMessage(nonce []byte, hostID, clientID string, ts int64) []byte {
+func buildMessage(nonce []byte, hostID, clientID string, ts int64, clientNonce, session []byte) []byte {
 	hostIDByte := []byte(hostID)
 	clientIDByte := []byte(clientID)
 
-	buf := make([]byte, 0, len(protoTag)+len(nonce)+len(hostIDByte)+len(clientIDByte)+8)
+	buf := make([]byte, 0, len(protoTag)+len(nonce)+len(hostIDByte)+len(clientIDByte)+8+len(clientNonce)+len(session))
 	buf = append(buf, []byte(protoTag)...)
 	buf = append(buf, nonce...)
 	buf = append(buf, hostIDByte...)
@@ -182,32 +449,53 @@ func buildMessage(nonce []byte, hostID, clientID string, ts int64) []byte {
 	var tsbuf [8]byte
 	binary.BigEndian.PutUint64(tsbuf[:], uint64(ts))
 	buf = append(buf, tsbuf[:]...)
+	buf = append(buf, clientNonce...)
+	buf = append(buf, session...)
 	return buf
 }
 
-func lookUpControllerPubKey(clientID string) (ed25519.PublicKey, error) {
-	path := assertUserFilepath(allowListPath)
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("cannot open allow list file: %w", err)
-	}
-	defer file.Close()
+// sshConnection identifies the SSH connection this helper runs under. sshd sets
+// SSH_CONNECTION to both endpoints, which differ for every connection.
+func sshConnection() string {
+	return os.Getenv("SSH_CONNECTION")
+}
 
-	var alf allowListFile
-	err = json.NewDecoder(file).Decode(&alf)
+// lookUpController returns the public key of an active controller. The entry is returned even
+// when the controller is revoked or expired so the handshake log can name who was refused.
+func lookUpController(clientID string) (ed25519.PublicKey, allowEntry, error) {
+	alf, err := loadAllowlist()
 	if err != nil {
-		return nil, fmt.Errorf("cannot decode allow list file: %w", err)
+		return nil, allowEntry{}, err
 	}
 
+	now := time.Now()
 	for _, e := range alf.Controllers {
-		if e.Revoked {
+		if e.ID != clientID {
 			continue
 		}
-		if e.ID == clientID {
-			return parseEd25519PubKey(e.PubKeyB64)
+		if state := entryState(e, now); state != "active" {
+			code := auth.ErrRevoked
+			if state == "expired" {
+				code = auth.ErrExpired
+			}
+			return nil, e, auth.NewAuthError(code, "controller %s", state)
 		}
+		pub, err := parseEd25519PubKey(e.PubKeyB64)
+		return pub, e, err
 	}
-	return nil, fmt.Errorf("client id not found: %s", clientID)
+	return nil, allowEntry{}, auth.NewAuthError(auth.ErrUnknownClient, "client id not found: %s", clientID)
+}
+
+func revokeDue(e allowEntry) bool {
+	if e.RevokeAt == "" {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, e.RevokeAt)
+	if err != nil {
+		// An unreadable deadline fails closed
+		return true
+	}
+	return !time.Now().Before(at)
 }
 
 func parseEd25519PubKey(s string) (ed25519.PublicKey, error) {
@@ -239,58 +527,6 @@ func getHostID() string {
 	return "host-" + base64.RawStdEncoding.EncodeToString(sum[:8])
 }
 
-// Below will be helper functions to write to the allow list file
-const allowlistRelPath = ".config/tufwgo/authorised_controllers.json"
-
-func allowlistPath() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, allowlistRelPath)
-}
-
-func loadAllowlist() (*allowListFile, error) {
-	p := allowlistPath()
-	f, err := os.Open(p)
-	if errors.Is(err, os.ErrNotExist) {
-		return &allowListFile{Version: 1}, nil
-	}
-	if err != nil {
-		return nil, fmt.Errorf("open allowlist: %w", err)
-	}
-	defer f.Close()
-	var af allowListFile
-	if err := json.NewDecoder(f).Decode(&af); err != nil {
-		return nil, fmt.Errorf("parse allowlist: %w", err)
-	}
-	if af.Version == 0 {
-		af.Version = 1
-	}
-	return &af, nil
-}
-
-func saveAllowlist(af *allowListFile) error {
-	p := allowlistRelPath
-	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
-		return fmt.Errorf("mkdir: %w", err)
-	}
-	tmp := p + ".tmp"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("open tmp: %w", err)
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(af); err != nil {
-		f.Close()
-		_ = os.Remove(tmp)
-		return fmt.Errorf("encode: %w", err)
-	}
-	if err := f.Close(); err != nil {
-		_ = os.Remove(tmp)
-		return err
-	}
-	return os.Rename(tmp, p)
-}
-
 // Normalise a controller public key string. Accepts "ed25519:BASE64" or bare BASE64.
 func normalizePubB64(s string) (string, []byte, error) {
 	s = strings.TrimSpace(s)
@@ -313,7 +549,7 @@ func shortIDFromPub(raw []byte) string {
 	return "ed25519:" + base64.RawStdEncoding.EncodeToString(sum[:8])
 }
 
-func upsertController(af *allowListFile, id, label, pubB64 string) {
+func upsertController(af *allowListFile, id, label, pubB64, endorsedBy, role, expires string) {
 	now := time.Now().UTC().Format(time.RFC3339)
 	// update if id exists
 	for i := range af.Controllers {
@@ -321,6 +557,16 @@ func upsertController(af *allowListFile, id, label, pubB64 string) {
 			af.Controllers[i].Label = label
 			af.Controllers[i].PubKeyB64 = pubB64
 			af.Controllers[i].Revoked = false
+			af.Controllers[i].RevokeAt = ""
+			if endorsedBy != "" {
+				af.Controllers[i].EndorsedBy = endorsedBy
+			}
+			if role != "" {
+				af.Controllers[i].Role = role
+			}
+			if expires != "" {
+				af.Controllers[i].Expires = expires
+			}
 			if af.Controllers[i].Created == "" {
 				af.Controllers[i].Created = now
 			}
@@ -328,11 +574,14 @@ func upsertController(af *allowListFile, id, label, pubB64 string) {
 		}
 	}
 	af.Controllers = append(af.Controllers, allowEntry{
-		ID:        id,
-		Label:     label,
-		PubKeyB64: pubB64,
-		Revoked:   false,
-		Created:   now,
+		ID:         id,
+		Label:      label,
+		PubKeyB64:  pubB64,
+		Revoked:    false,
+		Created:    now,
+		EndorsedBy: endorsedBy,
+		Role:       role,
+		Expires:    expires,
 	})
 }
 
@@ -345,58 +594,168 @@ func listControllersCmd() error {
 		fmt.Println("(no controllers)")
 		return nil
 	}
+	now := time.Now()
+	fmt.Printf("%-20s  %-8s  %-14s  %-9s  %-9s  %-20s  %s\n", "ID", "STATE", "ROLE", "AGE", "LAST USED", "EXPIRES", "LABEL")
 	for _, c := range af.Controllers {
-		state := "active"
-		if c.Revoked {
-			state = "revoked"
+		state := entryState(c, now)
+		expires := c.Expires
+		if expires == "" {
+			expires = "never"
+		}
+		fmt.Printf("%-20s  %-8s  %-14s  %-9s  %-9s  %-20s  %s", c.ID, state, entryRole(c), strings.TrimSuffix(humanAge(c.Created, now), " ago"), humanAge(c.LastUsed, now), expires, c.Label)
+		if c.RevokeAt != "" && state == "active" {
+			fmt.Printf("  (revoking at %s)", c.RevokeAt)
 		}
-		fmt.Printf("%s  %-8s  %s\n", c.ID, state, c.Label)
+		fmt.Println()
+	}
+	if af.RequireApproval {
+		fmt.Println("\nDeletes and default policy changes through the executor need a co-signed approval (require-approval)")
 	}
 	return nil
 }
 
-func addControllerCmd(pubArg, label string) error {
+func addControllerCmd(pubArg, label, endorsedBy, endorsement, role, expires string) error {
 	if pubArg == "" {
 		return errors.New("missing --pub")
 	}
+	if expires != "" {
+		if _, err := parseExpiry(expires); err != nil {
+			return err
+		}
+	}
+	if role != "" && !validRole(role) {
+		return fmt.Errorf("unknown role %q (want one of %s)", role, strings.Join(roles, ", "))
+	}
 	pubB64, raw, err := normalizePubB64(pubArg)
 	if err != nil {
 		return err
 	}
 	id := shortIDFromPub(raw)
 
-	af, err := loadAllowlist()
+	err = updateAllowlist(func(af *allowListFile) error {
+		if endorsedBy != "" || endorsement != "" {
+			// A rotation must be signed by a controller that is still trusted here
+			if err := checkEndorsement(af, endorsedBy, endorsement, pubB64); err != nil {
+				return fmt.Errorf("rejecting rotated key: %w", err)
+			}
+			// A rotated key keeps the role of the key it replaces
+			if role == "" {
+				role = roleOf(af, endorsedBy)
+			}
+		}
+		upsertController(af, id, label, pubB64, endorsedBy, role, expires)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	upsertController(af, id, label, pubB64)
-	if err := saveAllowlist(af); err != nil {
-		return err
-	}
 	fmt.Println("Added/updated controller:")
 	fmt.Println("  ID:  ", id)
 	fmt.Println("  Label:", label)
 	return nil
 }
 
-func revokeControllerCmd(id string) error {
+func checkEndorsement(af *allowListFile, endorsedBy, endorsement, newPubB64 string) error {
+	if endorsedBy == "" || endorsement == "" {
+		return errors.New("--endorsed-by and --endorsement must be given together")
+	}
+	for _, e := range af.Controllers {
+		if e.ID != endorsedBy {
+			continue
+		}
+		if state := entryState(e, time.Now()); state != "active" {
+			return fmt.Errorf("endorsing controller %s is %s", endorsedBy, state)
+		}
+		oldPub, err := parseEd25519PubKey(e.PubKeyB64)
+		if err != nil {
+			return err
+		}
+		sig, err := base64.StdEncoding.DecodeString(endorsement)
+		if err != nil {
+			return fmt.Errorf("cannot decode endorsement: %w", err)
+		}
+		if !ed25519.Verify(oldPub, auth.RotateMessage(endorsedBy, newPubB64), sig) {
+			return errors.New("invalid endorsement signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("endorsing controller not found: %s", endorsedBy)
+}
+
+func roleOf(af *allowListFile, id string) string {
+	for _, e := range af.Controllers {
+		if e.ID == id {
+			return e.Role
+		}
+	}
+	return ""
+}
+
+func setRoleCmd(id, role string) error {
 	if id == "" {
 		return errors.New("missing --id")
 	}
-	af, err := loadAllowlist()
+	if !validRole(role) {
+		return fmt.Errorf("unknown role %q (want one of %s)", role, strings.Join(roles, ", "))
+	}
+	err := updateAllowlist(func(af *allowListFile) error {
+		for i := range af.Controllers {
+			if af.Controllers[i].ID == id {
+				af.Controllers[i].Role = role
+				return nil
+			}
+		}
+		return fmt.Errorf("controller not found: %s", id)
+	})
 	if err != nil {
 		return err
 	}
-	found := false
-	for i := range af.Controllers {
-		if af.Controllers[i].ID == id {
-			af.Controllers[i].Revoked = true
-			found = true
-			break
+	fmt.Println("Role set:", id, role)
+	return nil
+}
+
+// requireApprovalCmd turns the executor's approval check for deletes and default policy changes on or off
+func requireApprovalCmd(on bool) error {
+	err := updateAllowlist(func(af *allowListFile) error {
+		if af.RequireApproval == on {
+			return errUnchanged
 		}
+		af.RequireApproval = on
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	if !found {
-		return fmt.Errorf("id not found: %s", id)
+	if on {
+		fmt.Println("Deletes and default policy changes through the executor now need a co-signed approval")
+		fmt.Println("This is only enforced for logins sshd forces through the executor; any other login can still run ufw")
+		fmt.Println("directly. Run 'tufwgo-auth sshd-config --user <login>' for the setup.")
+	} else {
+		fmt.Println("Deletes and default policy changes through the executor no longer need an approval")
 	}
-	return saveAllowlist(af)
+	return nil
+}
+
+func revokeControllerCmd(id, after string) error {
+	if id == "" {
+		return errors.New("missing --id")
+	}
+	if after != "" {
+		if _, err := time.Parse(time.RFC3339, after); err != nil {
+			return fmt.Errorf("bad --after: %w", err)
+		}
+	}
+	return updateAllowlist(func(af *allowListFile) error {
+		for i := range af.Controllers {
+			if af.Controllers[i].ID == id {
+				if after == "" {
+					af.Controllers[i].Revoked = true
+				} else {
+					af.Controllers[i].RevokeAt = after
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("id not found: %s", id)
+	})
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// runRecord appends a controller's signed account of a change to this host's log, so the host
+// keeps its own record of what was done to it and by which controller
+func runRecord(in io.Reader, out io.Writer, hs *handshake) error {
+	decode := json.NewDecoder(bufio.NewReader(in))
+	encode := json.NewEncoder(out)
+
+	g, err := greet(decode, encode, hs)
+	if err != nil {
+		return err
+	}
+
+	var req auth.RecordRequest
+	if err = decode.Decode(&req); err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode record: %v", err)
+	}
+	if req.Type != "RECORD" || req.ClientID != hs.clientID {
+		return auth.NewAuthError(auth.ErrBadRequest, "invalid record")
+	}
+	record, err := base64.StdEncoding.DecodeString(req.RecordB64)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode record: %v", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.SigB64)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode signature: %v", err)
+	}
+	clientNonce, err := base64.StdEncoding.DecodeString(req.Nonce)
+	if err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot decode client nonce: %v", err)
+	}
+	if len(clientNonce) < minClientNonce {
+		return auth.NewAuthError(auth.ErrBadRequest, "missing or short client nonce")
+	}
+	msg := auth.RecordMessage(g.nonce, g.hostID, hs.clientID, req.TSUnix, clientNonce, g.session, record)
+	if !ed25519.Verify(g.pubkey, msg, sig) {
+		return auth.NewAuthError(auth.ErrBadSignature, "invalid signature")
+	}
+	if err = checkClock(req.TSUnix); err != nil {
+		return err
+	}
+	if err = rememberProof(assertUserFilepath(replayCachePath), proofID("record\x00"+hs.clientID, clientNonce), time.Now()); err != nil {
+		return err
+	}
+
+	var rec auth.HostRecord
+	if err = json.Unmarshal(record, &rec); err != nil {
+		return auth.NewAuthError(auth.ErrBadRequest, "cannot parse record: %v", err)
+	}
+	hs.role = entryRole(g.entry)
+	entry := &audit.Entry{
+		Actor:       hs.clientID,
+		Action:      audit.Action(rec.Action),
+		Command:     rec.Command,
+		ProfCommand: rec.ProfCommand,
+		Result:      rec.Result,
+		Error:       rec.Error,
+		Payload:     rec.Payload,
+		Fields: []audit.Field{
+			{Name: "label", Value: hs.label},
+			{Name: "source", Value: handshakeSource()},
+			{Name: "role", Value: hs.role},
+			{Name: "ref", Value: rec.Ref},
+			{Name: "controller", Value: rec.Controller},
+			{Name: "controller_log", Value: rec.ControllerLog},
+		},
+	}
+	for _, f := range rec.Fields {
+		entry.Fields = append(entry.Fields, audit.Field{Name: f.Name, Value: f.Value})
+	}
+	hash, err := appendHostLog(entry)
+	if err != nil {
+		return err
+	}
+	return encode.Encode(auth.RecordReply{
+		Type:  "RECORDED",
+		Log:   filepath.Base(handshakeLogPath),
+		Index: entry.Index,
+		Hash:  hash,
+	})
+}
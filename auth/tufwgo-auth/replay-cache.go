@@ -0,0 +1,118 @@
+package main
+
+import (
+	"TUFWGo/auth"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const replayCachePath = ".config/tufwgo/auth_replay_cache.json"
+
+// replayWindow covers every timestamp the skew check can still accept
+const replayWindow = 2 * clockSkew
+
+var errReplay = auth.NewAuthError(auth.ErrReplay, "proof already used (replay rejected)")
+
+type replayCache struct {
+	Entries map[string]int64 `json:"entries"` // proof id -> unix expiry
+}
+
+func proofID(clientID string, clientNonce []byte) string {
+	h := sha256.New()
+	h.Write([]byte(clientID))
+	h.Write([]byte{0})
+	h.Write(clientNonce)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// rememberProof records a proof as used, failing if it was seen inside the replay window.
+// The cache is shared by every handshake on the host, so it is updated under an exclusive lock.
+func rememberProof(path, id string, now time.Time) error {
+	return rememberUntil(path, id, now, now.Add(replayWindow))
+}
+
+// rememberUntil is rememberProof with an explicit expiry, for proofs that stay valid longer than the replay window
+func rememberUntil(path, id string, now, until time.Time) error {
+	return editReplayCache(path, now, func(rc *replayCache) error {
+		if _, seen := rc.Entries[id]; seen {
+			return errReplay
+		}
+		rc.Entries[id] = until.Unix()
+		return nil
+	})
+}
+
+// forgetProof drops a proof again, for a reservation whose command didn't go through
+func forgetProof(path, id string, now time.Time) error {
+	return editReplayCache(path, now, func(rc *replayCache) error {
+		delete(rc.Entries, id)
+		return nil
+	})
+}
+
+// isRemembered reports whether id is in the cache and hasn't expired
+func isRemembered(path, id string, now time.Time) (bool, error) {
+	seen := false
+	err := editReplayCache(path, now, func(rc *replayCache) error {
+		_, seen = rc.Entries[id]
+		return nil
+	})
+	return seen, err
+}
+
+// editReplayCache runs fn on the unexpired entries and saves the result, under an exclusive lock
+// since the cache is shared by every process on the host
+func editReplayCache(path string, now time.Time, fn func(rc *replayCache) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("open replay lock: %w", err)
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock replay cache: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	rc := replayCache{Entries: map[string]int64{}}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(data, &rc); err != nil {
+			// A damaged cache can't prove a proof is fresh, so refuse rather than reset it
+			return fmt.Errorf("parse replay cache: %w", err)
+		}
+		if rc.Entries == nil {
+			rc.Entries = map[string]int64{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read replay cache: %w", err)
+	}
+
+	for k, exp := range rc.Entries {
+		if exp <= now.Unix() {
+			delete(rc.Entries, k)
+		}
+	}
+	if err = fn(&rc); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(rc)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("write replay cache: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
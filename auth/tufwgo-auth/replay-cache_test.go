@@ -0,0 +1,119 @@
+package main
+
+import (
+	"TUFWGo/auth"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRememberProofRejectsReplay(t *testing.T) {
+	path := t.TempDir() + "/replay.json"
+	now := time.Now()
+	id := proofID("ctl", []byte("0123456789abcdef"))
+
+	if err := rememberProof(path, id, now); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := rememberProof(path, id, now); !errors.Is(err, errReplay) {
+		t.Fatalf("second use: got %v, want replay", err)
+	}
+	// Once the window has passed the proof is forgotten; its timestamp is refused by then anyway
+	if err := rememberProof(path, id, now.Add(replayWindow+time.Second)); err != nil {
+		t.Fatalf("after the window: %v", err)
+	}
+}
+
+// proofRun plays one login handshake against run. sign turns the challenge into the PROOF to send.
+func proofRun(t *testing.T, clientID string, sign func(Challenge) Proof) (map[string]any, error) {
+	t.Helper()
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- run(serverIn, serverOut, io.Discard, &handshake{})
+		serverOut.Close()
+	}()
+
+	enc := json.NewEncoder(clientOut)
+	dec := json.NewDecoder(clientIn)
+	if err := enc.Encode(Hello{Type: "HELLO", ClientID: clientID, Algo: "ed25519", ProtocolVersions: auth.ProtocolVersions}); err != nil {
+		t.Fatal(err)
+	}
+	var chal Challenge
+	if err := dec.Decode(&chal); err != nil {
+		t.Fatalf("read challenge: %v", err)
+	}
+	if err := enc.Encode(sign(chal)); err != nil {
+		t.Fatal(err)
+	}
+	// run only answers a successful proof; a refusal is returned for main to report
+	var reply map[string]any
+	_ = dec.Decode(&reply)
+	return reply, <-done
+}
+
+func TestProofReplayRejected(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_CONNECTION", "192.0.2.10 50000 192.0.2.20 22")
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = addControllerCmd(base64.StdEncoding.EncodeToString(pub), "test", "", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	clientID := auth.ControllerID(pub)
+	clientNonce := bytes.Repeat([]byte{7}, minClientNonce)
+
+	var first Proof
+	signed := func(chal Challenge) Proof {
+		nonce, _ := base64.StdEncoding.DecodeString(chal.NonceBase64)
+		session, _ := base64.StdEncoding.DecodeString(chal.SessionBase64)
+		ts := time.Now().Unix()
+		msg := buildMessage(nonce, chal.HostID, clientID, ts, clientNonce, session)
+		first = Proof{
+			Type:      "PROOF",
+			ClientID:  clientID,
+			TSUnix:    ts,
+			Nonce:     base64.StdEncoding.EncodeToString(clientNonce),
+			SigBase64: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg)),
+		}
+		return first
+	}
+
+	reply, err := proofRun(t, clientID, signed)
+	if err != nil || reply["type"] != "OK" {
+		t.Fatalf("first handshake: reply %v, err %v", reply, err)
+	}
+
+	// A fresh server nonce alone stops the same PROOF, so repeat the challenge to reach the cache
+	serverNonce := bytes.Repeat([]byte{9}, 32)
+	nonceSource = bytes.NewReader(append(bytes.Clone(serverNonce), serverNonce...))
+	defer func() { nonceSource = rand.Reader }()
+	clientNonce = bytes.Repeat([]byte{8}, minClientNonce)
+
+	reply, err = proofRun(t, clientID, signed)
+	if err != nil || reply["type"] != "OK" {
+		t.Fatalf("handshake with a fixed challenge: reply %v, err %v", reply, err)
+	}
+	// The same PROOF against the same challenge only differs from a fresh login by the cache
+	_, err = proofRun(t, clientID, func(Challenge) Proof { return first })
+	var ae *auth.AuthError
+	if !errors.As(err, &ae) || ae.Code != auth.ErrReplay {
+		t.Fatalf("replayed proof: got %v, want %s", err, auth.ErrReplay)
+	}
+
+	// A fresh signature over a new challenge that reuses the client nonce is caught by the cache too
+	nonceSource = rand.Reader
+	_, err = proofRun(t, clientID, signed)
+	if !errors.As(err, &ae) || ae.Code != auth.ErrReplay {
+		t.Fatalf("reused client nonce: got %v, want %s", err, auth.ErrReplay)
+	}
+}
@@ -0,0 +1,78 @@
+package fanout
+
+import (
+	"TUFWGo/system/ssh"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func BuildJob(op Op, rule, profilePath string) (*Job, error) {
+	switch op {
+	case OpAdd, OpDelete:
+		args, err := checkRuleArgs(rule)
+		if err != nil {
+			return nil, err
+		}
+		if op == OpAdd {
+			return &Job{Op: op, Commands: []string{"ufw " + args}}, nil
+		}
+		return &Job{Op: op, Commands: []string{"ufw delete " + args}}, nil
+	case OpProfile:
+		cmds, err := readProfileCommands(profilePath)
+		if err != nil {
+			return nil, err
+		}
+		return &Job{Op: op, Commands: cmds}, nil
+	}
+	return nil, fmt.Errorf("unknown fan-out operation: %q (expected add, delete or profile)", op)
+}
+
+// checkRuleArgs accepts the part of a ufw rule after "ufw", e.g. "allow 22/tcp" or "deny from 10.0.0.5"
+func checkRuleArgs(rule string) (string, error) {
+	rule = strings.TrimSpace(rule)
+	if rule == "" {
+		return "", errors.New("missing -rule")
+	}
+	rule = strings.TrimPrefix(rule, "ufw ")
+	if err := ssh.CheckPlainCommand(rule); err != nil {
+		return "", fmt.Errorf("invalid rule: %w", err)
+	}
+	switch strings.Fields(rule)[0] {
+	case "allow", "deny", "reject", "limit", "route":
+	default:
+		return "", errors.New("rule must start with 'allow', 'deny', 'reject', 'limit' or 'route'")
+	}
+	return rule, nil
+}
+
+func readProfileCommands(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("missing -profile")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read profile: %w", err)
+	}
+
+	var rs struct {
+		Commands []string `json:"commands"`
+	}
+	if err = json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("unable to parse profile: %w", err)
+	}
+	if len(rs.Commands) == 0 {
+		return nil, errors.New("profile is empty")
+	}
+	for _, cmd := range rs.Commands {
+		if !strings.HasPrefix(cmd, "ufw ") {
+			return nil, fmt.Errorf("profile contains a non-ufw command: %q", cmd)
+		}
+		if err := ssh.CheckPlainCommand(cmd); err != nil {
+			return nil, fmt.Errorf("invalid profile command: %w", err)
+		}
+	}
+	return rs.Commands, nil
+}
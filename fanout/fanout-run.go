@@ -0,0 +1,209 @@
+package fanout
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/system/ssh"
+	"TUFWGo/ufw"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+type Op string
+
+const (
+	OpAdd     Op = "add"
+	OpDelete  Op = "delete"
+	OpProfile Op = "profile"
+)
+
+// AuditAction maps a fan-out operation onto the action names used by the single host flows
+func (o Op) AuditAction() audit.Action {
+	switch o {
+	case OpAdd:
+		return audit.ActionUFWAdd
+	case OpDelete:
+		return audit.ActionUFWDelete
+	case OpProfile:
+		return audit.ActionProfileExecute
+	}
+	return audit.Action("fanout." + string(o))
+}
+
+// ChangesRuleset is true for the operations that modify ufw, whose audit entries record the
+// ruleset before and after
+func (o Op) ChangesRuleset() bool {
+	return o == OpAdd || o == OpDelete || o == OpProfile
+}
+
+// Permission is the controller role permission the operation needs on each host
+func (o Op) Permission() auth.Permission {
+	switch o {
+	case OpAdd:
+		return auth.PermAdd
+	case OpDelete:
+		return auth.PermDelete
+	case OpProfile:
+		return auth.PermProfile
+	}
+	return auth.PermDefaults
+}
+
+type Job struct {
+	Op       Op
+	Commands []string
+}
+
+type Stage string
+
+const (
+	StageConnecting Stage = "connecting"
+	StageConnected  Stage = "connected"
+	StageRunning    Stage = "running"
+	StageOutput     Stage = "output"
+	StageDone       Stage = "done"
+	StageFailed     Stage = "failed"
+)
+
+type Event struct {
+	Target  Target
+	Stage   Stage
+	Command string
+	Line    string
+	Err     error
+}
+
+type Result struct {
+	Target  Target
+	Output  string
+	Failed  string // command that failed, if any
+	Err     error
+	Elapsed time.Duration
+	Ruleset ufw.RulesetChange
+	// HostLog cross-references the record the host kept in its own audit log
+	HostLog []audit.Field
+}
+
+// DialFunc connects and authenticates against a single target
+type DialFunc func(t Target) (*cryptossh.Client, error)
+
+// Run applies the job to every target, with at most concurrency hosts in flight at once.
+// Results are returned in the same order as targets.
+func Run(targets []Target, job *Job, concurrency int, dial DialFunc, progress func(Event)) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if progress == nil {
+		progress = func(Event) {}
+	}
+
+	results := make([]Result, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runOne(t, job, dial, progress)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+func runOne(t Target, job *Job, dial DialFunc, progress func(Event)) Result {
+	start := time.Now()
+	res := Result{Target: t}
+
+	progress(Event{Target: t, Stage: StageConnecting})
+	client, err := dial(t)
+	if err != nil {
+		res.Err = fmt.Errorf("connect: %w", err)
+		res.Elapsed = time.Since(start)
+		progress(Event{Target: t, Stage: StageFailed, Err: res.Err})
+		return res
+	}
+	defer func() {
+		ssh.ForgetElevation(client)
+		ssh.ForgetAgent(client)
+		_ = client.Close()
+	}()
+	progress(Event{Target: t, Stage: StageConnected})
+
+	snapshot := job.Op.ChangesRuleset()
+	if snapshot {
+		res.Ruleset.Before, res.Ruleset.BeforeErr = captureRuleset(client)
+	}
+
+	var out strings.Builder
+	for _, cmd := range job.Commands {
+		progress(Event{Target: t, Stage: StageRunning, Command: cmd})
+
+		input := ""
+		if job.Op == OpDelete {
+			input = "y\n"
+		}
+		emit := func(line string, _ bool) {
+			progress(Event{Target: t, Stage: StageOutput, Command: cmd, Line: line})
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ssh.CommandTimeout)
+		stdout, err := ssh.CommandLiveStreamOn(ctx, client, cmd, input, emit)
+		cancel()
+		out.WriteString(stdout)
+		if err != nil {
+			res.Failed = cmd
+			res.Err = err
+			break
+		}
+	}
+
+	if snapshot {
+		// Captured even after a failure, since a profile may have got part way
+		res.Ruleset.After, res.Ruleset.AfterErr = captureRuleset(client)
+		res.HostLog = recordOnHost(client, job, &res)
+	}
+	res.Output = out.String()
+	res.Elapsed = time.Since(start)
+	if res.Err != nil {
+		progress(Event{Target: t, Stage: StageFailed, Command: res.Failed, Err: res.Err})
+	} else {
+		progress(Event{Target: t, Stage: StageDone})
+	}
+	return res
+}
+
+func captureRuleset(client *cryptossh.Client) (*ufw.Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ssh.CommandTimeout)
+	defer cancel()
+	return ufw.CaptureRulesetOn(ctx, client)
+}
+
+// recordOnHost has the host log the change in its own audit log
+func recordOnHost(client *cryptossh.Client, job *Job, res *Result) []audit.Field {
+	rec := auth.HostRecord{Action: string(job.Op.AuditAction()), Result: "success"}
+	if job.Op == OpProfile {
+		rec.ProfCommand = job.Commands
+	} else {
+		rec.Command = job.Commands[0]
+	}
+	if res.Err != nil {
+		rec.Result, rec.Error = "error", res.Err.Error()
+		if errors.Is(res.Err, context.DeadlineExceeded) {
+			rec.Result = "timeout"
+		}
+		rec.Fields = append(rec.Fields, auth.RecordField{Name: "failed_command", Value: res.Failed})
+	}
+	rec.Fields = append(rec.Fields, audit.HostRecordFields(audit.RulesetFields(&res.Ruleset))...)
+	ctx, cancel := context.WithTimeout(context.Background(), ssh.CommandTimeout)
+	defer cancel()
+	return audit.RecordOnHost(ctx, client, rec)
+}
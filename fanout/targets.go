@@ -0,0 +1,144 @@
+package fanout
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Target struct {
+	Host string
+	User string
+	Port int
+}
+
+func (t Target) String() string {
+	if t.Port == 22 {
+		return fmt.Sprintf("%s@%s", t.User, t.Host)
+	}
+	return fmt.Sprintf("%s@%s:%d", t.User, t.Host, t.Port)
+}
+
+// ParseTargets reads a comma separated list of [user@]host[:port] entries
+func ParseTargets(list, defaultUser string) ([]Target, error) {
+	var targets []Target
+	for _, raw := range strings.Split(list, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTarget(raw, defaultUser)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets given")
+	}
+	return dedupe(targets), nil
+}
+
+func parseTarget(raw, defaultUser string) (Target, error) {
+	t := Target{User: defaultUser, Port: 22}
+	if at := strings.LastIndex(raw, "@"); at != -1 {
+		t.User = raw[:at]
+		raw = raw[at+1:]
+	}
+
+	host, portStr, err := net.SplitHostPort(raw)
+	if err != nil {
+		// No port given
+		host = strings.Trim(raw, "[]")
+	} else {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return Target{}, fmt.Errorf("invalid port in target %q", raw)
+		}
+		t.Port = port
+	}
+	if host == "" {
+		return Target{}, fmt.Errorf("missing host in target %q", raw)
+	}
+	if t.User == "" {
+		return Target{}, fmt.Errorf("missing user in target %q", raw)
+	}
+	t.Host = host
+	return t, nil
+}
+
+// LoadInventory reads the hosts out of an Ansible INI inventory, honouring ansible_host, ansible_user and ansible_port.
+// Group variable sections ([group:vars]) and child sections ([group:children]) are skipped.
+func LoadInventory(path, defaultUser string) ([]Target, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open inventory: %w", err)
+	}
+	defer file.Close()
+
+	var targets []Target
+	skipSection := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section := strings.Trim(line, "[]")
+			skipSection = strings.HasSuffix(section, ":vars") || strings.HasSuffix(section, ":children")
+			continue
+		}
+		if skipSection {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		t := Target{Host: fields[0], User: defaultUser, Port: 22}
+		for _, kv := range fields[1:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, "\"'")
+			switch key {
+			case "ansible_host":
+				t.Host = value
+			case "ansible_user":
+				t.User = value
+			case "ansible_port":
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid ansible_port for %s: %s", fields[0], value)
+				}
+				t.Port = port
+			}
+		}
+		targets = append(targets, t)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hosts found in inventory: %s", path)
+	}
+	return dedupe(targets), nil
+}
+
+func dedupe(targets []Target) []Target {
+	seen := make(map[string]struct{})
+	out := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		key := t.String()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, t)
+	}
+	return out
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"TUFWGo/system"
-	"TUFWGo/system/local"
+	"TUFWGo/system/userdir"
 )
 
 func main() {
-	local.RequireRoot()
+	userdir.RequireRoot()
 	system.RunTUIMode()
 	/*err := copilot.RunOllama()
 	if err != nil {
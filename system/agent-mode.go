@@ -0,0 +1,31 @@
+package system
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/system/ssh"
+	"context"
+	"crypto/ed25519"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// agentExec sends ufw commands to the tufwgo-auth executor, signing each one with the controller key.
+// approvalB64 is the co-signed approval the commands run under, or "".
+func agentExec(clientID string, priv ed25519.PrivateKey, approvalB64 string) ssh.AgentExecFunc {
+	return func(ctx context.Context, client *cryptossh.Client, argv []string, input, sudo, sudoPassword string, emit func(string, bool)) (string, error) {
+		return auth.ExecOverSSH(ctx, client, clientID, priv, "/usr/bin/tufwgo-auth", argv, input, sudo, sudoPassword, approvalB64, emit)
+	}
+}
+
+// enableHostAudit has each remote change also written to the managed host's audit log, signed with
+// the controller key. The shared auditor is opened first so host records can name the controller's log.
+func enableHostAudit(clientID string, priv ed25519.PrivateKey) {
+	if !*hostAudit {
+		return
+	}
+	sharedAuditor()
+	audit.SetHostRecorder(func(ctx context.Context, client *cryptossh.Client, rec auth.HostRecord) (*auth.RecordReply, error) {
+		return auth.RecordOverSSH(ctx, client, clientID, priv, "/usr/bin/tufwgo-auth", rec)
+	})
+}
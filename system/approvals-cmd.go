@@ -0,0 +1,429 @@
+package system
+
+import (
+	"TUFWGo/approval"
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/fanout"
+	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"TUFWGo/ufw"
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+const approvalsUsage = "usage: tufwgo approvals list | show <id> | approve [-yes] <id> | reject <id> | apply <id>\n(<id> may also be the path of a request file; re-run reject to retry hosts that weren't told)"
+
+// approvalsCmd manages changes that need a second controller's signature before they run
+func approvalsCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(approvalsUsage)
+	}
+	if args[0] == "list" {
+		return listApprovals()
+	}
+
+	fs := flag.NewFlagSet("approvals "+args[0], flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Approve without asking for confirmation")
+	_ = fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return errors.New(approvalsUsage)
+	}
+	r, path, err := approval.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		printApproval(r)
+		return nil
+	case "approve":
+		return approveRequest(r, path, *yes)
+	case "reject":
+		return rejectRequest(r, path)
+	case "apply":
+		return applyApproval(r, path)
+	}
+	return errors.New(approvalsUsage)
+}
+
+func listApprovals() error {
+	reqs, err := approval.List()
+	if err != nil {
+		return err
+	}
+	if len(reqs) == 0 {
+		fmt.Println("(no approval requests in " + approval.QueueDir() + ")")
+		return nil
+	}
+	fmt.Printf("%-16s %-9s %-16s %-20s %-20s %s\n", "ID", "STATUS", "ACTION", "PROPOSED", "BY", "CHANGE")
+	for _, r := range reqs {
+		fmt.Printf("%-16s %-9s %-16s %-20s %-20s %s\n", r.ID, r.Status, r.Action, r.ProposedAt, r.ProposedBy, r.Summary())
+	}
+	return nil
+}
+
+func printApproval(r *approval.Request) {
+	fmt.Println("Request: ", r.ID)
+	fmt.Println("Status:  ", r.Status)
+	fmt.Println("Action:  ", r.Action)
+	fmt.Println("Targets: ", strings.Join(r.Targets, ", "))
+	fmt.Println("Host keys:", strings.Join(r.HostKeys, ", "))
+	fmt.Printf("Proposed: %s by %s (expires %s)\n", r.ProposedAt, r.ProposedBy, r.Expires)
+	if r.ApprovedBy != "" {
+		fmt.Printf("Approved: %s by %s\n", r.ApprovedAt, r.ApprovedBy)
+	}
+	if r.RejectedBy != "" {
+		fmt.Println("Rejected by:", r.RejectedBy)
+	}
+	if r.Reason != "" {
+		fmt.Println("Reason:  ", r.Reason)
+	}
+	if r.Rule != "" {
+		fmt.Println("Rule:    ", r.Rule)
+	}
+	if len(r.Applied) > 0 {
+		fmt.Println("Applied: ", strings.Join(r.Applied, ", "))
+	}
+	fmt.Println("Commands:")
+	for _, c := range r.Commands {
+		fmt.Println("  " + c)
+	}
+}
+
+// approvalControllerKey loads this machine's controller key for signing a request
+func approvalControllerKey() (string, ed25519.PrivateKey, error) {
+	label, err := local.RunCommand("uname -snrm")
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to get system name to generate controller ID: %w", err)
+	}
+	id, _, priv, _, err := auth.EnsureControllerKey(label)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load controller key: %w", err)
+	}
+	return id, priv, nil
+}
+
+// proposeApproval signs a destructive change with this controller's key and queues it for a second controller
+func proposeApproval(action string, targets, cmds []string, rule string) (*approval.Request, error) {
+	r, err := approval.New(action, targets, cmds, approval.DefaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	r.Rule = rule
+	if r.HostKeys, err = targetHostKeys(targets); err != nil {
+		return nil, err
+	}
+	id, priv, err := approvalControllerKey()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.Propose(id, priv); err != nil {
+		return nil, err
+	}
+	if _, err = approval.Save(r); err != nil {
+		return nil, fmt.Errorf("unable to queue approval request: %w", err)
+	}
+	auditApproval(audit.ActionApprovalPropose, "success", r, "", nil)
+	return r, nil
+}
+
+// targetHostKeys looks up the trusted host keys of every target, so the signed proposal names the
+// machines it is for and can't be replayed on another host
+func targetHostKeys(targets []string) ([]string, error) {
+	parsed, err := fanout.ParseTargets(strings.Join(targets, ","), *fanoutUser)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, t := range parsed {
+		known, err := ssh.FindKnownHosts(t.Host, t.Port)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read known_hosts: %w", err)
+		}
+		found := false
+		for _, kh := range known {
+			// @revoked and @cert-authority lines aren't keys the host presents
+			if kh.Marker != "" {
+				continue
+			}
+			found = true
+			if !slices.Contains(keys, kh.Fingerprint) {
+				keys = append(keys, kh.Fingerprint)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not in known_hosts; connect to it once so its host key is trusted before proposing a change", t)
+		}
+	}
+	return keys, nil
+}
+
+func approveRequest(r *approval.Request, path string, yes bool) error {
+	printApproval(r)
+	if !yes {
+		fmt.Print("\nApprove this change? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("not approved")
+		}
+	}
+
+	id, priv, err := approvalControllerKey()
+	if err != nil {
+		return err
+	}
+	if err = r.Approve(id, priv); err != nil {
+		auditApproval(audit.ActionApprovalApprove, "error", r, err.Error(), nil)
+		return err
+	}
+	if err = approval.SaveTo(path, r); err != nil {
+		return err
+	}
+	auditApproval(audit.ActionApprovalApprove, "success", r, "", nil)
+	fmt.Printf("\nApproved %s. It can now be applied with: tufwgo approvals apply %s\n", r.ID, r.ID)
+	if !strings.HasPrefix(path, approval.QueueDir()) {
+		fmt.Println("Send", path, "back to the proposer if they don't share this queue.")
+	}
+	return nil
+}
+
+// rejectRequest signs the rejection and tells every target, since the status in the file isn't
+// signed and a copy edited back to approved would otherwise still be accepted
+func rejectRequest(r *approval.Request, path string) error {
+	if r.Status != approval.StatusPending && r.Status != approval.StatusApproved && r.Status != approval.StatusRejected {
+		return fmt.Errorf("request %s is %s", r.ID, r.Status)
+	}
+	if r.Status != approval.StatusRejected || r.RejectorSig == "" {
+		id, priv, err := approvalControllerKey()
+		if err != nil {
+			return err
+		}
+		if err = r.Reject(id, priv); err != nil {
+			return err
+		}
+		if err = approval.SaveTo(path, r); err != nil {
+			return err
+		}
+		auditApproval(audit.ActionApprovalReject, "success", r, "", nil)
+		fmt.Println("Rejected", r.ID)
+	}
+	return notifyRejection(r)
+}
+
+// notifyRejection hands the signed rejection to each target, which refuses the request from then on
+func notifyRejection(r *approval.Request) error {
+	targets, err := fanout.ParseTargets(strings.Join(r.Targets, ","), *fanoutUser)
+	if err != nil {
+		return err
+	}
+	label, err := local.RunCommand("uname -snrm")
+	if err != nil {
+		return fmt.Errorf("unable to get system name to generate controller ID: %w", err)
+	}
+	clientID, pubB64, priv, created, err := auth.EnsureControllerKey(label)
+	if err != nil {
+		return fmt.Errorf("failed to load controller key: %w", err)
+	}
+	data, _ := json.Marshal(r)
+	cmd := fmt.Sprintf("%s reject-approval --request %q", "/usr/bin/tufwgo-auth", base64.StdEncoding.EncodeToString(data))
+	dial := fanoutDialer(clientID, pubB64, label, priv, created, nil, "")
+
+	failed := 0
+	for _, t := range targets {
+		client, err := dial(t)
+		if err == nil {
+			_, err = ssh.ConversationalCommentStreamOn(client, cmd, "")
+			ssh.ForgetAgent(client)
+			_ = client.Close()
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("%-40s error    %s\n", t, firstLine(err.Error()))
+			continue
+		}
+		fmt.Printf("%-40s rejected\n", t)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d host(s) were not told about the rejection; re-run reject to retry them", failed, len(targets))
+	}
+	return nil
+}
+
+// applyApproval runs a co-signed change on every target it hasn't reached yet. Each host checks
+// both signatures against its own allowlist before anything runs.
+func applyApproval(r *approval.Request, path string) error {
+	if err := r.Verify(time.Now()); err != nil {
+		return err
+	}
+	all, err := fanout.ParseTargets(strings.Join(r.Targets, ","), *fanoutUser)
+	if err != nil {
+		return err
+	}
+	var targets []fanout.Target
+	for _, t := range all {
+		if !slices.Contains(r.Applied, t.String()) {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("request %s has already been applied to every target", r.ID)
+	}
+	mode, err := ssh.ParseElevationMode(*sudoMode)
+	if err != nil {
+		return err
+	}
+	label, err := local.RunCommand("uname -snrm")
+	if err != nil {
+		return fmt.Errorf("unable to get system name to generate controller ID: %w", err)
+	}
+	clientID, pubB64, priv, created, err := auth.EnsureControllerKey(label)
+	if err != nil {
+		return fmt.Errorf("failed to load controller key: %w", err)
+	}
+
+	op := fanout.OpProfile
+	switch audit.Action(r.Action) {
+	case audit.ActionUFWDelete:
+		op = fanout.OpDelete
+	case audit.ActionUFWAdd:
+		op = fanout.OpAdd
+	}
+	job := &fanout.Job{Op: op, Commands: r.Commands}
+	enableHostAudit(clientID, priv)
+	dial := approvedDialer(r, clientID, priv, fanoutDialer(clientID, pubB64, label, priv, created, &mode, op.Permission()))
+
+	fmt.Printf("Applying %s to %d host(s)...\n\n", r.ID, len(targets))
+	results := fanout.Run(targets, job, *fanoutConcurrency, dial, fanoutProgress())
+
+	failed := 0
+	fmt.Printf("\n%-40s %-8s %s\n", "HOST", "RESULT", "DETAIL")
+	for _, res := range results {
+		result, errMsg := "success", ""
+		if res.Err != nil {
+			failed++
+			result, errMsg = "error", res.Err.Error()
+			if errors.Is(res.Err, context.DeadlineExceeded) {
+				result = "timeout"
+			}
+		} else {
+			r.Applied = append(r.Applied, res.Target.String())
+		}
+		fmt.Printf("%-40s %-8s %s\n", res.Target, result, firstLine(errMsg))
+		auditApproval(audit.Action(r.Action), result, r, errMsg, append([]audit.Field{
+			{Name: "target", Value: res.Target.String()},
+			{Name: "ssh_active", Value: "true"},
+		}, append(audit.RulesetFields(&res.Ruleset), res.HostLog...)...))
+	}
+	if len(r.Applied) == len(all) {
+		r.Status = approval.StatusApplied
+	}
+	if err = approval.SaveTo(path, r); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d host(s) failed; re-run apply to retry them", failed, len(results))
+	}
+	return nil
+}
+
+// approvedDialer has each host accept the approval before the job runs, and for a numbered delete
+// checks that the rule at that number is still the one the approvers saw. The job always goes
+// through the executor with the approval attached, so a host that requires approvals can check it.
+func approvedDialer(r *approval.Request, clientID string, priv ed25519.PrivateKey, dial fanout.DialFunc) fanout.DialFunc {
+	data, _ := json.Marshal(r)
+	approvalB64 := base64.StdEncoding.EncodeToString(data)
+	check := fmt.Sprintf("%s check-approval --request %q", "/usr/bin/tufwgo-auth", approvalB64)
+	return func(t fanout.Target) (*cryptossh.Client, error) {
+		client, err := dial(t)
+		if err != nil {
+			return nil, err
+		}
+		fail := func(err error) (*cryptossh.Client, error) {
+			ssh.ForgetElevation(client)
+			ssh.ForgetAgent(client)
+			_ = client.Close()
+			return nil, err
+		}
+		if r.Rule != "" {
+			if err = checkNumberedRule(client, r); err != nil {
+				return fail(err)
+			}
+		}
+		if _, err = ssh.ConversationalCommentStreamOn(client, check, ""); err != nil {
+			return fail(fmt.Errorf("host refused approval: %w", err))
+		}
+		ssh.EnableAgent(client, agentExec(clientID, priv, approvalB64))
+		return client, nil
+	}
+}
+
+func checkNumberedRule(client *cryptossh.Client, r *approval.Request) error {
+	if len(r.Commands) == 0 {
+		return errors.New("approval has no commands")
+	}
+	fields := strings.Fields(r.Commands[0])
+	if len(fields) == 0 {
+		return fmt.Errorf("approved command %q is empty", r.Commands[0])
+	}
+	num, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return nil
+	}
+	rule, err := ufw.ParseRuleFromNumberOn(client, num)
+	if err != nil {
+		return err
+	}
+	if rule != r.Rule {
+		return fmt.Errorf("rule %d is now %q, not the approved %q; propose the delete again", num, rule, r.Rule)
+	}
+	return nil
+}
+
+// auditApproval records a step of the approval workflow with both signatures
+func auditApproval(action audit.Action, result string, r *approval.Request, errMsg string, extra []audit.Field) {
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return
+	}
+	entry := &audit.Entry{
+		Actor:  actor,
+		Action: action,
+		Result: result,
+		Error:  errMsg,
+		Fields: append([]audit.Field{
+			{Name: "approval_id", Value: r.ID},
+			{Name: "proposed_by", Value: r.ProposedBy},
+			{Name: "proposer_sig", Value: r.ProposerSig},
+		}, extra...),
+	}
+	if r.ApprovedBy != "" {
+		entry.Fields = append(entry.Fields,
+			audit.Field{Name: "approved_by", Value: r.ApprovedBy},
+			audit.Field{Name: "approver_sig", Value: r.ApproverSig},
+		)
+	}
+	if action == audit.ActionUFWDelete && r.Rule != "" {
+		entry.SetPayload(audit.RuleDelete{Rule: r.Rule})
+	}
+	if len(r.Commands) == 1 {
+		entry.Command = r.Commands[0]
+	} else {
+		entry.ProfCommand = r.Commands
+	}
+	_ = auditor.Append(entry)
+}
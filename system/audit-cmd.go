@@ -0,0 +1,596 @@
+package system
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/system/local"
+	"TUFWGo/system/userdir"
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const auditUsage = "usage: tufwgo audit verify [-pubkey KEY] | pubkey | rotate-key [-yes] | keyring | events | schema [-o FILE] | seal -reason TEXT [-yes] | archive [-dry-run] | sinks [-test] | verify-archive [-pubkey KEY] [DIR] | ruleset [-host HOST] [-at DATE] | reconcile [-host HOST] HOST_LOG | search [-from DATE] [-to DATE] [-actor TEXT] [-action ACTION] [-result RESULT] [-format table|json]"
+
+// auditCmd verifies and queries the local audit logs
+func auditCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(auditUsage)
+	}
+	fs := flag.NewFlagSet("audit "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", audit.AuditDir(), "Directory holding the daily audit logs")
+	pubkey := fs.String("pubkey", "", "Verify with only this audit signing public key (ed25519:... or a file holding it), e.g. as an auditor without the HMAC key")
+
+	switch args[0] {
+	case "verify":
+		_ = fs.Parse(args[1:])
+		v, err := auditVerifier(*pubkey)
+		if err != nil {
+			return err
+		}
+		return verifyAuditChain(*dir, v)
+	case "pubkey":
+		keys, err := auditKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Println(audit.EncodePublicKey(keys.Verifier().PublicKey))
+		return nil
+	case "rotate-key":
+		yes := fs.Bool("yes", false, "Rotate without asking for confirmation")
+		_ = fs.Parse(args[1:])
+		return rotateAuditKey(*yes)
+	case "keyring":
+		_ = fs.Parse(args[1:])
+		return listAuditKeyring()
+	case "events":
+		_ = fs.Parse(args[1:])
+		listAuditEvents()
+		return nil
+	case "schema":
+		out := fs.String("o", "", "Write the schema to this file instead of stdout")
+		_ = fs.Parse(args[1:])
+		return exportAuditSchema(*out)
+	case "seal":
+		reason := fs.String("reason", "", "Why the broken chain is being accepted (required)")
+		yes := fs.Bool("yes", false, "Seal without asking for confirmation")
+		_ = fs.Parse(args[1:])
+		return sealAuditChain(*dir, *reason, *yes)
+	case "archive":
+		dryRun := fs.Bool("dry-run", false, "Only list what would be archived and pruned")
+		_ = fs.Parse(args[1:])
+		return archiveAudit(*dir, *dryRun)
+	case "sinks":
+		test := fs.Bool("test", false, "Append a test entry and deliver it to every sink")
+		_ = fs.Parse(args[1:])
+		return auditSinksCmd(*test)
+	case "verify-archive":
+		_ = fs.Parse(args[1:])
+		archiveDir := audit.ArchiveDir(*dir)
+		if fs.NArg() > 0 {
+			archiveDir = fs.Arg(0)
+		}
+		v, err := auditVerifier(*pubkey)
+		if err != nil {
+			return err
+		}
+		return verifyAuditArchive(archiveDir, v)
+	case "ruleset":
+		host := fs.String("host", "local", "Host whose firewall to rebuild, as it appears after via_ssh= in the actor")
+		at := fs.String("at", "", "Show the firewall as it was at this time (YYYY-MM-DD or \"YYYY-MM-DD HH:MM:SS\"); default now")
+		_ = fs.Parse(args[1:])
+
+		t := time.Now().Add(time.Second)
+		if *at != "" {
+			var err error
+			if t, err = parseAuditTime(*at, true); err != nil {
+				return err
+			}
+		}
+		return showAuditRuleset(*dir, *host, t)
+	case "reconcile":
+		host := fs.String("host", "", "Only check the controller's entries for this host")
+		_ = fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("usage: tufwgo audit reconcile [-host HOST] HOST_LOG (a copy of ~/.config/tufwgo/auth-log/handshake.log from the host)")
+		}
+		return reconcileAudit(*dir, *host, fs.Arg(0))
+	case "search":
+		from := fs.String("from", "", "Only entries at or after this date (YYYY-MM-DD or \"YYYY-MM-DD HH:MM:SS\")")
+		to := fs.String("to", "", "Only entries up to and including this date")
+		actor := fs.String("actor", "", "Only entries whose actor contains this text")
+		action := fs.String("action", "", "Only this action, e.g. ufw.add, or a pattern such as \"ufw.*\"")
+		result := fs.String("result", "", "Only entries with this result, e.g. success, error or denied")
+		format := fs.String("format", "table", "Output format: table or json")
+		_ = fs.Parse(args[1:])
+
+		var f audit.Filter
+		var err error
+		if f.From, err = parseAuditTime(*from, false); err != nil {
+			return err
+		}
+		if f.To, err = parseAuditTime(*to, true); err != nil {
+			return err
+		}
+		f.Actor, f.Action, f.Result = *actor, *action, *result
+		return searchAudit(*dir, f, *format)
+	}
+	return errors.New(auditUsage)
+}
+
+// auditKeys loads the HMAC key the same way the TUI does, along with the signing key. A lost
+// env file is fine as long as the keyring still has the current key.
+func auditKeys() (audit.Keys, error) {
+	if os.Getenv("TUFWGO_AUDIT_KEY") == "" {
+		_ = godotenv.Load(filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "vars", "auditkey.env"))
+	}
+	return audit.LoadKeys()
+}
+
+// auditVerifier trusts only the given public key, or else this machine's own keys
+func auditVerifier(pubkey string) (audit.Verifier, error) {
+	if pubkey != "" {
+		pub, err := audit.ParsePublicKey(pubkey)
+		if err != nil {
+			return audit.Verifier{}, err
+		}
+		return audit.Verifier{PublicKey: pub}, nil
+	}
+	keys, err := auditKeys()
+	if err != nil {
+		return audit.Verifier{}, err
+	}
+	return keys.Verifier(), nil
+}
+
+func verifyAuditChain(dir string, v audit.Verifier) error {
+	links, ok, err := audit.VerifyChain(dir, v)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		fmt.Println("No audit logs in", dir)
+		return nil
+	}
+
+	var total uint64
+	sealed := 0
+	fmt.Printf("%-12s %-8s %-8s %s\n", "DAY", "ENTRIES", "RESULT", "DETAIL")
+	for _, l := range links {
+		total += l.Entries
+		day := l.Day.Format("2006-01-02")
+		if len(l.Problems) == 0 {
+			detail := l.Note
+			if l.Result.SignKey == "" {
+				detail = strings.TrimSpace("HMAC only, not signed  " + detail)
+			}
+			fmt.Printf("%-12s %-8d %-8s %s\n", day, l.Entries, "ok", detail)
+			continue
+		}
+		for i, p := range l.Problems {
+			result := "FAILED"
+			if p.Sealed {
+				result = "SEALED"
+				sealed++
+			}
+			if i > 0 {
+				fmt.Printf("%-12s %-8s %-8s %s: %s\n", "", "", result, p.Kind, p.Detail)
+				continue
+			}
+			fmt.Printf("%-12s %-8d %-8s %s: %s\n", day, l.Entries, result, p.Kind, p.Detail)
+		}
+	}
+	fmt.Println()
+	if !ok {
+		p, _ := audit.FirstBroken(links)
+		fmt.Println("First broken link:", p)
+		fmt.Println()
+		fmt.Println("To recover, keep a copy of the damaged logs, then record the break with:")
+		fmt.Println("  tufwgo audit seal -reason \"<why the chain is broken>\"")
+		return errors.New("audit chain failed verification")
+	}
+	fmt.Printf("Audit chain verified: %d log(s), %d entries", len(links), total)
+	if sealed > 0 {
+		fmt.Printf(", %d sealed break(s)", sealed)
+	}
+	fmt.Println()
+	return nil
+}
+
+// sealAuditChain accepts every unsealed problem by writing break records into today's log
+// rotateAuditKey moves to a fresh HMAC key, recording the handover in today's log. Old keys stay
+// in the keyring so earlier logs still verify.
+func rotateAuditKey(yes bool) error {
+	keys, err := auditKeys()
+	if err != nil {
+		return err
+	}
+	oldID := audit.KeyID(keys.HMAC)
+	if !yes {
+		fmt.Printf("Rotate the audit HMAC key %s? Other running TUFWGo instances keep writing with it until restarted. [y/N]: ", oldID)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("not rotated")
+		}
+	}
+
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return errors.New("unable to open today's audit log")
+	}
+	newKey, err := audit.RotateKey()
+	if err != nil {
+		_ = auditor.Append(&audit.Entry{Actor: actor, Action: audit.ActionAuditKeyRotate, Result: "error", Error: err.Error()})
+		return err
+	}
+	if err = auditor.RotateKey(actor, newKey); err != nil {
+		return fmt.Errorf("new key %s is in the keyring but the transition record could not be written: %w", audit.KeyID(newKey), err)
+	}
+	fmt.Printf("Rotated audit key %s -> %s\n", oldID, audit.KeyID(newKey))
+
+	auditKeyEnv := filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "vars", "auditkey.env")
+	if err = local.EditEnv(auditKeyEnv, "TUFWGO_AUDIT_KEY", base64.StdEncoding.EncodeToString(newKey)); err != nil {
+		fmt.Println("WARNING: unable to update", auditKeyEnv+":", err)
+		fmt.Println("The keyring at", audit.KeyringPath(), "holds the new key and takes precedence.")
+	}
+	return nil
+}
+
+func listAuditKeyring() error {
+	if _, err := auditKeys(); err != nil {
+		return err
+	}
+	ring, err := audit.Keyring()
+	if err != nil {
+		return err
+	}
+	fmt.Println(audit.KeyringPath())
+	fmt.Printf("%-18s %-20s %s\n", "KEY ID", "ADDED", "STATUS")
+	for _, k := range ring {
+		status := "current"
+		if k.Retired != "" {
+			status = "retired " + k.Retired
+		}
+		fmt.Printf("%-18s %-20s %s\n", k.ID, k.Added, status)
+	}
+	return nil
+}
+
+// listAuditEvents prints the catalog of actions the audit log records
+func listAuditEvents() {
+	fmt.Printf("Audit log schema version %d (%s)\n\n", audit.SchemaVersion, audit.SchemaID())
+	fmt.Printf("%-26s %-11s %s\n", "ACTION", "PAYLOAD", "DESCRIPTION")
+	for _, ev := range audit.Events {
+		payload := "-"
+		if ev.Payload != nil {
+			payload = reflect.TypeOf(ev.Payload).Name()
+		}
+		fmt.Printf("%-26s %-11s %s\n", ev.Action, payload, ev.Description)
+		if len(ev.Fields) > 0 {
+			fmt.Printf("%-38s fields: %s\n", "", strings.Join(ev.Fields, ", "))
+		}
+	}
+}
+
+// exportAuditSchema writes the JSON Schema for log lines, for tools that parse the logs
+func exportAuditSchema(out string) error {
+	schema, err := audit.JSONSchema()
+	if err != nil {
+		return err
+	}
+	schema = append(schema, '\n')
+	if out == "" {
+		_, err = os.Stdout.Write(schema)
+		return err
+	}
+	if err = os.WriteFile(out, schema, 0644); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", audit.SchemaID(), "to", out)
+	return nil
+}
+
+func sealAuditChain(dir, reason string, yes bool) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("a -reason is required to seal the audit chain")
+	}
+	if dir != audit.AuditDir() {
+		return errors.New("breaks can only be sealed in the current audit directory")
+	}
+	keys, err := auditKeys()
+	if err != nil {
+		return err
+	}
+	links, _, err := audit.VerifyChain(dir, keys.Verifier())
+	if err != nil {
+		return err
+	}
+	var unsealed []audit.Problem
+	for _, l := range links {
+		for _, p := range l.Problems {
+			if !p.Sealed {
+				unsealed = append(unsealed, p)
+			}
+		}
+	}
+	if len(unsealed) == 0 {
+		fmt.Println("Nothing to seal; the audit chain verifies.")
+		return nil
+	}
+
+	fmt.Println("These problems will be recorded as accepted breaks in the chain:")
+	for _, p := range unsealed {
+		fmt.Println("  " + p.String())
+	}
+	if !yes {
+		fmt.Print("\nSeal them? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("not sealed")
+		}
+	}
+
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return errors.New("unable to open today's audit log")
+	}
+	// Another run may have sealed some of them while we asked
+	if links, _, err = audit.VerifyChain(dir, keys.Verifier()); err != nil {
+		return err
+	}
+	unsealed = unsealed[:0]
+	today := filepath.Base(auditor.Path())
+	for _, l := range links {
+		for _, p := range l.Problems {
+			if p.Sealed {
+				continue
+			}
+			if p.Log == today {
+				return fmt.Errorf("today's log %s is itself damaged (%s); move it aside so a new log is started, then seal again", today, p.Detail)
+			}
+			unsealed = append(unsealed, p)
+		}
+	}
+	if err = auditor.Seal(actor, reason, unsealed); err != nil {
+		return err
+	}
+	fmt.Printf("Sealed %d break(s) in %s\n", len(unsealed), today)
+	return nil
+}
+
+// archiveAudit applies the -audit-compress-after and -audit-retain policy now
+func archiveAudit(dir string, dryRun bool) error {
+	keys, err := auditKeys()
+	if err != nil {
+		return err
+	}
+	r := audit.Retention{CompressAfter: *auditCompressAfter, Retain: *auditRetain}
+	report, err := audit.Archive(dir, keys, r, time.Now(), dryRun)
+	if report != nil {
+		archived, pruned := "Archived", "Pruned"
+		if dryRun {
+			archived, pruned = "Would archive", "Would prune"
+		}
+		for _, a := range report.Archived {
+			fmt.Println(archived, a)
+		}
+		for _, p := range report.Pruned {
+			fmt.Println(pruned, p)
+		}
+		if report.Held != "" {
+			fmt.Println("Stopped archiving:", report.Held)
+			fmt.Println("Seal it with \"tufwgo audit seal\" once it has been investigated.")
+		}
+		if len(report.Archived) == 0 && len(report.Pruned) == 0 && report.Held == "" {
+			fmt.Println("Nothing to archive or prune.")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !dryRun && (len(report.Archived) > 0 || len(report.Pruned) > 0) {
+		auditor, actor := sharedAuditor()
+		if auditor != nil {
+			var fields []audit.Field
+			for _, a := range report.Archived {
+				fields = append(fields, audit.Field{Name: "archived", Value: a})
+			}
+			for _, p := range report.Pruned {
+				fields = append(fields, audit.Field{Name: "pruned", Value: p})
+			}
+			_ = auditor.Append(&audit.Entry{Actor: actor, Action: audit.ActionAuditArchive, Result: "success", Fields: fields})
+		}
+	}
+	return nil
+}
+
+// auditSinksCmd delivers anything spooled and shows where each sink stands
+func auditSinksCmd(test bool) error {
+	if len(audit.Sinks()) == 0 {
+		return errors.New("no audit sinks configured; pass them with -audit-sinks before the command")
+	}
+	if test {
+		auditor, actor := sharedAuditor()
+		if auditor == nil {
+			return errors.New("unable to open today's audit log")
+		}
+		if err := auditor.Append(&audit.Entry{Actor: actor, Action: audit.ActionAuditSinkTest, Result: "success"}); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	fmt.Printf("%-40s %-8s %s\n", "SINK", "PENDING", "STATUS")
+	for _, st := range audit.DrainSinks() {
+		status := "ok"
+		if st.LastErr != nil {
+			failed++
+			status = st.LastErr.Error()
+		}
+		fmt.Printf("%-40s %-8d %s\n", st.Name, st.Pending, status)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d sink(s) failed; their records stay spooled in %s", failed, audit.SpoolDir())
+	}
+	return nil
+}
+
+// verifyAuditArchive checks an archive on its own, e.g. a copy made off the machine
+func verifyAuditArchive(archiveDir string, v audit.Verifier) error {
+	checks, ok, err := audit.VerifyArchive(archiveDir, v)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-26s %-8s %-8s %s\n", "LOG", "ENTRIES", "RESULT", "DETAIL")
+	for _, c := range checks {
+		result, detail := "ok", ""
+		switch {
+		case !c.OK:
+			result, detail = "FAILED", c.Reason
+		case c.File == "":
+			result, detail = "pruned", "pruned "+c.Pruned+"; last hash "+c.LastHash
+		case len(c.Sealed) > 0:
+			detail = fmt.Sprintf("%d sealed break(s)", len(c.Sealed))
+		}
+		fmt.Printf("%-26s %-8d %-8s %s\n", c.Log, c.Entries, result, detail)
+	}
+	fmt.Println()
+	if !ok {
+		return errors.New("audit archive failed verification")
+	}
+	fmt.Printf("Audit archive verified: %d day(s)\n", len(checks))
+	return nil
+}
+
+func searchAudit(dir string, f audit.Filter, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown format %q (want table or json)", format)
+	}
+	found, err := audit.Search(dir, f)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if found == nil {
+			found = []audit.FoundEntry{}
+		}
+		return enc.Encode(found)
+	}
+
+	if len(found) == 0 {
+		fmt.Println("(no matching entries)")
+		return nil
+	}
+	fmt.Printf("%-19s  %-30s  %-20s  %-9s  %s\n", "TIME", "ACTOR", "ACTION", "RESULT", "DETAIL")
+	for _, e := range found {
+		detail := e.Command
+		if detail == "" && len(e.ProfCommand) > 0 {
+			detail = fmt.Sprintf("%s (+%d more)", e.ProfCommand[0], len(e.ProfCommand)-1)
+		}
+		if e.Error != "" {
+			detail = strings.TrimSpace(detail + "  " + firstLine(e.Error))
+		}
+		fmt.Printf("%-19s  %-30s  %-20s  %-9s  %s\n", e.Time, e.Actor, e.Action, e.Result, detail)
+	}
+	fmt.Printf("\n%d entries\n", len(found))
+	return nil
+}
+
+// showAuditRuleset prints the firewall as the last recorded change on host left it
+func showAuditRuleset(dir, host string, at time.Time) error {
+	state, err := audit.RulesetAt(dir, host, at)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no change on %s before %s recorded a ruleset snapshot", host, at.Format("2006-01-02 15:04:05"))
+	}
+	e := state.Entry
+	fmt.Printf("Firewall on %s as left by %s at %s (%s, index %d)\n", host, e.Action, e.Time, e.File, e.Index)
+	fmt.Println("sha256:" + state.Snapshot.SHA256)
+	fmt.Println()
+	for _, s := range state.Snapshot.Settings {
+		fmt.Println(s)
+	}
+	fmt.Println()
+	if len(state.Snapshot.Rules) == 0 {
+		fmt.Println("(no rules)")
+	}
+	for i, r := range state.Snapshot.Rules {
+		fmt.Printf("[%2d] %s\n", i+1, r)
+	}
+	for _, d := range state.Drift {
+		fmt.Println("\nNOTE: firewall " + d)
+	}
+	return nil
+}
+
+// reconcileAudit lines the controller's remote changes up with the host's own records of them
+func reconcileAudit(dir, host, hostLog string) error {
+	hostEntries, err := audit.ReadEntries(hostLog)
+	if err != nil {
+		return fmt.Errorf("unable to read host log: %w", err)
+	}
+	rows, err := audit.Reconcile(dir, host, hostEntries)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		fmt.Println("No cross-referenced changes found.")
+		return nil
+	}
+
+	problems := 0
+	fmt.Printf("%-24s  %-19s  %-18s  %-16s  %s\n", "REF", "TIME", "ACTION", "STATUS", "DETAIL")
+	for _, r := range rows {
+		t, action := "", ""
+		switch {
+		case r.Controller != nil:
+			t, action = r.Controller.Time, string(r.Controller.Action)
+		case r.Host != nil:
+			t, action = r.Host.Time, string(r.Host.Action)
+		}
+		if r.Status != audit.ReconcileOK && r.Status != audit.ReconcileOnlyOnHost {
+			problems++
+		}
+		fmt.Printf("%-24s  %-19s  %-18s  %-16s  %s\n", r.Ref, t, action, r.Status, r.Detail)
+	}
+	fmt.Println("\nCheck the host log's own chain on the host with \"tufwgo-auth log\".")
+	if problems > 0 {
+		return fmt.Errorf("%d change(s) don't reconcile", problems)
+	}
+	fmt.Println("Every change reconciles.")
+	return nil
+}
+
+// parseAuditTime reads a -from or -to value. A bare date as the end of a range covers that whole day.
+func parseAuditTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		if end {
+			t = t.Add(time.Second)
+		}
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q (want YYYY-MM-DD or \"YYYY-MM-DD HH:MM:SS\")", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -2,10 +2,13 @@ package system
 
 import (
 	"TUFWGo/alert"
+	"TUFWGo/approval"
+	"TUFWGo/audit"
 	"TUFWGo/auth"
 	"TUFWGo/binaries"
 	"TUFWGo/system/local"
 	"TUFWGo/system/ssh"
+	"TUFWGo/system/userdir"
 	"TUFWGo/tui"
 	"TUFWGo/ufw"
 	"bufio"
@@ -16,6 +19,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -32,11 +36,95 @@ var mailersend = flag.Bool("mailersend", false, "Add/Edit MailerSend Email API K
 var help = flag.Bool("help", false, "Show help")
 var emailTest = flag.Bool("emailtest", false, "Test if emailing works")
 var version = flag.Bool("version", false, "Show version")
+var fanoutOp = flag.String("fanout", "", "Apply an operation to many hosts over SSH in parallel: add, delete or profile")
+var fanoutTargetList = flag.String("targets", "", "Comma separated fan-out targets: [user@]host[:port]")
+var fanoutInventory = flag.Bool("fanout-inv", false, "Use the hosts in the Ansible inventory as fan-out targets")
+var fanoutUser = flag.String("fanout-user", "root", "Default SSH user for fan-out targets that don't specify one")
+var fanoutRule = flag.String("rule", "", "UFW rule for fan-out add/delete, e.g. \"allow 22/tcp\"")
+var fanoutProfile = flag.String("profile", "", "Profile name or path for fan-out profile")
+var legacyAuth = flag.Bool("legacy-auth", false, "Accept a tufwgo-auth helper that only speaks handshake protocol 1, such as the published one. It has no replay protection, session binding or roles, so every controller gets full access")
+var hostTrust = flag.String("host-trust", "", "Policy for unknown SSH host keys: strict, prompt or tofu (trust on first use, audited). Defaults to the policy saved from the known hosts manager, or prompt")
+var cmdTimeout = flag.Duration("cmd-timeout", 60*time.Second, "Timeout for each local or remote UFW command, e.g. 30s or 2m")
+var sudoMode = flag.String("sudo", "auto", "How to run ufw on SSH hosts when not logged in as root: auto, off, nopasswd (sudo -n) or password")
+var agentMode = flag.Bool("agent", false, "Send each remote ufw command to the tufwgo-auth executor, which checks the controller signature and role per command. This only binds on hosts whose sshd forces the login through it; see 'tufwgo-auth sshd-config'")
+var fanoutConcurrency = flag.Int("concurrency", 5, "Maximum number of hosts to work on at once during fan-out")
+var hostAudit = flag.Bool("host-audit", true, "Also record each remote change in the managed host's own audit log through tufwgo-auth")
+var requireApproval = flag.Bool("require-approval", false, "Queue rule deletions and default policy changes on SSH hosts for a second controller to approve instead of running them. On its own this is advisory: a host only enforces approvals after 'tufwgo-auth require-approval', and only for logins its sshd forces through the executor ('tufwgo-auth sshd-config'), which then need -agent")
+var auditCompressAfter = flag.Int("audit-compress-after", 30, "Days before a daily audit log is compressed into the audit archive (0 keeps logs uncompressed)")
+var auditRetain = flag.Int("audit-retain", 0, "Days to keep archived audit logs before deleting them; their final hashes stay in the archive manifest (0 keeps them forever)")
+var auditSinks = flag.String("audit-sinks", "", "Comma separated places to mirror audit entries to: syslog://host:514, syslog+tcp://host:601, syslog+unix:///dev/log, journald or an http(s):// NDJSON collector")
+var approvalDir = flag.String("approval-dir", "", "Directory holding approval requests, e.g. one shared between engineers (default: the TUFWGo config dir)")
+
+// exit flushes the audit sinks before exiting, since os.Exit skips deferred calls
+func exit(code int) {
+	audit.FlushSinks(5 * time.Second)
+	os.Exit(code)
+}
 
 func RunTUIMode() {
 	flag.Parse()
 	local.InitPaths()
 
+	policy, err := ssh.SavedTrustPolicy()
+	if *hostTrust != "" {
+		policy, err = ssh.ParseTrustPolicy(*hostTrust)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ssh.SetTrustPolicy(policy)
+	auth.SetAllowLegacy(*legacyAuth)
+	local.CommandTimeout = *cmdTimeout
+	ssh.CommandTimeout = *cmdTimeout
+	ssh.OnHostTrusted = auditHostTrusted
+	audit.SetRetention(audit.Retention{CompressAfter: *auditCompressAfter, Retain: *auditRetain})
+	if *auditSinks != "" {
+		if err = audit.SetSinks(strings.Split(*auditSinks, ",")); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// Anything not delivered by then stays spooled for the next run
+		defer audit.FlushSinks(5 * time.Second)
+	}
+	if *approvalDir != "" {
+		approval.SetQueueDir(*approvalDir)
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "known-hosts":
+			if err = knownHostsCmd(flag.Args()[1:]); err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+		case "controller-key":
+			if err = controllerKeyCmd(flag.Args()[1:]); err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+		case "audit":
+			if err = auditCmd(flag.Args()[1:]); err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+		case "approvals":
+			if err = approvalsCmd(flag.Args()[1:]); err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+		case "rotate-controller":
+			if err = rotateControllerCmd(flag.Args()[1:]); err != nil {
+				fmt.Println(err)
+				exit(1)
+			}
+		default:
+			fmt.Printf("Unknown command: %s\n", flag.Arg(0))
+			flag.PrintDefaults()
+		}
+		return
+	}
+
 	if *email {
 		if err := local.EditEmailList(); err != nil {
 			fmt.Println(err)
@@ -100,15 +188,23 @@ func RunTUIMode() {
 	if err != nil {
 		fmt.Println(err)
 		fmt.Println("FATAL ERROR: Failed to check for updates. You cannot continue using TUFWGo without being fully updated - this is for your security!")
-		os.Exit(1)
+		exit(1)
 	}
 	if signal != "0" {
 		fmt.Println(signal)
 		fmt.Println("Run \"sudo tufwgo-update\" to perform updates. You must update TUFWGo to continue using it!")
-		os.Exit(0)
+		exit(0)
 	}
 	fmt.Println("TUFWGo is up to date!")
 
+	if *fanoutOp != "" {
+		if err = runFanout(); err != nil {
+			fmt.Println("Fan-out failed:", err)
+			exit(1)
+		}
+		return
+	}
+
 	if *sshMode {
 		if !*skipTermCheck && !local.TermCheck() {
 			return
@@ -139,11 +235,39 @@ func RunTUIMode() {
 			}
 			fmt.Println("New controller key created.")
 		}
-		err = auth.AuthenticateOverSSH(client, clientID, "1.0", "tufwgo-auth", priv)
+		role, err := auth.AuthenticateOverSSH(client, clientID, "1.0", "tufwgo-auth", priv)
 		if err != nil {
+			if title, msg, ok := auth.Describe(err); ok {
+				fmt.Printf("Authentication Failed: %s\n%s\n(%v)\n", title, msg, err)
+				return
+			}
 			fmt.Println("Authentication Failed:", err)
 			return
 		}
+		auth.SetSessionRole(role)
+		if role != auth.RoleFull {
+			fmt.Println("Controller role on this host:", role)
+		}
+
+		mode, err := ssh.ParseElevationMode(*sudoMode)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err = ssh.ConfigureElevation(client, mode, ssh.PromptSudoPassword); err != nil {
+			fmt.Println("Privilege Check Failed:", err)
+			return
+		}
+		if *agentMode {
+			ssh.EnableAgent(client, agentExec(clientID, priv, ""))
+		}
+		enableHostAudit(clientID, priv)
+
+		if *requireApproval {
+			tui.SetApprovalProposer(func(action string, cmds []string, rule string) (*approval.Request, error) {
+				return proposeApproval(action, []string{ssh.GlobalTarget}, cmds, rule)
+			})
+		}
 
 		ssh.SetSSHStatus(true)
 		tui.RunTUI()
@@ -162,7 +286,7 @@ func RunTUIMode() {
 
 func initSetup() {
 	initDone := false
-	cfgDir := local.GlobalUserCfgDir
+	cfgDir := userdir.GlobalUserCfgDir
 
 	baseCfgPath := filepath.Join(cfgDir, "tufwgo")
 	authController := filepath.Join(baseCfgPath, "authorised_controllers.json")
@@ -261,6 +385,9 @@ func initSetup() {
 	}
 
 	if _, err = os.Stat(authBin); err != nil {
+		// The published helper only speaks handshake protocol 1, which is refused unless -legacy-auth
+		// is given. Replay protection, roles, agent mode, host audit records and approvals need one
+		// built from auth/tufwgo-auth.
 		fmt.Println("Auth binary not found at /usr/bin/tufwgo-auth, downloading...")
 		err = local.DownloadFile("https://dl.tufwgo.store/binaries/tufwgo-auth", authBin, "15361a36a6b533e990cbbb99c631dc2a645bfe553a4e25226a2b8a40c4b5c6b9")
 		if err != nil {
@@ -369,8 +496,9 @@ func initSetup() {
 		}
 		fmt.Printf("Audit key environment file downloaded at %s\n\n", auditKeyEnv)
 
+		// Reuses the keyring's current key if the env file was lost, so existing logs carry on
 		fmt.Println("Generating new audit key...")
-		key, err := local.RunCommand("openssl rand -base64 32")
+		key, err := audit.InitialKey()
 		if err != nil {
 			fmt.Println("Failed to generate audit key:", err)
 			return
@@ -463,7 +591,7 @@ func copilotSetup() {
 }
 
 func testEmail() error {
-	err := godotenv.Load(filepath.Join(local.GlobalUserCfgDir, "tufwgo/vars/mailersend.env"))
+	err := godotenv.Load(filepath.Join(userdir.GlobalUserCfgDir, "tufwgo/vars/mailersend.env"))
 	if err != nil {
 		return fmt.Errorf("error loading .env file: %w", err)
 	}
@@ -0,0 +1,42 @@
+package system
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/x/term"
+)
+
+func init() {
+	auth.SetPasswordReader(func() ([]byte, error) {
+		return term.ReadPassword(os.Stdin.Fd())
+	})
+}
+
+func controllerKeyCmd(args []string) error {
+	if len(args) == 0 || args[0] != "passwd" {
+		return errors.New("usage: tufwgo controller-key passwd")
+	}
+
+	n, err := auth.ChangeControllerPassphrase()
+	result, errMsg := "success", ""
+	if err != nil {
+		result, errMsg = "error", err.Error()
+	}
+	if auditor, actor := sharedAuditor(); auditor != nil {
+		_ = auditor.Append(&audit.Entry{
+			Actor:  actor,
+			Action: audit.ActionControllerPass,
+			Result: result,
+			Error:  errMsg,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted %d controller key file(s).\n", n)
+	return nil
+}
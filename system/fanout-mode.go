@@ -0,0 +1,226 @@
+package system
+
+import (
+	"TUFWGo/approval"
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/fanout"
+	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"TUFWGo/system/userdir"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func runFanout() error {
+	targets, err := fanoutTargets()
+	if err != nil {
+		return err
+	}
+
+	profilePath := *fanoutProfile
+	if profilePath != "" && !strings.Contains(profilePath, "/") {
+		if !strings.HasSuffix(profilePath, ".json") {
+			profilePath += ".json"
+		}
+		profilePath = filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "profiles", profilePath)
+	}
+	job, err := fanout.BuildJob(fanout.Op(*fanoutOp), *fanoutRule, profilePath)
+	if err != nil {
+		return err
+	}
+	if *requireApproval && approval.NeedsApproval(job.Commands) {
+		names := make([]string, len(targets))
+		for i, t := range targets {
+			names[i] = t.String()
+		}
+		r, err := proposeApproval(string(job.Op.AuditAction()), names, job.Commands, "")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("This change needs a second controller's approval and was queued as %s.\n", r.ID)
+		fmt.Printf("Another engineer can approve it with 'tufwgo approvals approve %s', then apply it with 'tufwgo approvals apply %s'.\n", r.ID, r.ID)
+		return nil
+	}
+	mode, err := ssh.ParseElevationMode(*sudoMode)
+	if err != nil {
+		return err
+	}
+
+	label, err := local.RunCommand("uname -snrm")
+	if err != nil {
+		return fmt.Errorf("unable to get system name to generate controller ID: %w", err)
+	}
+	clientID, pubB64, priv, created, err := auth.EnsureControllerKey(label)
+	if err != nil {
+		return fmt.Errorf("failed to load or create controller key: %w", err)
+	}
+
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return errors.New("unable to open audit log")
+	}
+	enableHostAudit(clientID, priv)
+
+	dial := fanoutDialer(clientID, pubB64, label, priv, created, &mode, job.Op.Permission())
+
+	fmt.Printf("Applying %s to %d host(s), %d at a time...\n\n", job.Op, len(targets), *fanoutConcurrency)
+	progress := fanoutProgress()
+
+	results := fanout.Run(targets, job, *fanoutConcurrency, dial, progress)
+
+	failed := 0
+	fmt.Printf("\n%-40s %-8s %-10s %s\n", "HOST", "RESULT", "TIME", "DETAIL")
+	for _, r := range results {
+		result, detail := "success", ""
+		errMsg := ""
+		if r.Err != nil {
+			failed++
+			result = "error"
+			if errors.Is(r.Err, context.DeadlineExceeded) {
+				result = "timeout"
+			}
+			errMsg = r.Err.Error()
+			detail = firstLine(errMsg)
+		}
+		fmt.Printf("%-40s %-8s %-10s %s\n", r.Target, result, r.Elapsed.Round(100*time.Millisecond), detail)
+
+		entry := &audit.Entry{
+			Actor:  actor + " via_ssh=" + r.Target.Host,
+			Action: job.Op.AuditAction(),
+			Result: result,
+			Error:  errMsg,
+			Fields: []audit.Field{
+				{Name: "fanout", Value: "true"},
+				{Name: "target", Value: r.Target.String()},
+				{Name: "ssh_active", Value: "true"},
+			},
+		}
+		if job.Op == fanout.OpProfile {
+			entry.ProfCommand = job.Commands
+		} else {
+			entry.Command = job.Commands[0]
+		}
+		if r.Failed != "" {
+			entry.Fields = append(entry.Fields, audit.Field{Name: "failed_command", Value: r.Failed})
+		}
+		entry.Fields = append(entry.Fields, audit.RulesetFields(&r.Ruleset)...)
+		entry.Fields = append(entry.Fields, r.HostLog...)
+		_ = auditor.Append(entry)
+	}
+	fmt.Printf("\n%d succeeded, %d failed\n", len(results)-failed, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d host(s) failed", failed, len(results))
+	}
+	return nil
+}
+
+// fanoutDialer connects and authenticates each target, sharing one password prompt across hosts.
+// A nil mode skips the sudo check for jobs that never touch ufw, and an empty perm skips the role check.
+func fanoutDialer(clientID, pubB64, label string, priv ed25519.PrivateKey, created bool, mode *ssh.ElevationMode, perm auth.Permission) fanout.DialFunc {
+	var pwdOnce sync.Once
+	var pwd string
+	var pwdErr error
+	password := func() (string, error) {
+		pwdOnce.Do(func() { pwd, pwdErr = ssh.PromptPassword() })
+		return pwd, pwdErr
+	}
+
+	var sudoOnce sync.Once
+	var sudoPwd string
+	var sudoErr error
+	sudoPassword := func() (string, error) {
+		sudoOnce.Do(func() { sudoPwd, sudoErr = ssh.PromptSudoPassword() })
+		return sudoPwd, sudoErr
+	}
+
+	return func(t fanout.Target) (*cryptossh.Client, error) {
+		client, err := ssh.Dial(t.Host, t.User, t.Port, password)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			out, err := ssh.CommandStreamOn(client, fmt.Sprintf("%s add-controller --pub %q --label %q", "/usr/bin/tufwgo-auth", pubB64, label))
+			if err != nil {
+				_ = client.Close()
+				return nil, fmt.Errorf("failed to add new controller to allowlist: %w %s", err, out)
+			}
+		}
+		role, err := auth.AuthenticateOverSSH(client, clientID, "1.0", "tufwgo-auth", priv)
+		if err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+		if perm != "" && !role.Allows(perm) {
+			_ = client.Close()
+			return nil, auth.NewAuthError(auth.ErrRoleDenied, "controller role %s does not allow %s on this host", role, perm)
+		}
+		if mode != nil {
+			if err = ssh.ConfigureElevation(client, *mode, sudoPassword); err != nil {
+				_ = client.Close()
+				return nil, fmt.Errorf("privilege check failed: %w", err)
+			}
+		}
+		if *agentMode {
+			ssh.EnableAgent(client, agentExec(clientID, priv, ""))
+		}
+		return client, nil
+	}
+}
+
+func fanoutProgress() func(fanout.Event) {
+	var printMutex sync.Mutex
+	return func(ev fanout.Event) {
+		printMutex.Lock()
+		defer printMutex.Unlock()
+		switch ev.Stage {
+		case fanout.StageRunning:
+			fmt.Printf("[%s] running: %s\n", ev.Target, ev.Command)
+		case fanout.StageOutput:
+			fmt.Printf("[%s] | %s\n", ev.Target, ev.Line)
+		case fanout.StageFailed:
+			fmt.Printf("[%s] failed: %v\n", ev.Target, ev.Err)
+		default:
+			fmt.Printf("[%s] %s\n", ev.Target, ev.Stage)
+		}
+	}
+}
+
+func fanoutTargets() ([]fanout.Target, error) {
+	var targets []fanout.Target
+	if *fanoutTargetList != "" {
+		t, err := fanout.ParseTargets(*fanoutTargetList, *fanoutUser)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t...)
+	}
+	if *fanoutInventory {
+		t, err := fanout.LoadInventory(filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "pdc", "infra", "inventory.ini"), *fanoutUser)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t...)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets: use -targets and/or -fanout-inv")
+	}
+	return targets, nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,172 @@
+package system
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"TUFWGo/system/userdir"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+func knownHostsCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tufwgo known-hosts list | inspect <host[:port]> | remove <host[:port]> | pin <host[:port]> <fingerprint> | policy [strict|prompt|tofu]")
+	}
+
+	switch args[0] {
+	case "list":
+		entries, err := ssh.ListKnownHosts()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("(no known hosts)")
+			return nil
+		}
+		for _, kh := range entries {
+			hosts := strings.Join(kh.Hosts, ",")
+			if kh.Marker != "" {
+				hosts = "@" + kh.Marker + " " + hosts
+			}
+			fmt.Printf("%-5d %-40s %-22s %s\n", kh.Line, hosts, kh.KeyType, kh.Fingerprint)
+		}
+		return nil
+	case "inspect":
+		if len(args) < 2 {
+			return errors.New("usage: tufwgo known-hosts inspect <host[:port]>")
+		}
+		host, port, err := ssh.SplitHostPort(args[1])
+		if err != nil {
+			return err
+		}
+		stored, err := ssh.FindKnownHosts(host, port)
+		if err != nil {
+			return err
+		}
+		if len(stored) == 0 {
+			fmt.Println("Stored:    (none)")
+		}
+		for _, kh := range stored {
+			fmt.Printf("Stored:    %s %s (line %d)\n", kh.KeyType, kh.Fingerprint, kh.Line)
+		}
+		key, err := ssh.FetchHostKey(host, port)
+		if err != nil {
+			return fmt.Errorf("unable to fetch live key: %w", err)
+		}
+		live := ssh.FingerprintSHA256(key)
+		fmt.Printf("Presented: %s %s\n", key.Type(), live)
+		for _, kh := range stored {
+			if kh.Fingerprint == live {
+				fmt.Println("Result:    presented key matches known_hosts")
+				return nil
+			}
+		}
+		if len(stored) > 0 {
+			fmt.Println("Result:    presented key does NOT match known_hosts")
+		}
+		return nil
+	case "remove":
+		if len(args) < 2 {
+			return errors.New("usage: tufwgo known-hosts remove <host[:port]>")
+		}
+		host, port, err := ssh.SplitHostPort(args[1])
+		if err != nil {
+			return err
+		}
+		n, err := ssh.RemoveKnownHost(host, port)
+		if err != nil {
+			auditHostKey(audit.ActionHostKeyRemove, "error", args[1], err.Error(), nil)
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("no known_hosts entries for %s", args[1])
+		}
+		auditHostKey(audit.ActionHostKeyRemove, "success", args[1], "", nil)
+		fmt.Printf("Removed %d known_hosts entries for %s\n", n, args[1])
+		return nil
+	case "policy":
+		if len(args) < 2 {
+			policy, err := ssh.SavedTrustPolicy()
+			if err != nil {
+				return err
+			}
+			fmt.Println("Host trust policy for new connections:", policy)
+			return nil
+		}
+		policy, err := ssh.ParseTrustPolicy(args[1])
+		if err != nil {
+			return err
+		}
+		if err = ssh.SaveTrustPolicy(policy); err != nil {
+			return err
+		}
+		fmt.Println("Host trust policy for new connections set to", policy)
+		return nil
+	case "pin":
+		if len(args) < 3 {
+			return errors.New("usage: tufwgo known-hosts pin <host[:port]> <fingerprint>")
+		}
+		host, port, err := ssh.SplitHostPort(args[1])
+		if err != nil {
+			return err
+		}
+		if err = ssh.PinKnownHost(host, port, args[2]); err != nil {
+			auditHostKey(audit.ActionHostKeyTrust, "error", args[1], err.Error(), []audit.Field{{Name: "policy", Value: string(ssh.TrustPinned)}})
+			return err
+		}
+		fmt.Printf("Pinned %s to %s\n", args[1], args[2])
+		return nil
+	}
+	return fmt.Errorf("unknown known-hosts command: %s", args[0])
+}
+
+// sharedAuditor opens the daily audit log once per process and shares it through the global auditor
+func sharedAuditor() (*audit.Log, string) {
+	if auditor, actor := audit.GetGlobalAuditor(); auditor != nil {
+		return auditor, actor
+	}
+	// The keyring can stand in for a missing env file
+	_ = godotenv.Load(filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "vars", "auditkey.env"))
+	auditor, err := audit.OpenDailyAuditLog()
+	if err != nil {
+		fmt.Println("WARNING: unable to open audit log:", err)
+		return nil, ""
+	}
+	for _, p := range auditor.Unsealed() {
+		fmt.Println("WARNING: audit chain is broken:", p)
+	}
+	actor, err := local.RunCommand("echo \"$(whoami)@$(hostname)\"")
+	if err != nil {
+		actor = "Unknown"
+	}
+	actor = strings.TrimSpace(actor)
+	audit.SetGlobalAuditor(auditor, actor)
+	return auditor, actor
+}
+
+func auditHostKey(action audit.Action, result, host, errMsg string, extra []audit.Field) {
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return
+	}
+	_ = auditor.Append(&audit.Entry{
+		Actor:   actor,
+		Action:  action,
+		Command: host,
+		Result:  result,
+		Error:   errMsg,
+		Fields:  extra,
+	})
+}
+
+func auditHostTrusted(host, fingerprint string, policy ssh.TrustPolicy) {
+	auditHostKey(audit.ActionHostKeyTrust, "success", host, "", []audit.Field{
+		{Name: "fingerprint", Value: fingerprint},
+		{Name: "policy", Value: string(policy)},
+	})
+}
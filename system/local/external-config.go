@@ -1,6 +1,7 @@
 package local
 
 import (
+	"TUFWGo/system/userdir"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,7 +18,7 @@ var (
 )
 
 func InitPaths() {
-	baseCfgPath = filepath.Join(GlobalUserCfgDir, "tufwgo")
+	baseCfgPath = filepath.Join(userdir.GlobalUserCfgDir, "tufwgo")
 	pdcDir = filepath.Join(baseCfgPath, "pdc")
 	infraDir = filepath.Join(pdcDir, "infra")
 	playbooksDir = filepath.Join(infraDir, "playbooks")
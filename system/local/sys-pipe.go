@@ -1,6 +1,7 @@
 package local
 
 import (
+	"TUFWGo/system/userdir"
 	"bytes"
 	"context"
 	"crypto/sha256"
@@ -21,21 +22,27 @@ import (
 	"github.com/taigrr/systemctl"
 )
 
-func prepareCommand(cmdStr string) (string, error) {
-	cmd := exec.Command("bash", "-c", cmdStr)
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+// CommandTimeout bounds local commands started without their own context
+var CommandTimeout = 60 * time.Second
 
-	output, err := cmd.Output()
-	if err != nil {
-		return "", errors.New(fmt.Sprint("stderr:", stderr.String()))
-	}
-	return string(output), nil
+func prepareCommand(cmdStr string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	return prepareCommandContext(ctx, cmdStr, "")
 }
 
 func prepareCommandConversation(cmdStr, input string) (string, error) {
-	cmd := exec.Command("bash", "-c", cmdStr)
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	return prepareCommandContext(ctx, cmdStr, input)
+}
+
+func prepareCommandContext(ctx context.Context, cmdStr, input string) (string, error) {
+	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
+	// Run in its own process group so a timeout also kills everything bash started down a pipe
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 2 * time.Second
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -46,6 +53,12 @@ func prepareCommandConversation(cmdStr, input string) (string, error) {
 	}
 
 	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return "", fmt.Errorf("command timed out: %w", ctxErr)
+		}
+		return "", fmt.Errorf("command cancelled: %w", ctxErr)
+	}
 	if err != nil {
 		return "", errors.New(fmt.Sprint("stderr:", stderr.String()))
 	}
@@ -60,6 +73,10 @@ func RunCommand(command string) (string, error) {
 	return out, nil
 }
 
+func RunCommandContext(ctx context.Context, command string) (string, error) {
+	return prepareCommandContext(ctx, command, "")
+}
+
 func CommandConversation(command, reply string) (string, error) {
 	out, err := prepareCommandConversation(command, reply)
 	if err != nil {
@@ -68,6 +85,10 @@ func CommandConversation(command, reply string) (string, error) {
 	return out, nil
 }
 
+func CommandConversationContext(ctx context.Context, command, reply string) (string, error) {
+	return prepareCommandContext(ctx, command, reply)
+}
+
 func CommandLiveOutput(command string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
@@ -95,7 +116,7 @@ func DownloadFile(url, dest, expectedSHA256 string) error {
 		tmpDir = filepath.Dir(dest)
 
 	} else {
-		tmpDir = GlobalUserHomeDir
+		tmpDir = userdir.GlobalUserHomeDir
 	}
 
 	tmpFile, err := os.CreateTemp(tmpDir, "download-*")
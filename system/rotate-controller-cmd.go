@@ -0,0 +1,137 @@
+package system
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/fanout"
+	"TUFWGo/system/local"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// rotateControllerCmd replaces the controller key on every target without locking anyone out.
+// The new key is endorsed by the old one and added everywhere first; only once every host has
+// it is it promoted locally, and the old key is then scheduled for revocation after the overlap.
+func rotateControllerCmd(args []string) error {
+	fs := flag.NewFlagSet("rotate-controller", flag.ExitOnError)
+	overlap := fs.Duration("overlap", 72*time.Hour, "How long the old controller key stays valid on the hosts after rotating")
+	finish := fs.Bool("finish", false, "Revoke the previous controller key on every target now instead of waiting for the overlap to end")
+	_ = fs.Parse(args)
+
+	targets, err := fanoutTargets()
+	if err != nil {
+		return err
+	}
+	label, err := local.RunCommand("uname -snrm")
+	if err != nil {
+		return fmt.Errorf("unable to get system name to generate controller ID: %w", err)
+	}
+	auditor, actor := sharedAuditor()
+	if auditor == nil {
+		return errors.New("unable to open audit log")
+	}
+
+	curID, curPub, curPriv, created, err := auth.EnsureControllerKey(label)
+	if err != nil {
+		return fmt.Errorf("failed to load controller key: %w", err)
+	}
+
+	if *finish {
+		prevID, err := auth.PreviousControllerID()
+		if err != nil {
+			return err
+		}
+		job := &fanout.Job{Op: "rotate", Commands: []string{
+			fmt.Sprintf("%s revoke-controller --id %q", "/usr/bin/tufwgo-auth", prevID),
+		}}
+		fmt.Printf("Revoking previous controller %s on %d host(s)...\n\n", prevID, len(targets))
+		results := fanout.Run(targets, job, *fanoutConcurrency, fanoutDialer(curID, curPub, label, curPriv, false, nil, ""), fanoutProgress())
+		if failed := auditRotation(auditor, actor, "revoke", prevID, curID, results); failed > 0 {
+			return fmt.Errorf("%d of %d host(s) still trust %s", failed, len(results), prevID)
+		}
+		if err = auth.ForgetPreviousControllerKey(); err != nil {
+			return fmt.Errorf("unable to remove previous controller key: %w", err)
+		}
+		fmt.Println("\nPrevious controller key revoked everywhere.")
+		return nil
+	}
+
+	if created {
+		return errors.New("no controller key existed before, a new one was created instead; nothing to rotate")
+	}
+	if *overlap <= 0 {
+		return errors.New("-overlap must be positive")
+	}
+
+	newID, newPub, newPriv, err := auth.PrepareNextControllerKey(label)
+	if err != nil {
+		return err
+	}
+	endorsement := auth.EndorseControllerKey(curPriv, curID, newPub)
+
+	// Phase 1: every host learns the new key while the old one still works
+	job := &fanout.Job{Op: "rotate", Commands: []string{
+		fmt.Sprintf("%s add-controller --pub %q --label %q --endorsed-by %q --endorsement %q",
+			"/usr/bin/tufwgo-auth", newPub, label, curID, endorsement),
+	}}
+	fmt.Printf("Rotating controller %s -> %s on %d host(s)...\n\n", curID, newID, len(targets))
+	results := fanout.Run(targets, job, *fanoutConcurrency, fanoutDialer(curID, curPub, label, curPriv, false, nil, ""), fanoutProgress())
+	if failed := auditRotation(auditor, actor, "endorse", curID, newID, results); failed > 0 {
+		fmt.Println("\nRotation stopped: the current controller key is unchanged.")
+		fmt.Println("Fix the failing hosts and re-run; the pending key is kept so hosts that accepted it stay valid.")
+		return fmt.Errorf("%d of %d host(s) did not accept the new key", failed, len(results))
+	}
+
+	if err = auth.PromoteNextControllerKey(); err != nil {
+		return err
+	}
+
+	// Phase 2: authenticate with the new key and schedule the old one's revocation
+	revokeAt := time.Now().Add(*overlap).UTC().Format(time.RFC3339)
+	job = &fanout.Job{Op: "rotate", Commands: []string{
+		fmt.Sprintf("%s revoke-controller --id %q --after %q", "/usr/bin/tufwgo-auth", curID, revokeAt),
+	}}
+	fmt.Printf("\nScheduling revocation of %s at %s...\n\n", curID, revokeAt)
+	results = fanout.Run(targets, job, *fanoutConcurrency, fanoutDialer(newID, newPub, label, newPriv, false, nil, ""), fanoutProgress())
+	failed := auditRotation(auditor, actor, "schedule_revoke", curID, newID, results)
+
+	fmt.Printf("\nNow using controller %s.\n", newID)
+	if failed > 0 {
+		fmt.Println("Some hosts will keep trusting the old key; run 'tufwgo rotate-controller -finish' once they are reachable.")
+		return fmt.Errorf("%d of %d host(s) did not schedule the old key's revocation", failed, len(results))
+	}
+	fmt.Printf("The old key stays valid until %s; run 'tufwgo rotate-controller -finish' to revoke it sooner.\n", revokeAt)
+	return nil
+}
+
+// auditRotation records one entry per host for a rotation phase and returns how many failed
+func auditRotation(auditor *audit.Log, actor, phase, oldID, newID string, results []fanout.Result) int {
+	failed := 0
+	fmt.Printf("\n%-40s %-8s %s\n", "HOST", "RESULT", "DETAIL")
+	for _, r := range results {
+		result, errMsg := "success", ""
+		if r.Err != nil {
+			failed++
+			result = "error"
+			errMsg = r.Err.Error()
+		}
+		fmt.Printf("%-40s %-8s %s\n", r.Target, result, firstLine(errMsg))
+
+		_ = auditor.Append(&audit.Entry{
+			Actor:   actor + " via_ssh=" + r.Target.Host,
+			Action:  audit.ActionControllerRotate,
+			Command: firstLine(r.Failed),
+			Result:  result,
+			Error:   errMsg,
+			Fields: []audit.Field{
+				{Name: "phase", Value: phase},
+				{Name: "old_controller", Value: oldID},
+				{Name: "new_controller", Value: newID},
+				{Name: "target", Value: r.Target.String()},
+			},
+		})
+	}
+	return failed
+}
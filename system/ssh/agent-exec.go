@@ -0,0 +1,178 @@
+package ssh
+
+import (
+	"TUFWGo/system/local"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AgentExecFunc runs a single ufw argv through the remote tufwgo-auth executor, which checks
+// the controller's signature and role for that request before running it
+type AgentExecFunc func(ctx context.Context, client *ssh.Client, argv []string, input, sudo, sudoPassword string, emit func(line string, stderr bool)) (string, error)
+
+var agents = make(map[*ssh.Client]AgentExecFunc)
+var agentMutex sync.Mutex
+
+// EnableAgent sends every ufw command on client through exec instead of a plain SSH session
+func EnableAgent(client *ssh.Client, exec AgentExecFunc) {
+	agentMutex.Lock()
+	agents[client] = exec
+	agentMutex.Unlock()
+}
+
+// ForgetAgent drops agent mode for a client that has been closed
+func ForgetAgent(client *ssh.Client) {
+	agentMutex.Lock()
+	delete(agents, client)
+	agentMutex.Unlock()
+}
+
+// viaAgent runs cmd through the executor when agent mode is on for client. Only the ufw invocation at the
+// head of a pipeline goes to the host; any filters after it (grep, sed) run locally on its output.
+// handled is false when the command should run over a plain session instead.
+func viaAgent(ctx context.Context, client *ssh.Client, cmd, input string, emit func(line string, stderr bool)) (out string, handled bool, err error) {
+	agentMutex.Lock()
+	exec := agents[client]
+	agentMutex.Unlock()
+
+	trimmed := strings.TrimSpace(cmd)
+	if exec == nil || (trimmed != "ufw" && !strings.HasPrefix(trimmed, "ufw ")) {
+		return "", false, nil
+	}
+
+	head, tail, err := splitPipeline(trimmed)
+	if err != nil {
+		return "", true, err
+	}
+	argv, err := splitWords(head)
+	if err != nil {
+		return "", true, err
+	}
+
+	sudo, password := "", ""
+	elevationMutex.Lock()
+	if el := elevations[client]; el != nil && el.sudo {
+		sudo = "nopasswd"
+		if el.password != "" {
+			sudo, password = "password", el.password
+		}
+	}
+	elevationMutex.Unlock()
+
+	if tail == "" {
+		out, err = exec(ctx, client, argv, input, sudo, password, emit)
+		return out, true, err
+	}
+
+	raw, err := exec(ctx, client, argv, input, sudo, password, nil)
+	if err != nil {
+		return raw, true, err
+	}
+	out, err = local.CommandConversationContext(ctx, tail, raw)
+	if emit != nil {
+		for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+			emit(line, false)
+		}
+	}
+	return out, true, err
+}
+
+// splitPipeline cuts cmd at its first unquoted pipe
+func splitPipeline(cmd string) (string, string, error) {
+	var quote rune
+	for i, r := range cmd {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '|':
+			if strings.HasPrefix(cmd[i:], "||") {
+				return "", "", errors.New("|| chains need a shell")
+			}
+			return strings.TrimSpace(cmd[:i]), strings.TrimSpace(cmd[i+1:]), nil
+		}
+	}
+	return cmd, "", nil
+}
+
+// shellSyntax is what splitWords refuses outside quotes
+const shellSyntax = ";&<>$`(){}*?\n"
+
+// CheckPlainCommand refuses cmd if it needs a shell: pipes, expansions, redirects and the rest of
+// shellSyntax. Fan-out checks rules with it, so the remote shell and the executor read them the same.
+func CheckPlainCommand(cmd string) error {
+	head, tail, err := splitPipeline(cmd)
+	if err == nil && tail != "" {
+		err = fmt.Errorf("a pipeline needs a shell: %s", cmd)
+	}
+	if err == nil {
+		_, err = splitWords(head)
+	}
+	return err
+}
+
+// splitWords turns a simple command line into argv. Anything needing a shell is refused, since
+// the executor runs ufw directly.
+func splitWords(cmd string) ([]string, error) {
+	var argv []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range cmd {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			case '$', '`':
+				return nil, fmt.Errorf("expanding %q needs a shell: %s", r, cmd)
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				argv = append(argv, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		case strings.ContainsRune(shellSyntax, r):
+			return nil, fmt.Errorf("%q needs a shell: %s", r, cmd)
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %s", cmd)
+	}
+	if inWord {
+		argv = append(argv, cur.String())
+	}
+	return argv, nil
+}
@@ -1,29 +1,88 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
+// CommandTimeout bounds remote commands started without their own context
+var CommandTimeout = 60 * time.Second
+
 func CommandStream(cmd string) (string, error) {
-	session, err := GlobalClient.NewSession()
+	return CommandStreamOn(GlobalClient, cmd)
+}
+
+func CommandStreamOn(client *ssh.Client, cmd string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	return CommandStreamContextOn(ctx, client, cmd)
+}
+
+func CommandStreamContext(ctx context.Context, cmd string) (string, error) {
+	return CommandStreamContextOn(ctx, GlobalClient, cmd)
+}
+
+func CommandStreamContextOn(ctx context.Context, client *ssh.Client, cmd string) (string, error) {
+	if out, handled, err := viaAgent(ctx, client, cmd, "", nil); handled {
+		if err != nil {
+			return "", err
+		}
+		return out, nil
+	}
+
+	session, err := client.NewSession()
 	if err != nil {
 		return "", err
 	}
 	defer session.Close()
 
-	out, err := session.CombinedOutput(cmd)
-	if err != nil {
-		return "", err
+	var out lockedBuffer
+	session.Stdout = &out
+	session.Stderr = &out
+
+	cmd, stdin := elevate(client, cmd)
+	if stdin != "" {
+		session.Stdin = strings.NewReader(stdin)
 	}
 
-	return string(out), nil
+	if err = runSession(ctx, session, cmd); err != nil {
+		return "", err
+	}
+	return out.String(), nil
 }
 
 func ConversationalCommentStream(cmdStr, input string) (string, error) {
-	session, err := GlobalClient.NewSession()
+	return ConversationalCommentStreamOn(GlobalClient, cmdStr, input)
+}
+
+func ConversationalCommentStreamOn(client *ssh.Client, cmdStr, input string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	return ConversationalCommentStreamContextOn(ctx, client, cmdStr, input)
+}
+
+func ConversationalCommentStreamContext(ctx context.Context, cmdStr, input string) (string, error) {
+	return ConversationalCommentStreamContextOn(ctx, GlobalClient, cmdStr, input)
+}
+
+func ConversationalCommentStreamContextOn(ctx context.Context, client *ssh.Client, cmdStr, input string) (string, error) {
+	if out, handled, err := viaAgent(ctx, client, cmdStr, input, nil); handled {
+		if err != nil {
+			return "", err
+		}
+		return out, nil
+	}
+
+	session, err := client.NewSession()
 	if err != nil {
 		return "", err
 	}
@@ -33,13 +92,121 @@ func ConversationalCommentStream(cmdStr, input string) (string, error) {
 	session.Stdout = &stdout
 	session.Stderr = &stderr
 
-	if input != "" {
-		session.Stdin = strings.NewReader(input)
+	cmdStr, stdin := elevate(client, cmdStr)
+	if stdin+input != "" {
+		session.Stdin = strings.NewReader(stdin + input)
 	}
 
-	err = session.Run(cmdStr)
+	err = runSession(ctx, session, cmdStr)
+	if ctx.Err() != nil {
+		return "", err
+	}
 	if err != nil {
 		return "", errors.New(fmt.Sprint("stderr:", stderr.String()))
 	}
 	return stdout.String(), nil
 }
+
+// runSession runs cmd on the session and kills it if ctx ends first. A stalled channel can't block
+// the caller past the deadline because the session is closed from under Wait.
+func runSession(ctx context.Context, session *ssh.Session, cmd string) error {
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("remote command timed out: %w", ctx.Err())
+		}
+		return fmt.Errorf("remote command cancelled: %w", ctx.Err())
+	}
+}
+
+// lockedBuffer lets stdout and stderr share one buffer like CombinedOutput does
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// CommandLiveStream runs cmd on the global client and hands every stdout/stderr line to emit as it arrives,
+// for operations where waiting on CombinedOutput would leave the user staring at nothing
+func CommandLiveStream(ctx context.Context, cmd, input string, emit func(line string, stderr bool)) (string, error) {
+	return CommandLiveStreamOn(ctx, GlobalClient, cmd, input, emit)
+}
+
+func CommandLiveStreamOn(ctx context.Context, client *ssh.Client, cmd, input string, emit func(line string, stderr bool)) (string, error) {
+	if out, handled, err := viaAgent(ctx, client, cmd, input, emit); handled {
+		return out, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return "", err
+	}
+
+	cmd, stdin := elevate(client, cmd)
+	if stdin+input != "" {
+		session.Stdin = strings.NewReader(stdin + input)
+	}
+
+	var out, errOut lockedBuffer
+	var wg sync.WaitGroup
+	scan := func(r io.Reader, isStderr bool) {
+		defer wg.Done()
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for s.Scan() {
+			line := s.Text()
+			_, _ = out.Write([]byte(line + "\n"))
+			if isStderr {
+				_, _ = errOut.Write([]byte(line + "\n"))
+			}
+			if emit != nil {
+				emit(line, isStderr)
+			}
+		}
+	}
+	wg.Add(2)
+	go scan(stdout, false)
+	go scan(stderr, true)
+
+	err = runSession(ctx, session, cmd)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return out.String(), err
+	}
+	if err != nil {
+		return out.String(), errors.New(fmt.Sprint("stderr:", errOut.String()))
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,146 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+	"golang.org/x/crypto/ssh"
+)
+
+type ElevationMode string
+
+const (
+	// ElevateAuto uses sudo -n when the login user isn't root
+	ElevateAuto ElevationMode = "auto"
+	// ElevateOff runs everything as the login user
+	ElevateOff ElevationMode = "off"
+	// ElevateNoPasswd always uses sudo -n
+	ElevateNoPasswd ElevationMode = "nopasswd"
+	// ElevatePassword feeds a sudo password over the session's stdin
+	ElevatePassword ElevationMode = "password"
+)
+
+type elevation struct {
+	sudo     bool
+	password string
+}
+
+var elevations = make(map[*ssh.Client]*elevation)
+var elevationMutex sync.Mutex
+
+func ParseElevationMode(s string) (ElevationMode, error) {
+	switch m := ElevationMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case ElevateAuto, ElevateOff, ElevateNoPasswd, ElevatePassword:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown sudo mode %q (expected auto, off, nopasswd or password)", s)
+}
+
+// ConfigureElevation decides how ufw commands on client get root, and checks up front that the login user is
+// actually allowed to run ufw through sudo. getPassword is only used in password mode.
+func ConfigureElevation(client *ssh.Client, mode ElevationMode, getPassword func() (string, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	uid, err := rawOutput(ctx, client, "id -u", "")
+	if err != nil {
+		return fmt.Errorf("unable to determine remote user: %w", err)
+	}
+	isRoot := strings.TrimSpace(uid) == "0"
+
+	el := &elevation{}
+	switch mode {
+	case ElevateOff:
+	case ElevateAuto:
+		el.sudo = !isRoot
+	case ElevateNoPasswd:
+		el.sudo = true
+	case ElevatePassword:
+		el.sudo = true
+		if el.password, err = getPassword(); err != nil {
+			return fmt.Errorf("unable to read sudo password: %w", err)
+		}
+	}
+
+	if el.sudo {
+		check := "sudo -n -l ufw"
+		input := ""
+		if el.password != "" {
+			check = "sudo -S -p '' -l ufw"
+			input = el.password + "\n"
+		}
+		if out, err := rawOutput(ctx, client, check, input); err != nil {
+			reason := strings.TrimSpace(out)
+			if reason == "" {
+				reason = err.Error()
+			}
+			if mode == ElevatePassword {
+				return fmt.Errorf("user %q cannot run ufw through sudo on this host: %s", client.User(), reason)
+			}
+			return fmt.Errorf("user %q cannot run ufw through passwordless sudo on this host (%s); grant NOPASSWD for ufw or use -sudo password", client.User(), reason)
+		}
+	}
+
+	elevationMutex.Lock()
+	elevations[client] = el
+	elevationMutex.Unlock()
+	return nil
+}
+
+func PromptSudoPassword() (string, error) {
+	fmt.Print("sudo password: ")
+	pwd, err := term.ReadPassword(uintptr(int(syscall.Stdin)))
+	if err != nil {
+		return "", err
+	}
+	fmt.Println()
+	return string(pwd), nil
+}
+
+// ForgetElevation drops the stored settings for a client that has been closed
+func ForgetElevation(client *ssh.Client) {
+	elevationMutex.Lock()
+	delete(elevations, client)
+	elevationMutex.Unlock()
+}
+
+// elevate runs the ufw invocation at the start of cmd through sudo, returning the command to run and
+// anything that has to be written to stdin ahead of the caller's own input. The shell applies sudo to
+// that first command only, so filters piped after it (grep, sed) run as the login user and a sudoer
+// entry for ufw alone is enough. Other commands run as the login user.
+func elevate(client *ssh.Client, cmd string) (string, string) {
+	elevationMutex.Lock()
+	el := elevations[client]
+	elevationMutex.Unlock()
+
+	trimmed := strings.TrimSpace(cmd)
+	if el == nil || !el.sudo || (trimmed != "ufw" && !strings.HasPrefix(trimmed, "ufw ")) {
+		return cmd, ""
+	}
+	if el.password != "" {
+		return "sudo -S -p '' -- " + trimmed, el.password + "\n"
+	}
+	return "sudo -n -- " + trimmed, ""
+}
+
+func rawOutput(ctx context.Context, client *ssh.Client, cmd, input string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var out lockedBuffer
+	session.Stdout = &out
+	session.Stderr = &out
+	if input != "" {
+		session.Stdin = strings.NewReader(input)
+	}
+	err = runSession(ctx, session, cmd)
+	return out.String(), err
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"TUFWGo/system/userdir"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type TrustPolicy string
+
+const (
+	// TrustStrict only connects to hosts already in known_hosts
+	TrustStrict TrustPolicy = "strict"
+	// TrustPrompt asks on the terminal before adding an unknown host
+	TrustPrompt TrustPolicy = "prompt"
+	// TrustTOFU adds unknown hosts on first use without asking, reporting each one through OnHostTrusted
+	TrustTOFU TrustPolicy = "tofu"
+	// TrustPinned is only reported through OnHostTrusted, for keys pinned by hand
+	TrustPinned TrustPolicy = "pin"
+)
+
+var trustPolicy = TrustPrompt
+
+// OnHostTrusted is called whenever a new host key is written to known_hosts, so callers can audit it
+var OnHostTrusted func(host, fingerprint string, policy TrustPolicy)
+
+func ParseTrustPolicy(s string) (TrustPolicy, error) {
+	switch p := TrustPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case TrustStrict, TrustPrompt, TrustTOFU:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown host trust policy %q (expected strict, prompt or tofu)", s)
+}
+
+func GetTrustPolicy() TrustPolicy {
+	return trustPolicy
+}
+
+func SetTrustPolicy(p TrustPolicy) {
+	trustPolicy = p
+}
+
+func NextTrustPolicy(p TrustPolicy) TrustPolicy {
+	switch p {
+	case TrustStrict:
+		return TrustPrompt
+	case TrustPrompt:
+		return TrustTOFU
+	}
+	return TrustStrict
+}
+
+func trustPolicyPath() string {
+	return filepath.Join(userdir.GlobalUserCfgDir, "tufwgo", "vars", "host-trust")
+}
+
+// SavedTrustPolicy is the policy chosen in the known hosts manager, used when -host-trust isn't given.
+// It is TrustPrompt until one has been saved.
+func SavedTrustPolicy() (TrustPolicy, error) {
+	data, err := os.ReadFile(trustPolicyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return TrustPrompt, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return ParseTrustPolicy(string(data))
+}
+
+// SaveTrustPolicy makes p the policy for later connections; it doesn't change this session's
+func SaveTrustPolicy(p TrustPolicy) error {
+	if _, err := ParseTrustPolicy(string(p)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(trustPolicyPath()), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(trustPolicyPath(), []byte(string(p)+"\n"), 0600)
+}
+
+func hostTrusted(host, fingerprint string) {
+	if OnHostTrusted != nil {
+		OnHostTrusted(host, fingerprint, trustPolicy)
+	}
+}
@@ -13,7 +13,7 @@ import (
 func findKnownHostsPath() string {
 	homePath, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Println(os.Stderr, "cannot resolve home:", err)
+		fmt.Fprintln(os.Stderr, "cannot resolve home:", err)
 		os.Exit(1)
 	}
 	return filepath.Join(homePath, ".ssh", "known_hosts")
@@ -58,3 +58,7 @@ func fingerprintSHA256(pub ssh.PublicKey) string {
 	sum := sha256.Sum256(pub.Marshal())
 	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
 }
+
+func FingerprintSHA256(pub ssh.PublicKey) string {
+	return fingerprintSHA256(pub)
+}
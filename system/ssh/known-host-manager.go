@@ -0,0 +1,244 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type KnownHost struct {
+	Line        int
+	Marker      string
+	Hosts       []string
+	KeyType     string
+	Fingerprint string
+	Comment     string
+}
+
+var errKeyCaptured = errors.New("host key captured")
+
+// SplitHostPort accepts "host", "host:port" or "[host]:port"; the port defaults to 22
+func SplitHostPort(s string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return strings.Trim(s, "[]"), 22, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port: %s", portStr)
+	}
+	return host, port, nil
+}
+
+func ListKnownHosts() ([]KnownHost, error) {
+	path := findKnownHostsPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []KnownHost
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		marker, hosts, key, comment, _, err := ssh.ParseKnownHosts(line)
+		if err != nil {
+			// Blank lines, comments and entries we can't parse are left alone
+			continue
+		}
+		out = append(out, KnownHost{
+			Line:        i + 1,
+			Marker:      marker,
+			Hosts:       hosts,
+			KeyType:     key.Type(),
+			Fingerprint: fingerprintSHA256(key),
+			Comment:     comment,
+		})
+	}
+	return out, nil
+}
+
+func FindKnownHosts(host string, port int) ([]KnownHost, error) {
+	all, err := ListKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+	pattern := hostPattern(host, port)
+	var out []KnownHost
+	for _, kh := range all {
+		for _, h := range kh.Hosts {
+			if hostEntryMatches(h, pattern) {
+				out = append(out, kh)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// RemoveKnownHost drops every known_hosts entry for host:port and returns how many were touched.
+// Lines that also list other hosts keep those hosts.
+func RemoveKnownHost(host string, port int) (int, error) {
+	path := findKnownHostsPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pattern := hostPattern(host, port)
+
+	removed := 0
+	var kept []string
+	for _, line := range strings.Split(string(data), "\n") {
+		_, hosts, _, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			kept = append(kept, line)
+			continue
+		}
+
+		var remaining []string
+		for _, h := range hosts {
+			if !hostEntryMatches(h, pattern) {
+				remaining = append(remaining, h)
+			}
+		}
+		if len(remaining) == len(hosts) {
+			kept = append(kept, line)
+			continue
+		}
+		removed++
+		if len(remaining) > 0 {
+			kept = append(kept, replaceHostsField(line, remaining))
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, writeKnownHosts(path, strings.Join(kept, "\n"))
+}
+
+// FetchHostKey connects just far enough to see the key a host presents, without authenticating
+func FetchHostKey(host string, port int) (ssh.PublicKey, error) {
+	var captured ssh.PublicKey
+	cfg := &ssh.ClientConfig{
+		User: "tufwgo-keyscan",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			captured = key
+			return errKeyCaptured
+		},
+		Timeout: time.Second * 12,
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, time.Second*7)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, _, _, err = ssh.NewClientConn(conn, addr, cfg)
+	if captured != nil {
+		return captured, nil
+	}
+	if err == nil {
+		err = errors.New("host did not present a key")
+	}
+	return nil, err
+}
+
+// PinKnownHost replaces any stored keys for host:port with the key the host presents now,
+// but only if it matches the fingerprint the operator verified out-of-band
+func PinKnownHost(host string, port int, fingerprint string) error {
+	key, err := FetchHostKey(host, port)
+	if err != nil {
+		return fmt.Errorf("unable to fetch host key: %w", err)
+	}
+	presented := fingerprintSHA256(key)
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = "SHA256:" + fingerprint
+	}
+	if presented != fingerprint {
+		return fmt.Errorf("presented fingerprint %s does not match %s", presented, fingerprint)
+	}
+
+	path := findKnownHostsPath()
+	if err = ensureKnownHostsExists(path); err != nil {
+		return err
+	}
+	if _, err = RemoveKnownHost(host, port); err != nil {
+		return fmt.Errorf("unable to remove old entries: %w", err)
+	}
+	if err = appendKnownHostLine(path, hostPattern(host, port), key); err != nil {
+		return err
+	}
+	if OnHostTrusted != nil {
+		OnHostTrusted(hostPattern(host, port), presented, TrustPinned)
+	}
+	return nil
+}
+
+func hostEntryMatches(entry, pattern string) bool {
+	if entry == pattern {
+		return true
+	}
+	// Hashed entries look like |1|base64(salt)|base64(hmac-sha1(salt, host))
+	if !strings.HasPrefix(entry, "|1|") {
+		return false
+	}
+	parts := strings.Split(entry[3:], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(pattern))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func replaceHostsField(line string, hosts []string) string {
+	fields := strings.Fields(line)
+	idx := 0
+	if strings.HasPrefix(fields[0], "@") {
+		idx = 1
+	}
+	fields[idx] = strings.Join(hosts, ",")
+	return strings.Join(fields, " ")
+}
+
+func writeKnownHosts(path, content string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,24 +20,14 @@ import (
 var GlobalClient *ssh.Client
 var GlobalHost string
 
+// GlobalTarget is the current connection as user@host[:port], the form fan-out targets are written in
+var GlobalTarget string
+
+// trustPromptMutex keeps host trust prompts from interleaving when several connections are dialled at once
+var trustPromptMutex sync.Mutex
+
 func Connect(host, user string, port int) (*ssh.Client, error) {
-	khPath := findKnownHostsPath()
-	client, err := connectWithKnownHosts(
-		context.Background(),
-		host, port, user,
-		khPath,
-		time.Second*7,  //TCP connect timeout
-		time.Second*12, //SSH handshake timeout
-		func() (string, error) {
-			fmt.Print("SSH password: ")
-			pwd, err := term.ReadPassword(uintptr(int(syscall.Stdin)))
-			if err != nil {
-				return "", err
-			}
-			fmt.Println()
-			return string(pwd), nil
-		},
-	)
+	client, err := Dial(host, user, port, PromptPassword)
 	if err != nil {
 		return nil, errors.New(fmt.Sprint("Connection error: ", err))
 	}
@@ -46,9 +37,36 @@ func Connect(host, user string, port int) (*ssh.Client, error) {
 	fmt.Println("SSH connection succeeded")
 	GlobalClient = client
 	GlobalHost = host
+	GlobalTarget = fmt.Sprintf("%s@%s", user, host)
+	if port != 22 {
+		GlobalTarget = fmt.Sprintf("%s@%s:%d", user, host, port)
+	}
 	return client, nil
 }
 
+// Dial opens an SSH connection without touching GlobalClient/GlobalHost, so callers can hold several at once
+func Dial(host, user string, port int, getPassword func() (string, error)) (*ssh.Client, error) {
+	khPath := findKnownHostsPath()
+	return connectWithKnownHosts(
+		context.Background(),
+		host, port, user,
+		khPath,
+		time.Second*7,  //TCP connect timeout
+		time.Second*12, //SSH handshake timeout
+		getPassword,
+	)
+}
+
+func PromptPassword() (string, error) {
+	fmt.Print("SSH password: ")
+	pwd, err := term.ReadPassword(uintptr(int(syscall.Stdin)))
+	if err != nil {
+		return "", err
+	}
+	fmt.Println()
+	return string(pwd), nil
+}
+
 func connectWithKnownHosts(
 	ctx context.Context,
 	host string,
@@ -93,17 +111,31 @@ func connectWithKnownHosts(
 				return nil, fmt.Errorf("unknown host, but no presented key captured: %w", err)
 			}
 			fp := fingerprintSHA256(presented)
-			fmt.Printf("The authenticity of host '%s' can't be established.\nFingerprint (SHA256): %s\nTrust and add to known_hosts? [y/N]: ", host, fp)
-			consent, _ := bufio.NewReader(os.Stdin).ReadString('\n')
-			if strings.ToLower(strings.TrimSpace(consent)) != "y" {
-				return nil, fmt.Errorf("user declined to trust unknown host %s", addr)
+			switch trustPolicy {
+			case TrustStrict:
+				return nil, fmt.Errorf("unknown host %s (fingerprint %s) rejected by strict host trust policy; verify the fingerprint and run \"tufwgo known-hosts pin %s %s\"", addr, fp, hostPattern(host, port), fp)
+			case TrustPrompt:
+				trustPromptMutex.Lock()
+				fmt.Printf("The authenticity of host '%s' can't be established.\nFingerprint (SHA256): %s\nTrust and add to known_hosts? [y/N]: ", host, fp)
+				consent, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+				trustPromptMutex.Unlock()
+				if strings.ToLower(strings.TrimSpace(consent)) != "y" {
+					return nil, fmt.Errorf("user declined to trust unknown host %s", addr)
+				}
+			case TrustTOFU:
+				fmt.Printf("Trusting new host '%s' on first use (SHA256 fingerprint: %s)\n", host, fp)
 			}
 
 			err = appendKnownHostLine(knownHostsPath, hostPattern(host, port), presented)
 			if err != nil {
 				return nil, fmt.Errorf("appending known_hosts entry: %w", err)
 			}
+			hostTrusted(hostPattern(host, port), fp)
 
+			// The callback only knows the keys that were on disk when it was built
+			if rec.inner, err = knownhosts.New(knownHostsPath); err != nil {
+				return nil, fmt.Errorf("cannot reload knownhosts callback: %w", err)
+			}
 			return dialSSH(ctx, addr, cfg, connectTimeout)
 		}
 		expectedFPs := []string{}
@@ -115,7 +147,7 @@ func connectWithKnownHosts(
 		if presented != nil {
 			presentedFP = fingerprintSHA256(presented)
 		}
-		return nil, fmt.Errorf("host key mismatch for %s (possible MITM attack)!\n expected: %s\n presented: %s", addr, strings.Join(expectedFPs, ","), presentedFP)
+		return nil, fmt.Errorf("host key mismatch for %s (possible MITM attack)!\n expected: %s\n presented: %s\nIf the key change is expected, verify the presented fingerprint out-of-band and run \"tufwgo known-hosts pin %s %s\"", addr, strings.Join(expectedFPs, ","), presentedFP, hostPattern(host, port), presentedFP)
 
 	}
 	return nil, err
@@ -1,4 +1,6 @@
-package local
+// Package userdir knows the invoking user's home and config directories. It has no dependencies
+// of its own, so the audit and approval packages can use it inside tufwgo-auth too.
+package userdir
 
 import (
 	"errors"
@@ -453,7 +453,7 @@ func (m *profilesFlow) SetAuditorForAP(auditor *audit.Log, actor string) {
 	m.actor = actor
 }
 
-func (m *profilesFlow) auditAddAP(action, result, errMsg string, profCmds []string, extra []audit.Field) {
+func (m *profilesFlow) auditAddAP(action audit.Action, result, errMsg string, profCmds []string, extra []audit.Field) {
 	if m.auditor == nil {
 		return
 	}
@@ -514,18 +514,18 @@ func (m *profilesFlow) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			data, err := json.MarshalIndent(rs, "", "  ")
 			if err != nil {
-				m.auditAddAP("profile.add", "error", err.Error(), m.commands, nil)
+				m.auditAddAP(audit.ActionProfileAdd, "error", err.Error(), m.commands, nil)
 				m.child = newErrorBoxModel("Failed to serialize ruleset:", err.Error(), m)
 				return m, nil
 			}
 			err = os.WriteFile(profilePath, data, 0o644)
 			if err != nil {
-				m.auditAddAP("profile.add", "error", err.Error(), m.commands, nil)
+				m.auditAddAP(audit.ActionProfileAdd, "error", err.Error(), m.commands, nil)
 				m.child = newErrorBoxModel("Failed to write ruleset to file:", err.Error(), m)
 				return m, nil
 			}
 
-			m.auditAddAP("profile.add", "success", "", m.commands, nil)
+			m.auditAddAP(audit.ActionProfileAdd, "success", "", m.commands, nil)
 			m.child = newSuccessBoxModel(fmt.Sprintf("Successfully wrote ruleset to profile: %s", strings.Trim(profile, ".json")), fmt.Sprintf("Profile is located at: %s", profilePath), returnMsg(ProfileDone{}))
 			return m, nil
 		}
@@ -0,0 +1,37 @@
+package tui
+
+import (
+	"TUFWGo/approval"
+	"TUFWGo/audit"
+	"TUFWGo/system/ssh"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// approvalProposer queues a change for a second controller; set when TUFWGo runs with -require-approval
+var approvalProposer func(action string, cmds []string, rule string) (*approval.Request, error)
+
+func SetApprovalProposer(fn func(action string, cmds []string, rule string) (*approval.Request, error)) {
+	approvalProposer = fn
+}
+
+// needsApproval reports whether cmds have to be co-signed before they run on the SSH host
+func needsApproval(cmds []string) bool {
+	return approvalProposer != nil && ssh.GetSSHStatus() && approval.NeedsApproval(cmds)
+}
+
+// queueForApproval signs and queues the change instead of running it, and tells the user how to finish it
+func (m *TabModel) queueForApproval(action audit.Action, cmds []string, rule string) (tea.Model, tea.Cmd) {
+	r, err := approvalProposer(string(action), cmds, rule)
+	if err != nil {
+		m.auditAdd(action, "error", strings.Join(cmds, "\n"), err.Error(), nil, nil)
+		m.child = newErrorBoxModel("Unable to queue the change for approval!", err.Error(), m.child)
+		return m, nil
+	}
+	msg := fmt.Sprintf("%s\n\nA second engineer must approve it:\n  tufwgo approvals approve %s\nThen apply it with:\n  tufwgo approvals apply %s",
+		strings.Join(cmds, "\n"), r.ID, r.ID)
+	m.child = newSuccessBoxModel("Change queued for approval as "+r.ID+":", msg, m.child)
+	return m, nil
+}
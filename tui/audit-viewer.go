@@ -0,0 +1,320 @@
+package tui
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/ufw"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Number of entries shown at once in the list
+const auditViewerRows = 15
+
+type auditViewerModel struct {
+	all     []audit.FoundEntry // newest first
+	shown   []audit.FoundEntry
+	idx     int
+	top     int
+	detail  bool
+	editing bool
+	filter  textinput.Model
+	chainOK bool
+	chain   string
+	status  string
+	err     string
+}
+
+func NewAuditViewerModel() *auditViewerModel {
+	ti := textinput.New()
+	ti.Placeholder = "actor, action, result or command"
+	ti.Prompt = "/ "
+	ti.CharLimit = 128
+	m := &auditViewerModel{filter: ti}
+	m.reload()
+	return m
+}
+
+// reload reads every daily log and re-checks the hash chain across them
+func (m *auditViewerModel) reload() {
+	dir := audit.AuditDir()
+	found, err := audit.Search(dir, audit.Filter{})
+	if err != nil {
+		m.err = fmt.Sprintf("Failed to read audit logs: %v", err)
+		return
+	}
+	slices.Reverse(found)
+	m.all = found
+	m.err = ""
+	m.checkChain(dir)
+	m.applyFilter()
+}
+
+func (m *auditViewerModel) checkChain(dir string) {
+	keys, err := audit.LoadKeys()
+	if err != nil {
+		m.chainOK, m.chain = false, "unable to load audit keys: "+err.Error()
+		return
+	}
+	links, ok, err := audit.VerifyChain(dir, keys.Verifier())
+	if err != nil {
+		m.chainOK, m.chain = false, err.Error()
+		return
+	}
+	m.chainOK = ok
+	if p, broken := audit.FirstBroken(links); broken {
+		m.chain = "BROKEN at " + p.String()
+		return
+	}
+	sealed := 0
+	for _, l := range links {
+		sealed += len(l.Problems)
+	}
+	m.chain = fmt.Sprintf("verified (%d log(s))", len(links))
+	if sealed > 0 {
+		m.chain = fmt.Sprintf("verified (%d log(s), %d sealed break(s))", len(links), sealed)
+	}
+}
+
+func (m *auditViewerModel) applyFilter() {
+	q := strings.ToLower(strings.TrimSpace(m.filter.Value()))
+	m.shown = m.shown[:0]
+	for _, e := range m.all {
+		if q == "" || auditEntryMatches(e, q) {
+			m.shown = append(m.shown, e)
+		}
+	}
+	m.idx, m.top = 0, 0
+}
+
+func auditEntryMatches(e audit.FoundEntry, q string) bool {
+	for _, s := range append([]string{e.Actor, string(e.Action), e.Result, e.Command, e.Time}, e.ProfCommand...) {
+		if strings.Contains(strings.ToLower(s), q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *auditViewerModel) current() (audit.FoundEntry, bool) {
+	if m.idx < 0 || m.idx >= len(m.shown) {
+		return audit.FoundEntry{}, false
+	}
+	return m.shown[m.idx], true
+}
+
+func (m *auditViewerModel) move(by int) {
+	m.idx = min(max(m.idx+by, 0), maximum(len(m.shown)-1, 0))
+	if m.idx < m.top {
+		m.top = m.idx
+	}
+	if m.idx >= m.top+auditViewerRows {
+		m.top = m.idx - auditViewerRows + 1
+	}
+}
+
+func (m *auditViewerModel) Init() tea.Cmd { return nil }
+
+func (m *auditViewerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	v, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+	if m.editing {
+		if v.String() == "enter" {
+			m.editing = false
+			m.filter.Blur()
+			m.applyFilter()
+			m.status = fmt.Sprintf("%d of %d entries match.", len(m.shown), len(m.all))
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.filter, cmd = m.filter.Update(msg)
+		return m, cmd
+	}
+	if m.detail {
+		switch v.String() {
+		case "enter", "backspace", "q":
+			m.detail = false
+		case "up":
+			m.move(-1)
+		case "down":
+			m.move(1)
+		}
+		return m, nil
+	}
+
+	switch v.String() {
+	case "up":
+		m.move(-1)
+	case "down":
+		m.move(1)
+	case "pgup":
+		m.move(-auditViewerRows)
+	case "pgdown":
+		m.move(auditViewerRows)
+	case "home":
+		m.move(-len(m.shown))
+	case "end":
+		m.move(len(m.shown))
+	case "enter":
+		if _, ok := m.current(); ok {
+			m.detail = true
+		}
+	case "/":
+		m.editing = true
+		m.status = ""
+		return m, m.filter.Focus()
+	case "c":
+		m.filter.SetValue("")
+		m.applyFilter()
+		m.status = "Filter cleared."
+	case "r":
+		m.reload()
+		m.status = "Reloaded audit logs."
+	}
+	return m, nil
+}
+
+func (m *auditViewerModel) View() string {
+	var b strings.Builder
+	chain := lipgloss.NewStyle().Foreground(lipgloss.Color("#35fc03")).Render("Chain: " + m.chain)
+	if !m.chainOK {
+		chain = lipgloss.NewStyle().Foreground(errorColor).Bold(true).Render("Chain: " + m.chain)
+	}
+	b.WriteString(focusStyle.Render("Audit Log") + "  " + hintStyle.Render(audit.AuditDir()) + "\n")
+	b.WriteString(chain + "\n")
+	b.WriteString(sepStyle.Render(strings.Repeat("─", 100)) + "\n\n")
+
+	if m.detail {
+		m.viewDetail(&b)
+	} else {
+		m.viewList(&b)
+	}
+
+	if m.editing {
+		b.WriteString("\n" + m.filter.View() + "\n")
+	} else if q := m.filter.Value(); q != "" {
+		b.WriteString("\n" + hintStyle.Render("Filter: "+q) + "\n")
+	}
+	if m.status != "" {
+		b.WriteString("\n" + lipgloss.NewStyle().Bold(true).Render(m.status) + "\n")
+	}
+	if m.err != "" {
+		b.WriteString("\n" + lipgloss.NewStyle().Foreground(errorColor).Render(m.err) + "\n")
+	}
+	switch {
+	case m.editing:
+		b.WriteString("\n" + hintStyle.Render("Type to filter • Enter: apply") + "\n")
+	case m.detail:
+		b.WriteString("\n" + hintStyle.Render("↑/↓ previous/next entry • Enter: back to list • Esc: back") + "\n")
+	default:
+		b.WriteString("\n" + hintStyle.Render("↑/↓ PgUp/PgDn move • Enter: details • /: filter • c: clear filter • r: reload • Esc: back") + "\n")
+	}
+	return b.String()
+}
+
+func (m *auditViewerModel) viewList(b *strings.Builder) {
+	if len(m.shown) == 0 {
+		if len(m.all) == 0 {
+			b.WriteString("No audit entries yet.\n")
+		} else {
+			b.WriteString("No entries match the filter.\n")
+		}
+		return
+	}
+	b.WriteString(hintStyle.Render("  "+padRight("TIME", 21)+padRight("ACTOR", 28)+padRight("ACTION", 22)+padRight("RESULT", 10)+"DETAIL") + "\n")
+	end := min(m.top+auditViewerRows, len(m.shown))
+	for i := m.top; i < end; i++ {
+		e := m.shown[i]
+		detail := e.Command
+		if detail == "" && len(e.ProfCommand) > 0 {
+			detail = fmt.Sprintf("%s (+%d more)", e.ProfCommand[0], len(e.ProfCommand)-1)
+		}
+		line := padRight(e.Time, 21) + padRight(truncate(e.Actor, 26), 28) + padRight(string(e.Action), 22) + padRight(e.Result, 10) + truncate(detail, 40)
+		if i == m.idx {
+			b.WriteString(focusStyle.Render("> "+line) + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	b.WriteString("\n" + hintStyle.Render(fmt.Sprintf("%d-%d of %d", m.top+1, end, len(m.shown))) + "\n")
+}
+
+func (m *auditViewerModel) viewDetail(b *strings.Builder) {
+	e, ok := m.current()
+	if !ok {
+		return
+	}
+	label := func(s string) string { return focusStyle.Render(padRight(s, 10)) }
+	b.WriteString(label("Time") + e.Time + "\n")
+	b.WriteString(label("Index") + fmt.Sprintf("%d (%s)", e.Index, e.File) + "\n")
+	b.WriteString(label("Actor") + e.Actor + "\n")
+	b.WriteString(label("Action") + string(e.Action) + "\n")
+	b.WriteString(label("Result") + e.Result + "\n")
+	if e.Command != "" {
+		b.WriteString(label("Command") + e.Command + "\n")
+	}
+	if len(e.ProfCommand) > 0 {
+		b.WriteString(label("Commands") + "\n")
+		for _, c := range e.ProfCommand {
+			b.WriteString("  " + c + "\n")
+		}
+	}
+	if e.Error != "" {
+		b.WriteString(label("Error") + lipgloss.NewStyle().Foreground(errorColor).Render(e.Error) + "\n")
+	}
+	switch p, err := e.DecodePayload(); p := p.(type) {
+	case audit.RuleAdd:
+		b.WriteString(label("Rule") + describeAuditRule(p.Rule) + "\n")
+	case audit.RuleDelete:
+		b.WriteString(label("Rule") + p.Rule + "\n")
+	default:
+		// A payload this version has no type for is shown as written
+		if err != nil {
+			b.WriteString(label("Payload") + string(e.Payload) + "\n")
+		}
+	}
+	if len(e.Fields) == 0 {
+		return
+	}
+	b.WriteString("\n" + focusStyle.Render("Fields") + "\n")
+	for _, f := range e.Fields {
+		if f.Name != "" || f.Value != "" {
+			// Multi-line values such as ruleset_diff are indented under their name
+			lines := strings.Split(f.Value, "\n")
+			b.WriteString("  " + padRight(f.Name, 20) + lines[0] + "\n")
+			for _, l := range lines[1:] {
+				b.WriteString("  " + padRight("", 20) + l + "\n")
+			}
+		}
+		if f.Ruleset != nil {
+			b.WriteString("  " + padRight("", 20) + hintStyle.Render(fmt.Sprintf("%d rule(s): %s", len(f.Ruleset.Rules), strings.Join(f.Ruleset.Settings, "; "))) + "\n")
+		}
+	}
+}
+
+// describeAuditRule lists the set parts of a logged rule form
+func describeAuditRule(r ufw.Form) string {
+	var parts []string
+	for _, p := range [][2]string{
+		{"action", r.Action}, {"direction", r.Direction}, {"interface", r.Interface}, {"from", r.FromIP},
+		{"to", r.ToIP}, {"port", r.Port}, {"proto", r.Protocol}, {"app", r.AppProfile},
+	} {
+		if p[1] != "" {
+			parts = append(parts, p[0]+"="+p[1])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
@@ -71,7 +71,7 @@ func (m *iacFlow) SetAuditorForIAC(auditor *audit.Log, actor string) {
 	m.actor = actor
 }
 
-func (m *iacFlow) auditAddIAC(action, result, cmd, errMsg string, extra []audit.Field) {
+func (m *iacFlow) auditAddIAC(action audit.Action, result, cmd, errMsg string, extra []audit.Field) {
 	if m.auditor == nil {
 		return
 	}
@@ -110,7 +110,7 @@ func (m *iacFlow) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case IACPreflightFailed:
-			m.auditAddIAC("profile.deploy", "error", "Unauthorised attempt to use PDC", v.Err.Error(), nil)
+			m.auditAddIAC(audit.ActionProfileDeploy, "error", "Unauthorised attempt to use PDC", v.Err.Error(), nil)
 			m.child = newErrorBoxModel("Preflight Check Failed", v.Err.Error(), m.child)
 			return m, nil
 
@@ -139,12 +139,12 @@ func (m *iacFlow) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case IACRunDone:
 			if v.Err != nil {
-				m.auditAddIAC("profile.deploy", "error", v.Command, v.Err.Error(), nil)
+				m.auditAddIAC(audit.ActionProfileDeploy, "error", v.Command, v.Err.Error(), nil)
 				m.child = newErrorBoxModel("Ansible Failed", v.Err.Error()+"\n\n"+v.Out, m.child)
 				return m, nil
 			}
 
-			m.auditAddIAC("profile.deploy", "success", v.Command, "", nil)
+			m.auditAddIAC(audit.ActionProfileDeploy, "success", v.Command, "", nil)
 			// Show output then jump back to the Action menu
 			m.child = newSuccessBoxModel("Ansible task completed.", v.Out, returnMsg(IACReturnToAction{}))
 			return m, nil
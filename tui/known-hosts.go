@@ -0,0 +1,273 @@
+package tui
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/system/ssh"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type knownHostInspected struct {
+	Host        string
+	Fingerprint string
+	Err         error
+}
+type knownHostPinned struct {
+	Host        string
+	Fingerprint string
+	Err         error
+}
+type knownHostsReload struct{}
+
+type knownHostsModel struct {
+	entries       []ssh.KnownHost
+	idx           int
+	status        string
+	err           string
+	pendingRemove string
+	pendingPin    knownHostInspected
+	inspected     knownHostInspected
+	auditor       *audit.Log
+	actor         string
+}
+
+func NewKnownHostsModel() *knownHostsModel {
+	m := &knownHostsModel{}
+	m.reload()
+	return m
+}
+
+func (m *knownHostsModel) reload() {
+	entries, err := ssh.ListKnownHosts()
+	if err != nil {
+		m.err = fmt.Sprintf("Failed to read known_hosts: %v", err)
+		return
+	}
+	m.entries = entries
+	m.err = ""
+	if m.idx >= len(m.entries) {
+		m.idx = maximum(len(m.entries)-1, 0)
+	}
+}
+
+func (m *knownHostsModel) current() (ssh.KnownHost, bool) {
+	if m.idx < 0 || m.idx >= len(m.entries) {
+		return ssh.KnownHost{}, false
+	}
+	return m.entries[m.idx], true
+}
+
+func (m *knownHostsModel) SetAuditorForKH(auditor *audit.Log, actor string) {
+	m.auditor = auditor
+	m.actor = actor
+}
+
+func (m *knownHostsModel) auditAddKH(action audit.Action, result, cmd, errMsg string, extra []audit.Field) {
+	if m.auditor == nil {
+		return
+	}
+	entry := &audit.Entry{
+		Actor:   m.actor,
+		Action:  action,
+		Command: cmd,
+		Result:  result,
+		Error:   errMsg,
+		Fields:  extra,
+	}
+	_ = m.auditor.Append(entry)
+}
+
+func (m *knownHostsModel) remove(pattern string) {
+	host, port, err := ssh.SplitHostPort(pattern)
+	if err == nil {
+		_, err = ssh.RemoveKnownHost(host, port)
+	}
+	if err != nil {
+		m.auditAddKH(audit.ActionHostKeyRemove, "error", pattern, err.Error(), nil)
+		m.status = ""
+		m.err = fmt.Sprintf("Failed to remove %s: %v", pattern, err)
+		return
+	}
+	m.auditAddKH(audit.ActionHostKeyRemove, "success", pattern, "", nil)
+	m.reload()
+	m.status = fmt.Sprintf("Removed %s from known_hosts.", pattern)
+}
+
+// pin goes through ssh.PinKnownHost, which fetches the key again and refuses it if it no longer matches
+func pin(pending knownHostInspected) tea.Cmd {
+	return func() tea.Msg {
+		host, port, err := ssh.SplitHostPort(pending.Host)
+		if err == nil {
+			err = ssh.PinKnownHost(host, port, pending.Fingerprint)
+		}
+		return knownHostPinned{Host: pending.Host, Fingerprint: pending.Fingerprint, Err: err}
+	}
+}
+
+func (m *knownHostsModel) Init() tea.Cmd { return nil }
+
+func (m *knownHostsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch v := msg.(type) {
+	case knownHostInspected:
+		if v.Err != nil {
+			m.status = ""
+			m.err = fmt.Sprintf("Could not fetch key from %s: %v", v.Host, v.Err)
+			return m, nil
+		}
+		kh, _ := m.current()
+		m.err = ""
+		m.inspected = v
+		if kh.Fingerprint == v.Fingerprint {
+			m.status = fmt.Sprintf("%s presents %s, which matches the stored key.", v.Host, v.Fingerprint)
+		} else {
+			m.status = fmt.Sprintf("%s presents %s, which does NOT match the stored key %s!", v.Host, v.Fingerprint, kh.Fingerprint)
+		}
+		return m, nil
+	case knownHostPinned:
+		if v.Err != nil {
+			// Successful pins are audited through ssh.OnHostTrusted
+			m.auditAddKH(audit.ActionHostKeyTrust, "error", v.Host, v.Err.Error(), []audit.Field{
+				{Name: "fingerprint", Value: v.Fingerprint},
+				{Name: "policy", Value: string(ssh.TrustPinned)},
+			})
+			m.status = ""
+			m.err = fmt.Sprintf("Failed to pin %s: %v", v.Host, v.Err)
+			return m, nil
+		}
+		m.reload()
+		m.status = fmt.Sprintf("Pinned %s to %s.", v.Host, v.Fingerprint)
+		return m, nil
+	case tea.KeyMsg:
+		if m.pendingRemove != "" {
+			pattern := m.pendingRemove
+			m.pendingRemove = ""
+			if v.String() == "y" {
+				m.remove(pattern)
+			} else {
+				m.status = "Removal cancelled."
+			}
+			return m, nil
+		}
+		if m.pendingPin.Host != "" {
+			pending := m.pendingPin
+			m.pendingPin = knownHostInspected{}
+			if v.String() == "y" {
+				m.status = fmt.Sprintf("Fetching key from %s…", pending.Host)
+				return m, pin(pending)
+			}
+			m.status = "Pin cancelled."
+			return m, nil
+		}
+		switch v.String() {
+		case "up":
+			if m.idx > 0 {
+				m.idx--
+			}
+		case "down":
+			if m.idx < len(m.entries)-1 {
+				m.idx++
+			}
+		case "r":
+			m.reload()
+			m.status = "Reloaded known_hosts."
+		case "t":
+			// This session's connection is already made, so the choice is saved for the next ones
+			saved, err := ssh.SavedTrustPolicy()
+			if err != nil {
+				saved = ssh.TrustPrompt
+			}
+			next := ssh.NextTrustPolicy(saved)
+			if err = ssh.SaveTrustPolicy(next); err != nil {
+				m.err = fmt.Sprintf("Failed to save host trust policy: %v", err)
+				return m, nil
+			}
+			m.err = ""
+			m.status = fmt.Sprintf("Host trust policy for new connections set to: %s (-host-trust still overrides it)", next)
+		case "p":
+			kh, ok := m.current()
+			if !ok {
+				return m, nil
+			}
+			if m.inspected.Host == "" || m.inspected.Host != kh.Hosts[0] {
+				m.err = "Inspect the live key with i first, then verify its fingerprint out-of-band before pinning."
+				return m, nil
+			}
+			m.pendingPin = m.inspected
+			m.err = ""
+			m.status = fmt.Sprintf("Replace every known_hosts entry for %s with the key %s? Only do this after verifying the fingerprint out-of-band. (y/N)", m.pendingPin.Host, m.pendingPin.Fingerprint)
+		case "i":
+			kh, ok := m.current()
+			if !ok || strings.HasPrefix(kh.Hosts[0], "|1|") {
+				m.err = "Hashed entries can't be inspected live; use \"tufwgo known-hosts inspect <host>\"."
+				return m, nil
+			}
+			host := kh.Hosts[0]
+			m.status = fmt.Sprintf("Fetching key from %s…", host)
+			return m, func() tea.Msg {
+				h, port, err := ssh.SplitHostPort(host)
+				if err != nil {
+					return knownHostInspected{Host: host, Err: err}
+				}
+				key, err := ssh.FetchHostKey(h, port)
+				if err != nil {
+					return knownHostInspected{Host: host, Err: err}
+				}
+				return knownHostInspected{Host: host, Fingerprint: ssh.FingerprintSHA256(key)}
+			}
+		case "d":
+			kh, ok := m.current()
+			if !ok {
+				return m, nil
+			}
+			if strings.HasPrefix(kh.Hosts[0], "|1|") {
+				m.err = "Hashed entries can't be removed by name here; use \"tufwgo known-hosts remove <host>\"."
+				return m, nil
+			}
+			m.pendingRemove = kh.Hosts[0]
+			m.err = ""
+			m.status = fmt.Sprintf("Remove every known_hosts entry for %s? It will have to be trusted again on the next connection. (y/N)", m.pendingRemove)
+		}
+	}
+	return m, nil
+}
+
+func (m *knownHostsModel) View() string {
+	var b strings.Builder
+	saved, err := ssh.SavedTrustPolicy()
+	if err != nil {
+		saved = "unreadable"
+	}
+	b.WriteString(focusStyle.Render("Known Hosts") + "  " + hintStyle.Render(fmt.Sprintf("Host trust policy: %s this session, %s for new connections", ssh.GetTrustPolicy(), saved)) + "\n")
+	b.WriteString(sepStyle.Render(strings.Repeat("─", 100)) + "\n\n")
+
+	if len(m.entries) == 0 {
+		b.WriteString("No known hosts yet.\n")
+	}
+	for i, kh := range m.entries {
+		hosts := strings.Join(kh.Hosts, ",")
+		if strings.HasPrefix(hosts, "|1|") {
+			hosts = "(hashed)"
+		}
+		if kh.Marker != "" {
+			hosts = "@" + kh.Marker + " " + hosts
+		}
+		line := padRight(hosts, 36) + padRight(kh.KeyType, 24) + kh.Fingerprint
+		if i == m.idx {
+			b.WriteString(focusStyle.Render("> "+line) + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+
+	if m.status != "" {
+		b.WriteString("\n" + lipgloss.NewStyle().Bold(true).Render(m.status) + "\n")
+	}
+	if m.err != "" {
+		b.WriteString("\n" + lipgloss.NewStyle().Foreground(errorColor).Render(m.err) + "\n")
+	}
+	b.WriteString("\n" + hintStyle.Render("↑/↓ move • i: inspect live key • p: pin inspected key • d: remove • t: cycle trust policy • r: reload • Esc: back") + "\n")
+	return b.String()
+}
@@ -2,6 +2,8 @@ package tui
 
 import (
 	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"context"
 	"encoding/json"
 	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
@@ -110,9 +112,15 @@ func showRulesFromProfile(path string) (string, string, string, []string, error)
 	return rs.Name, rs.CreatedAt, commands, rawCommands, nil
 }
 
-func executeProfile(commands []string) error {
+func executeProfileContext(ctx context.Context, commands []string, emit func(string)) error {
 	for _, cmd := range commands {
-		_, err := local.RunCommand(cmd)
+		var err error
+		if ssh.GetSSHStatus() {
+			emit("$ " + cmd)
+			_, err = ssh.CommandLiveStream(ctx, cmd, "", remoteLines(emit))
+		} else {
+			_, err = local.RunCommandContext(ctx, cmd)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to execute command %q: %w", cmd, err)
 		}
@@ -0,0 +1,29 @@
+package tui
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/system/ssh"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// remoteAllows reports whether the controller role granted for the SSH session permits perm.
+// Local sessions aren't governed by a remote allowlist and are always allowed.
+func remoteAllows(perm auth.Permission) bool {
+	if !ssh.GetSSHStatus() {
+		return true
+	}
+	return auth.GetSessionRole().Allows(perm)
+}
+
+// roleDenied shows why an action was refused and records the refusal
+func (m *TabModel) roleDenied(action audit.Action, cmd string, perm auth.Permission) (tea.Model, tea.Cmd) {
+	role := auth.GetSessionRole()
+	msg := fmt.Sprintf("Your controller role on %s is %q, which does not allow %s.", ssh.GlobalHost, role, perm)
+	m.auditAdd(action, "denied", cmd, msg, nil, []audit.Field{{Name: "role", Value: string(role)}})
+	m.child = newErrorBoxModel("Not permitted", msg, m.child)
+	m.selected = ""
+	return m, nil
+}
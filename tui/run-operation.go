@@ -0,0 +1,202 @@
+package tui
+
+import (
+	"TUFWGo/audit"
+	"TUFWGo/auth"
+	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"TUFWGo/ufw"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type operationDone struct {
+	Action  audit.Action
+	Out     string
+	Err     error
+	Ruleset *ufw.RulesetChange
+	HostLog []audit.Field
+}
+
+// How long the ruleset capture after a change may take, even once the change itself has timed out
+const snapshotTimeout = 15 * time.Second
+
+type operationOutput struct{ Line string }
+
+type runningModel struct {
+	title   string
+	cmd     string
+	timeout time.Duration
+	lines   []string
+}
+
+const runningModelMaxLines = 15
+
+func newRunningModel(title, cmd string, timeout time.Duration) *runningModel {
+	return &runningModel{title: title, cmd: cmd, timeout: timeout}
+}
+
+func (r *runningModel) Init() tea.Cmd                           { return nil }
+func (r *runningModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) { return r, nil }
+
+func (r *runningModel) View() string {
+	title := lipgloss.NewStyle().Bold(true).Render(r.title)
+	body := lipgloss.NewStyle().Faint(true).Render(r.cmd)
+	hint := hintStyle.Render(fmt.Sprintf("Times out after %s • x/Esc: cancel", r.timeout))
+
+	width := 60
+	parts := []string{title, body}
+	if len(r.lines) > 0 {
+		width = 100
+		shown := r.lines
+		if len(shown) > runningModelMaxLines {
+			shown = shown[len(shown)-runningModelMaxLines:]
+		}
+		parts = append(parts, "", strings.Repeat("-", 60), strings.Join(shown, "\n"))
+	}
+	parts = append(parts, "", hint)
+
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(highlightColor).
+		Padding(1, 2).
+		Width(width)
+
+	content := strings.Join(parts, "\n")
+	return lipgloss.Place(
+		0, 0,
+		lipgloss.Center, lipgloss.Center,
+		box.Render(content))
+}
+
+func operationTimeout() time.Duration {
+	if ssh.GetSSHStatus() {
+		return ssh.CommandTimeout
+	}
+	return local.CommandTimeout
+}
+
+// startOperation runs fn off the bubbletea loop with a timeout, showing a cancellable progress box until
+// it reports back with operationDone. Lines passed to emit show up in the box as they arrive.
+func (m *TabModel) startOperation(action audit.Action, title, cmd string, fn func(ctx context.Context, emit func(string)) (string, error)) tea.Cmd {
+	return m.runOperation(action, title, cmd, false, fn)
+}
+
+// startChange is startOperation for commands that change the ruleset. The ruleset is captured before
+// and after fn so the audit entry shows exactly what the change did.
+func (m *TabModel) startChange(action audit.Action, title, cmd string, fn func(ctx context.Context, emit func(string)) (string, error)) tea.Cmd {
+	return m.runOperation(action, title, cmd, true, fn)
+}
+
+func (m *TabModel) runOperation(action audit.Action, title, cmd string, snapshot bool, fn func(ctx context.Context, emit func(string)) (string, error)) tea.Cmd {
+	timeout := operationTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	m.cancelOp = cancel
+	m.opReturn = m.child
+	m.child = newRunningModel(title, cmd, timeout)
+
+	ch := make(chan tea.Msg, 256)
+	m.opOutput = ch
+	rec := auth.HostRecord{Action: string(action), Command: m.cmd}
+	switch action {
+	case audit.ActionProfileExecute:
+		rec.Command, rec.ProfCommand = "", m.profCmds
+	case audit.ActionUFWAdd:
+		rec.Payload = audit.MarshalPayload(audit.RuleAdd{Rule: structPass})
+	case audit.ActionUFWDelete:
+		rec.Payload = audit.MarshalPayload(audit.RuleDelete{Rule: m.rule})
+	}
+	run := func() tea.Msg {
+		defer cancel()
+		defer close(ch)
+		var change *ufw.RulesetChange
+		if snapshot {
+			change = &ufw.RulesetChange{}
+			change.Before, change.BeforeErr = ufw.CaptureRuleset(ctx)
+		}
+		out, err := fn(ctx, func(line string) { ch <- operationOutput{Line: line} })
+		if snapshot {
+			// A failed or cancelled profile may still have applied some of its rules
+			afterCtx, afterCancel := context.WithTimeout(context.Background(), snapshotTimeout)
+			change.After, change.AfterErr = ufw.CaptureRuleset(afterCtx)
+			afterCancel()
+		}
+		var hostLog []audit.Field
+		if snapshot && ssh.GetSSHStatus() {
+			rec.Result = auditResult(err)
+			if err != nil {
+				rec.Error = err.Error()
+			}
+			rec.Fields = append(rec.Fields, audit.HostRecordFields(audit.RulesetFields(change))...)
+			hostCtx, hostCancel := context.WithTimeout(context.Background(), snapshotTimeout)
+			hostLog = audit.RecordOnHost(hostCtx, ssh.GlobalClient, rec)
+			hostCancel()
+		}
+		return operationDone{Action: action, Out: out, Err: err, Ruleset: change, HostLog: hostLog}
+	}
+	return tea.Batch(run, listenOperation(ch))
+}
+
+func listenOperation(ch <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		if msg, ok := <-ch; ok {
+			return msg
+		}
+		return nil
+	}
+}
+
+// remoteLines adapts the TUI's line callback to ssh.CommandLiveStream, marking stderr like the Ansible runner does
+func remoteLines(emit func(string)) func(string, bool) {
+	return func(line string, stderr bool) {
+		if stderr {
+			emit("[ERR] " + line)
+			return
+		}
+		emit(line)
+	}
+}
+
+// auditResult keeps timeouts and cancellations apart from ordinary failures in the audit log
+func auditResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	var ae *auth.AuthError
+	if errors.As(err, &ae) && ae.Code == auth.ErrRoleDenied {
+		return "denied"
+	}
+	return "error"
+}
+
+func operationErrorTitle(err error) string {
+	if title, _, ok := auth.Describe(err); ok {
+		return title
+	}
+	switch auditResult(err) {
+	case "timeout":
+		return "Your command timed out and was stopped!"
+	case "cancelled":
+		return "Your command was cancelled."
+	}
+	return "There was an error executing your command!"
+}
+
+// operationErrorText explains auth refusals from the remote executor, keeping the host's own wording underneath
+func operationErrorText(err error) string {
+	if _, msg, ok := auth.Describe(err); ok {
+		return msg + "\n\n" + err.Error()
+	}
+	return err.Error()
+}
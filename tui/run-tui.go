@@ -5,12 +5,13 @@ import (
 	"TUFWGo/system/local"
 	"TUFWGo/system/ssh"
 	"fmt"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func RunTUI() {
-	tabs := []string{"General", "IPv6 Rules", "Profile Management", "Settings"}
+	tabs := []string{"General", "IPv6 Rules", "Profile Management", "Audit", "Settings"}
 	var withSSH []string
 	if ssh.GetSSHStatus() {
 		withSSH = []string{"List Current Rules", "Add Rule", "Remove Rule", "Test SSH Connection", "Fail2Ban Dashboard (Coming Soon!)"}
@@ -22,7 +23,8 @@ func RunTUI() {
 		{Items: withSSH},
 		{Items: []string{"Adjust your preferences here.", "Change settings as needed.", "Customize your experience.", "Save your changes."}},
 		{Items: []string{"Create Profile", "Add to Profile", "Import a Profile", "Examine Profiles", "Profile Deployment Center"}},
-		{Items: []string{"Find answers to common questions.", "Contact support if needed.", "Explore tutorials and guides.", "Get the most out of the app."}},
+		{Items: []string{"View Audit Log"}},
+		{Items: []string{"Known Hosts", "Find answers to common questions.", "Contact support if needed.", "Explore tutorials and guides.", "Get the most out of the app."}},
 	}
 
 	m := &TabModel{
@@ -30,10 +32,18 @@ func RunTUI() {
 		TabContent: tabContent,
 	}
 
-	auditor, err := audit.OpenDailyAuditLog()
-	if err != nil {
-		fmt.Println(err)
-		return
+	// Reuse the log if something before the TUI (e.g. host trust on connect) already opened it
+	auditor, _ := audit.GetGlobalAuditor()
+	if auditor == nil {
+		var err error
+		auditor, err = audit.OpenDailyAuditLog()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, p := range auditor.Unsealed() {
+			fmt.Println("WARNING: audit chain is broken:", p)
+		}
 	}
 	m.SetAuditor(auditor, getActor())
 	audit.SetGlobalAuditor(auditor, getActor())
@@ -44,20 +54,12 @@ func RunTUI() {
 	}
 }
 
+// getActor is the operator on the controller. Remote changes add via_ssh=HOST to it, and the
+// host records the change in its own log too (see audit.RecordOnHost).
 func getActor() string {
-	if ssh.GetSSHStatus() {
-		if err := ssh.Checkup(); err != nil {
-			return "Unknown"
-		}
-		remActor, err := ssh.CommandStream("echo \"$(whoami)@$(hostname)\"")
-		if err != nil {
-			return "Unknown"
-		}
-		return remActor
-	}
 	locActor, err := local.RunCommand("echo \"$(whoami)@$(hostname)\"")
 	if err != nil {
 		return "Unknown"
 	}
-	return locActor
+	return strings.TrimSpace(locActor)
 }
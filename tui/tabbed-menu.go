@@ -3,9 +3,11 @@ package tui
 import (
 	"TUFWGo/alert"
 	"TUFWGo/audit"
+	"TUFWGo/auth"
 	"TUFWGo/system/local"
 	"TUFWGo/system/ssh"
 	"TUFWGo/ufw"
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -32,6 +34,10 @@ type TabModel struct {
 	rule        string
 	auditor     *audit.Log
 	actor       string
+	cancelOp    context.CancelFunc
+	opReturn    tea.Model
+	opOutput    chan tea.Msg
+	profCmds    []string
 }
 
 type confirmDeclined struct{ ReturnTo tea.Model }
@@ -66,7 +72,12 @@ func (m *TabModel) SetAuditor(auditor *audit.Log, actor string) {
 	m.actor = actor
 }
 
-func (m *TabModel) auditAdd(action, result, cmd, errMsg string, profcmds []string, extra []audit.Field) {
+func (m *TabModel) auditAdd(action audit.Action, result, cmd, errMsg string, profcmds []string, extra []audit.Field) {
+	m.auditAddPayload(action, result, cmd, errMsg, profcmds, nil, extra)
+}
+
+// auditAddPayload is auditAdd for the events that carry a typed payload
+func (m *TabModel) auditAddPayload(action audit.Action, result, cmd, errMsg string, profcmds []string, payload any, extra []audit.Field) {
 	if m.auditor == nil {
 		return
 	}
@@ -85,6 +96,9 @@ func (m *TabModel) auditAdd(action, result, cmd, errMsg string, profcmds []strin
 		ProfCommand: profcmds,
 		Fields:      extra,
 	}
+	if payload != nil {
+		entry.SetPayload(payload)
+	}
 
 	_ = m.auditor.Append(entry)
 }
@@ -97,6 +111,13 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.child != nil {
 		switch child := msg.(type) {
 		case tea.KeyMsg:
+			if m.cancelOp != nil {
+				// Only cancellation is accepted while an operation is running
+				if child.String() == "x" || child.String() == "esc" {
+					m.cancelOp()
+				}
+				return m, nil
+			}
 			if child.String() == "esc" {
 				m.child = nil
 				return m, nil
@@ -159,51 +180,23 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case FormSubmitted:
-			var err error
+			cmd := m.cmd
+			if !remoteAllows(auth.PermissionForCommand(cmd)) {
+				return m.roleDenied(audit.ActionUFWAdd, cmd, auth.PermissionForCommand(cmd))
+			}
 			if ssh.GetSSHStatus() {
-				if err = sshCheckup(); err != nil {
+				if err := sshCheckup(); err != nil {
 					m.child = newErrorBoxModel("Couldn't connect via SSH!", fmt.Sprint("Unable to connect to SSH server: ", err), m.child)
-					m.auditAdd("ufw.add", "error", m.cmd, err.Error(), nil, nil)
-					return m, nil
-				}
-				_, err = ssh.CommandStream(m.cmd)
-				if err != nil {
-					m.child = newErrorBoxModel("There was an error executing your command!", err.Error(), m.child)
-					m.auditAdd("ufw.add", "error", m.cmd, err.Error(), nil, nil)
+					m.auditAdd(audit.ActionUFWAdd, "error", m.cmd, err.Error(), nil, nil)
 					return m, nil
 				}
-				m.child = newSuccessBoxModel("UFW Rule added remotely:", m.cmd, m.child)
-				m.auditAdd("ufw.add", "success", m.cmd, "", nil, []audit.Field{
-					{Name: "ssh_active", Value: "true"},
-					{Rule: structPass},
+				return m, m.startChange(audit.ActionUFWAdd, "Adding UFW Rule on the remote client…", cmd, func(ctx context.Context, emit func(string)) (string, error) {
+					return ssh.CommandLiveStream(ctx, cmd, "", remoteLines(emit))
 				})
-
-				//Send email alert to admins
-				emailInfo = &alert.EmailInfo{}
-				emailInfo.SendMail("Rule Added", m.cmd, &structPass)
-
-				m.toastUntil = time.Now().Add(5 * time.Second)
-				return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
-			} else {
-				_, err = local.RunCommand(m.cmd)
-				if err != nil {
-					m.child = newErrorBoxModel("There was an error executing your command!", err.Error(), m.child)
-					m.auditAdd("ufw.add", "error", m.cmd, err.Error(), nil, nil)
-					return m, nil
-				}
 			}
-
-			//Send email alert to admins
-			emailInfo = &alert.EmailInfo{}
-			emailInfo.SendMail("Rule Added", m.cmd, &structPass)
-
-			// Show success message for 5 seconds
-			m.child = newSuccessBoxModel("UFW successfully added the following Rule:", m.cmd, nil)
-			m.auditAdd("ufw.add", "success", m.cmd, "", nil, []audit.Field{
-				{Rule: structPass},
+			return m, m.startChange(audit.ActionUFWAdd, "Adding UFW Rule…", cmd, func(ctx context.Context, _ func(string)) (string, error) {
+				return local.RunCommandContext(ctx, cmd)
 			})
-			m.toastUntil = time.Now().Add(5 * time.Second)
-			return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
 		case DeleteConfirmation:
 			delInt, delError := child.number, child.error
 			if delError != nil {
@@ -227,52 +220,43 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.child = newConfirmModel(note, rule, m.child, onYes)
 			return m, nil
 		case DeleteExecuted:
-			var err error
+			cmd := m.cmd
+			if !remoteAllows(auth.PermDelete) {
+				return m.roleDenied(audit.ActionUFWDelete, cmd, auth.PermDelete)
+			}
+			if needsApproval([]string{cmd}) {
+				return m.queueForApproval(audit.ActionUFWDelete, []string{cmd}, m.rule)
+			}
 			if ssh.GetSSHStatus() {
-				if err = sshCheckup(); err != nil {
+				if err := sshCheckup(); err != nil {
 					m.child = newErrorBoxModel("Couldn't connect via SSH!", fmt.Sprint("Unable to connect to SSH server: ", err), m.child)
-					m.auditAdd("ufw.delete", "error", m.cmd, err.Error(), nil, nil)
+					m.auditAdd(audit.ActionUFWDelete, "error", m.cmd, err.Error(), nil, nil)
 					return m, nil
 				}
-				_, err = ssh.ConversationalCommentStream(m.cmd, "y\n")
-				if err != nil {
-					m.child = newErrorBoxModel("There was an error executing your command!", err.Error(), m.child)
-					m.auditAdd("ufw.delete", "error", m.cmd, err.Error(), nil, nil)
-					return m, nil
-				}
-
-				emailInfo = &alert.EmailInfo{}
-				alert.DeleteRule = m.rule
-				emailInfo.SendMail("Rule Deleted", m.cmd, nil)
-
-				m.child = newSuccessBoxModel("UFW Rule deleted remotely:", m.rule, nil)
-				m.auditAdd("ufw.delete", "success", m.cmd, "", nil, []audit.Field{
-					{Name: "ssh_active", Value: "true"},
-					{DeletedRule: m.rule},
+				return m, m.startChange(audit.ActionUFWDelete, "Deleting UFW Rule on the remote client…", m.rule, func(ctx context.Context, emit func(string)) (string, error) {
+					return ssh.CommandLiveStream(ctx, cmd, "y\n", remoteLines(emit))
 				})
-				m.toastUntil = time.Now().Add(5 * time.Second)
-				return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
-			} else {
-				_, err = local.CommandConversation(m.cmd, "y\n")
-				if err != nil {
-					m.child = newErrorBoxModel("There was an error executing your command!", err.Error(), m.child)
-					m.auditAdd("ufw.delete", "error", m.cmd, err.Error(), nil, nil)
-					return m, nil
-				}
 			}
-
-			//Send email alert to admins
-			emailInfo = &alert.EmailInfo{}
-			alert.DeleteRule = m.rule
-			emailInfo.SendMail("Rule Deleted", m.cmd, nil)
-
-			// Show success message for 5 seconds
-			m.child = newSuccessBoxModel("UFW successfully deleted the following Rule:", m.rule, nil)
-			m.auditAdd("ufw.delete", "success", m.cmd, "", nil, []audit.Field{
-				{DeletedRule: m.rule},
+			return m, m.startChange(audit.ActionUFWDelete, "Deleting UFW Rule…", m.rule, func(ctx context.Context, _ func(string)) (string, error) {
+				return local.CommandConversationContext(ctx, cmd, "y\n")
 			})
-			m.toastUntil = time.Now().Add(5 * time.Second)
-			return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
+		case operationOutput:
+			if r, ok := m.child.(*runningModel); ok {
+				r.lines = append(r.lines, child.Line)
+			}
+			return m, listenOperation(m.opOutput)
+		case operationDone:
+			m.cancelOp = nil
+			change := append(audit.RulesetFields(child.Ruleset), child.HostLog...)
+			switch child.Action {
+			case audit.ActionUFWAdd:
+				return m.finishAdd(child.Err, change)
+			case audit.ActionUFWDelete:
+				return m.finishDelete(child.Err, change)
+			case audit.ActionProfileExecute:
+				return m.finishProfile(child.Err, change)
+			}
+			return m, nil
 		case clearToast:
 			if time.Now().After(m.toastUntil) {
 				m.toastUntil = time.Time{}
@@ -284,10 +268,10 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		case ProfCreateAudit:
 			if child.Err != nil {
-				m.auditAdd("profile.create", "error", "", child.Err.Error(), nil, nil)
+				m.auditAdd(audit.ActionProfileCreate, "error", "", child.Err.Error(), nil, nil)
 				return m, nil
 			}
-			m.auditAdd("profile.create", "success", "", "", nil, nil)
+			m.auditAdd(audit.ActionProfileCreate, "success", "", "", nil, nil)
 			return m, nil
 		case ReturnFromProfile:
 			m.child = nil
@@ -305,16 +289,16 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		case ExecuteProfile:
 			cmds := child.RawCommands
-			err := executeProfile(cmds)
-			if err != nil {
-				m.auditAdd("profile.execute", "error", "", err.Error(), cmds, nil)
-				m.child = newErrorBoxModel("There was an error executing your profile", err.Error(), m.child)
-				return m, nil
+			if !remoteAllows(auth.PermProfile) {
+				return m.roleDenied(audit.ActionProfileExecute, strings.Join(cmds, "\n"), auth.PermProfile)
+			}
+			if needsApproval(cmds) {
+				return m.queueForApproval(audit.ActionProfileExecute, cmds, "")
 			}
-			m.auditAdd("profile.execute", "success", "", "", cmds, nil)
-			m.child = newSuccessBoxModel("Profile executed successfully!", "The profile has been executed and the rules have been added to UFW.", nil)
-			m.toastUntil = time.Now().Add(5 * time.Second)
-			return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
+			m.profCmds = cmds
+			return m, m.startChange(audit.ActionProfileExecute, "Executing profile…", strings.Join(cmds, "\n"), func(ctx context.Context, emit func(string)) (string, error) {
+				return "", executeProfileContext(ctx, cmds, emit)
+			})
 		}
 		if time.Now().Before(m.toastUntil) {
 			// Still showing toast, don't process other messages
@@ -356,18 +340,24 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.child = NewModel()
 			m.selected = ""
 		case "Add Rule":
+			if !remoteAllows(auth.PermAdd) {
+				return m.roleDenied(audit.ActionUFWAdd, "", auth.PermAdd)
+			}
 			m.child = initialFormModel()
 			m.selected = ""
 		case "Remove Rule":
+			if !remoteAllows(auth.PermDelete) {
+				return m.roleDenied(audit.ActionUFWDelete, "", auth.PermDelete)
+			}
 			m.child = DeleteList()
 			m.selected = ""
 		case "Test SSH Connection":
 			if err := sshCheckup(); err != nil {
-				m.auditAdd("ssh.test", "error", "SSH Test attempted", err.Error(), nil, nil)
+				m.auditAdd(audit.ActionSSHTest, "error", "SSH Test attempted", err.Error(), nil, nil)
 				m.child = newErrorBoxModel("SSH Connection Failed!", fmt.Sprint("Unable to connect to SSH server: ", err), m.child)
 
 			} else {
-				m.auditAdd("ssh.test", "success", "SSH Test attempted", "", nil, nil)
+				m.auditAdd(audit.ActionSSHTest, "success", "SSH Test attempted", "", nil, nil)
 				m.child = newSuccessBoxModel("SSH Connection Successful!", "You are now connected via SSH!", m.child)
 			}
 			m.selected = ""
@@ -380,11 +370,22 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			m.child.(*profilesFlow).SetAuditorForAP(m.auditor, m.actor)
 		case "Import a Profile":
+			if !remoteAllows(auth.PermProfile) {
+				return m.roleDenied(audit.ActionProfileExecute, "", auth.PermProfile)
+			}
 			m.child = LoadFromProfile()
 			m.selected = ""
 		case "Examine Profiles":
 			m.child = NewExamineFlow()
 			m.selected = ""
+		case "Known Hosts":
+			m.child = NewKnownHostsModel()
+			m.selected = ""
+
+			m.child.(*knownHostsModel).SetAuditorForKH(m.auditor, m.actor)
+		case "View Audit Log":
+			m.child = NewAuditViewerModel()
+			m.selected = ""
 		case "Profile Deployment Center":
 			configDir, err := getConfigDir()
 			workdir := filepath.Join(configDir, "tufwgo", "pdc", "infra")
@@ -449,6 +450,75 @@ func (m *TabModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m *TabModel) finishAdd(err error, change []audit.Field) (tea.Model, tea.Cmd) {
+	if err != nil {
+		m.child = newErrorBoxModel(operationErrorTitle(err), operationErrorText(err), m.opReturn)
+		m.auditAddPayload(audit.ActionUFWAdd, auditResult(err), m.cmd, err.Error(), nil, audit.RuleAdd{Rule: structPass}, change)
+		return m, nil
+	}
+
+	if ssh.GetSSHStatus() {
+		m.child = newSuccessBoxModel("UFW Rule added remotely:", m.cmd, m.opReturn)
+		m.auditAddPayload(audit.ActionUFWAdd, "success", m.cmd, "", nil, audit.RuleAdd{Rule: structPass}, append([]audit.Field{
+			{Name: "ssh_active", Value: "true"},
+		}, change...))
+	} else {
+		// Show success message for 5 seconds
+		m.child = newSuccessBoxModel("UFW successfully added the following Rule:", m.cmd, nil)
+		m.auditAddPayload(audit.ActionUFWAdd, "success", m.cmd, "", nil, audit.RuleAdd{Rule: structPass}, change)
+	}
+
+	//Send email alert to admins
+	emailInfo = &alert.EmailInfo{}
+	emailInfo.SendMail("Rule Added", m.cmd, &structPass)
+
+	m.toastUntil = time.Now().Add(5 * time.Second)
+	return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
+}
+
+func (m *TabModel) finishDelete(err error, change []audit.Field) (tea.Model, tea.Cmd) {
+	if err != nil {
+		m.child = newErrorBoxModel(operationErrorTitle(err), operationErrorText(err), m.opReturn)
+		m.auditAddPayload(audit.ActionUFWDelete, auditResult(err), m.cmd, err.Error(), nil, audit.RuleDelete{Rule: m.rule}, change)
+		return m, nil
+	}
+
+	//Send email alert to admins
+	emailInfo = &alert.EmailInfo{}
+	alert.DeleteRule = m.rule
+	emailInfo.SendMail("Rule Deleted", m.cmd, nil)
+
+	if ssh.GetSSHStatus() {
+		m.child = newSuccessBoxModel("UFW Rule deleted remotely:", m.rule, nil)
+		m.auditAddPayload(audit.ActionUFWDelete, "success", m.cmd, "", nil, audit.RuleDelete{Rule: m.rule}, append([]audit.Field{
+			{Name: "ssh_active", Value: "true"},
+		}, change...))
+	} else {
+		// Show success message for 5 seconds
+		m.child = newSuccessBoxModel("UFW successfully deleted the following Rule:", m.rule, nil)
+		m.auditAddPayload(audit.ActionUFWDelete, "success", m.cmd, "", nil, audit.RuleDelete{Rule: m.rule}, change)
+	}
+	m.toastUntil = time.Now().Add(5 * time.Second)
+	return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
+}
+
+func (m *TabModel) finishProfile(err error, change []audit.Field) (tea.Model, tea.Cmd) {
+	cmds := m.profCmds
+	if err != nil {
+		m.auditAdd(audit.ActionProfileExecute, auditResult(err), "", err.Error(), cmds, change)
+		title := "There was an error executing your profile"
+		if t, _, ok := auth.Describe(err); ok {
+			title = t
+		}
+		m.child = newErrorBoxModel(title, operationErrorText(err), m.opReturn)
+		return m, nil
+	}
+	m.auditAdd(audit.ActionProfileExecute, "success", "", "", cmds, change)
+	m.child = newSuccessBoxModel("Profile executed successfully!", "The profile has been executed and the rules have been added to UFW.", nil)
+	m.toastUntil = time.Now().Add(5 * time.Second)
+	return m, tea.Tick(time.Until(m.toastUntil), func(time.Time) tea.Msg { return clearToast{} })
+}
+
 func sshCheckup() error {
 	if ssh.GlobalClient == nil {
 		return errors.New("SSH Mode is not active")
@@ -519,6 +589,8 @@ func (m *TabModel) View() string {
 		content = m.TabContent[m.activeTab].View() // default: simple menu in this tab
 	}
 
+	content += m.auditWarning()
+
 	if m.Width > 0 && m.Height > 0 {
 		inW := m.Width - docStyle.GetHorizontalFrameSize()
 		if inW < 0 {
@@ -564,6 +636,21 @@ func (m *TabModel) View() string {
 	return docStyle.Render(doc.String())
 }
 
+// auditWarning stays on screen for the whole session while earlier audit logs have breaks
+// nobody has looked at; they are only ever sealed with "tufwgo audit seal"
+func (m *TabModel) auditWarning() string {
+	if m.auditor == nil || len(m.auditor.Unsealed()) == 0 {
+		return ""
+	}
+	broken := m.auditor.Unsealed()
+	msg := fmt.Sprintf("WARNING: the audit chain is broken: %s", broken[0])
+	if len(broken) > 1 {
+		msg += fmt.Sprintf(" (+%d more)", len(broken)-1)
+	}
+	msg += "\nRun 'tufwgo audit verify', keep a copy of the damaged logs, then 'tufwgo audit seal -reason ...'."
+	return "\n" + lipgloss.NewStyle().Foreground(errorColor).Render(msg)
+}
+
 func newConfirmModel(prompt, cmd string, returnTo tea.Model, onYes func() tea.Msg) *confirmModel {
 	return &confirmModel{
 		prompt:   prompt,
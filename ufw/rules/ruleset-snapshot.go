@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Snapshot is the firewall state as "ufw status verbose" reports it. Rules are in ufw's order,
+// so a rule's number is its position plus one.
+type Snapshot struct {
+	Settings []string `json:"settings"`
+	Rules    []string `json:"rules"`
+	SHA256   string   `json:"sha256"`
+}
+
+// RulesetChange is the ruleset captured either side of a change. A side that couldn't be
+// captured has its error set instead.
+type RulesetChange struct {
+	Before    *Snapshot
+	After     *Snapshot
+	BeforeErr error
+	AfterErr  error
+}
+
+// ParseSnapshot reads "ufw status verbose" output. Column padding is collapsed so the hash
+// only changes when the firewall does.
+func ParseSnapshot(out string) *Snapshot {
+	s := &Snapshot{Settings: []string{}, Rules: []string{}}
+	inRules := false
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		line := strings.Join(strings.Fields(sc.Text()), " ")
+		switch {
+		case line == "":
+		case !inRules && strings.HasPrefix(line, "To ") && strings.Contains(line, " Action ") && strings.Contains(line, " From"):
+			inRules = true
+		case !inRules:
+			s.Settings = append(s.Settings, line)
+		case strings.Trim(line, "- ") == "":
+		default:
+			s.Rules = append(s.Rules, line)
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(s.Settings, "\n") + "\n\n" + strings.Join(s.Rules, "\n")))
+	s.SHA256 = hex.EncodeToString(sum[:])
+	return s
+}
+
+// Diff lists the rules and settings that are only in after ("+ ") or only in before ("- ")
+func (s *Snapshot) Diff(after *Snapshot) []string {
+	var diff []string
+	for _, l := range onlyIn(after.Settings, s.Settings) {
+		diff = append(diff, "+ "+l)
+	}
+	for _, l := range onlyIn(s.Settings, after.Settings) {
+		diff = append(diff, "- "+l)
+	}
+	for _, l := range onlyIn(after.Rules, s.Rules) {
+		diff = append(diff, "+ "+l)
+	}
+	for _, l := range onlyIn(s.Rules, after.Rules) {
+		diff = append(diff, "- "+l)
+	}
+	return diff
+}
+
+// onlyIn returns the lines of a that b doesn't have, counting duplicates
+func onlyIn(a, b []string) []string {
+	left := make(map[string]int, len(b))
+	for _, l := range b {
+		left[l]++
+	}
+	var out []string
+	for _, l := range a {
+		if left[l] > 0 {
+			left[l]--
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package ufw
+
+import (
+	"TUFWGo/system/local"
+	"TUFWGo/system/ssh"
+	"TUFWGo/ufw/rules"
+	"context"
+
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+const snapshotCmd = "ufw status verbose"
+
+// Snapshot and RulesetChange live in ufw/rules so the audit log can use them without this package
+type Snapshot = rules.Snapshot
+type RulesetChange = rules.RulesetChange
+
+// CaptureRuleset snapshots the local firewall, or the remote one while SSH is active
+func CaptureRuleset(ctx context.Context) (*Snapshot, error) {
+	if ssh.GetSSHStatus() {
+		return CaptureRulesetOn(ctx, ssh.GlobalClient)
+	}
+	out, err := local.RunCommandContext(ctx, snapshotCmd)
+	if err != nil {
+		return nil, err
+	}
+	return rules.ParseSnapshot(out), nil
+}
+
+func CaptureRulesetOn(ctx context.Context, client *cryptossh.Client) (*Snapshot, error) {
+	out, err := ssh.CommandStreamContextOn(ctx, client, snapshotCmd)
+	if err != nil {
+		return nil, err
+	}
+	return rules.ParseSnapshot(out), nil
+}